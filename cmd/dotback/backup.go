@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/amroessam/dotback/internal/auth/github"
+	"github.com/amroessam/dotback/internal/backup"
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/ignore"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/output"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/amroessam/dotback/internal/restore"
+	"github.com/amroessam/dotback/internal/scan"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [paths...]",
+	Short: "Back up your dotfiles to a private GitHub repository",
+	Long: `Back up your dotfiles and application configurations to a private
+GitHub repository. An interactive wizard asks you to log in if needed,
+select or create a repository, select or create a machine entry for this
+computer and choose which applications to back up.
+
+Without arguments the home directory is scanned, exactly like 'dotback scan'.
+Ignore rules apply, and files that look like they contain secrets are left
+out unless they are listed in "secret_allow" in the config file.
+
+Only files that changed since the machine's last backup are uploaded.
+Files deleted locally are removed from the backup, unless --keep-deleted is
+given. Files of earlier backups that are not selected this time stay in it.
+
+With --format archive the selected files are packed into one compressed
+snapshot, machines/<machine>/snapshots/<time>.tar.gz, with the manifest
+embedded, instead of being stored one by one. Every archive backup uploads
+a complete snapshot.
+
+--dry-run shows the plan of the backup without changing the repository.
+--plan-out saves the plan to a file, and --apply backs up exactly as
+planned, failing if the files or the backup changed in the meantime.
+
+With --yes no questions are asked, so the backup can run from cron or a
+script: the repository comes from --repo or the last backup, the machine
+from --machine, the last backup or the hostname, and every file found is
+backed up. The token is read from the keyring or GITHUB_TOKEN. Without a
+terminal on stdin, --yes is required.
+
+Exit codes:
+  0    Backup succeeded, or there was nothing to back up
+  1    Any other error
+  2    Invalid or missing flags, or input needed without a terminal
+  3    Not logged in, or the GitHub token was rejected
+  4    Scanning for dotfiles failed
+  5    The backup could not be committed; the repository is unchanged
+  6    The plan given to --apply is out of date
+  130  Interrupted`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runBackup(cmd, args, nil, nil); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+// backupOptions holds the flags of the backup command. Each one that is set
+// replaces the matching question of the wizard.
+type backupOptions struct {
+	repo    string
+	machine string
+	paths   []string
+	message string
+	yes     bool
+
+	keepDeleted bool
+	dryRun      bool
+	planOut     string
+	apply       string
+	format      string
+}
+
+var backupFlags backupOptions
+
+func init() {
+	backupCmd.Flags().StringVar(&backupFlags.repo, "repo", "", "Repository to back up to, as owner/name or name")
+	backupCmd.Flags().StringVar(&backupFlags.machine, "machine", "", "Machine name to back up as")
+	backupCmd.Flags().StringSliceVar(&backupFlags.paths, "paths", nil, "Paths to back up, in addition to the arguments")
+	backupCmd.Flags().StringVar(&backupFlags.message, "message", "", "Commit message")
+	backupCmd.Flags().BoolVar(&backupFlags.keepDeleted, "keep-deleted", false, "Keep files deleted locally in the backup")
+	backupCmd.Flags().BoolVar(&backupFlags.dryRun, "dry-run", false, "Show what would be backed up without changing the repository")
+	backupCmd.Flags().StringVar(&backupFlags.planOut, "plan-out", "", "Save the plan to a file instead of backing up; implies --dry-run")
+	backupCmd.Flags().StringVar(&backupFlags.apply, "apply", "", "Back up exactly as planned in a file saved with --plan-out")
+	backupCmd.Flags().StringVar(&backupFlags.format, "format", backup.FormatFiles, "Repository layout: files, or archive for one snapshot per backup")
+	backupCmd.Flags().BoolVarP(&backupFlags.yes, "yes", "y", false, "Back up without asking any questions")
+	rootCmd.AddCommand(backupCmd)
+}
+
+func runBackup(cmd *cobra.Command, args []string, testClient types.GitHubClient, testFS types.FileSystem) error {
+	logger.Info("Starting backup")
+	opts := backupFlags
+
+	// A saved plan already decides everything the other flags would
+	if opts.apply != "" && (len(args) > 0 || len(opts.paths) > 0 || opts.repo != "" || opts.machine != "" ||
+		opts.message != "" || opts.keepDeleted || opts.dryRun || opts.planOut != "" || opts.format == backup.FormatArchive) {
+		return withExitCode(exitUsage, fmt.Errorf("Error: --apply cannot be combined with paths or other backup flags"))
+	}
+
+	if backup.CheckFormat(opts.format) != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Invalid --format %q, expected files or archive", opts.format))
+	}
+
+	// Reading answers from a pipe or /dev/null would block or fail halfway
+	if !opts.yes && !isTerminal(os.Stdin) {
+		return withExitCode(exitUsage, fmt.Errorf("Error: No terminal to ask questions on. Use --yes to back up without prompts"))
+	}
+	prompt := newPrompter()
+
+	configManager, err := config.NewManager()
+	if err != nil {
+		logger.Error("Failed to initialize config manager: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+
+	client, err := githubClient(configManager, prompt, opts.yes, testClient)
+	if err != nil {
+		return err
+	}
+	if opts.apply != "" {
+		return runApplyPlan(configManager, client, testFS, prompt, opts)
+	}
+	return runBackupWizard(configManager, client, testFS, prompt, opts, args)
+}
+
+// githubClient returns a GitHub client for the token in the keyring or in
+// GITHUB_TOKEN. Without a token it offers to log in, unless yes is set and
+// no questions may be asked.
+func githubClient(configManager types.ConfigManager, prompt *prompter, yes bool, testClient types.GitHubClient) (types.GitHubClient, error) {
+	// The keyring is often unavailable to cron jobs, so a failure only
+	// matters when GITHUB_TOKEN is not set either
+	token, keyringErr := configManager.GetToken()
+	if keyringErr != nil {
+		logger.Debug("Failed to read token from keyring: %v", keyringErr)
+	}
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+
+	if token == "" {
+		if keyringErr != nil {
+			logger.Error("Failed to check existing token: %v", keyringErr)
+			return nil, withExitCode(exitAuth, fmt.Errorf("Error: Could not check existing login state"))
+		}
+		if yes {
+			return nil, withExitCode(exitAuth, fmt.Errorf("Error: Not logged in. Run 'dotback login' or set GITHUB_TOKEN"))
+		}
+		fmt.Println("You are not logged in to GitHub.")
+		if ok, err := prompt.confirm("Log in now?", true); err != nil || !ok {
+			return nil, withExitCode(exitAuth, fmt.Errorf("Error: Not logged in. Run 'dotback login' first"))
+		}
+		var err error
+		token, err = prompt.ask("Enter your GitHub Personal Access Token", "")
+		if err != nil || token == "" {
+			logger.Error("No token provided")
+			return nil, withExitCode(exitAuth, fmt.Errorf("Error: GitHub token is required"))
+		}
+		if err := validateAndShowUser(token, testClient); err != nil {
+			return nil, withExitCode(exitAuth, err)
+		}
+		if err := configManager.SetToken(token); err != nil {
+			logger.Error("Failed to store token: %v", err)
+			return nil, fmt.Errorf("Error: Could not store token securely")
+		}
+	}
+
+	var client types.GitHubClient = testClient
+	if client == nil {
+		client = github.NewClient(token)
+	}
+
+	// Fail early with the right exit code rather than at the commit
+	if yes {
+		if _, err := client.GetUser(); err != nil {
+			logger.Error("Token validation failed: %v", err)
+			return nil, withExitCode(exitAuth, fmt.Errorf("Error: GitHub token was rejected"))
+		}
+	}
+	return client, nil
+}
+
+// runBackupWizard runs the steps of the backup once a client is available.
+// Questions answered by opts are skipped.
+func runBackupWizard(configManager types.ConfigManager, client types.GitHubClient, testFS types.FileSystem, prompt *prompter, opts backupOptions, args []string) error {
+	cfg, err := configManager.Load()
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return fmt.Errorf("Error: Could not load configuration")
+	}
+	dirs, err := xdg.Load()
+	if err != nil {
+		logger.Error("Failed to resolve directories: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+
+	if err := backup.CheckSizePolicy(cfg.Backup.SizePolicy); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Invalid backup.size_policy in the config file: %v", err))
+	}
+
+	repo, machine, err := backupTarget(client, prompt, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	previous, err := backup.FetchManifest(client, repo, machine)
+	if err != nil {
+		logger.Error("Failed to read manifest: %v", err)
+		return withExitCode(exitUpload, fmt.Errorf("Error: Could not read the backup of machine %s in %s", machine, repo))
+	}
+
+	fileSystem, files, apps, err := collectBackupFiles(cfg, testFS, append(args, opts.paths...))
+	if err != nil {
+		return err
+	}
+	if opts.yes {
+		apps, _ = scan.GroupFiles(files, apps)
+	} else {
+		files, apps, err = selectApps(prompt, "Select the applications to back up:", files, apps)
+		if err != nil {
+			return err
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("Nothing to back up")
+		return nil
+	}
+
+	// Keep the description and labels when backing up the same machine again
+	state := cfg.Machine
+	if state.Hostname != machine {
+		state = types.Machine{Hostname: machine}
+		if previous != nil {
+			state = previous.Machine
+		}
+	}
+
+	now := time.Now()
+	message := opts.message
+	if message == "" {
+		message = fmt.Sprintf("Backup %s from %s", now.Format("2006-01-02 15:04"), machine)
+	}
+	b := &backup.Backup{
+		Client:      client,
+		FS:          fileSystem,
+		Dirs:        dirs,
+		Repo:        repo,
+		Machine:     machine,
+		Message:     message,
+		Apps:        apps,
+		Labels:      state.Labels,
+		Description: state.Description,
+		Time:        now,
+		Previous:    previous,
+		KeepDeleted: opts.keepDeleted,
+		OnUpload:    printUpload,
+
+		ChunkSize:     cfg.Backup.ChunkSize,
+		LargeFileSize: cfg.Backup.LargeFileSize,
+		SizePolicy:    cfg.Backup.SizePolicy,
+		Format:        opts.format,
+	}
+	plan, err := b.Plan(files)
+	if err != nil {
+		logger.Error("Failed to plan backup: %v", err)
+		return withExitCode(exitUpload, fmt.Errorf("Error: Could not plan the backup to %s", repo))
+	}
+
+	if opts.dryRun || opts.planOut != "" {
+		printPlan(plan)
+		if opts.planOut != "" {
+			if err := backup.WritePlan(opts.planOut, plan); err != nil {
+				logger.Error("Failed to save plan: %v", err)
+				return fmt.Errorf("Error: Could not save the plan to %s", opts.planOut)
+			}
+			fmt.Printf("Plan saved to %s. Run 'dotback backup --apply %s' to back up.\n", opts.planOut, opts.planOut)
+		}
+		return nil
+	}
+
+	printLargeFiles(plan)
+	if !opts.yes {
+		question := fmt.Sprintf("Back up %d files to %s as machine %s?", len(files), repo, machine)
+		if ok, err := prompt.confirm(question, true); err != nil || !ok {
+			fmt.Println("Backup cancelled")
+			return nil
+		}
+	}
+	return applyBackupPlan(configManager, cfg, b, plan)
+}
+
+// runApplyPlan backs up exactly as planned in the file given to --apply
+func runApplyPlan(configManager types.ConfigManager, client types.GitHubClient, testFS types.FileSystem, prompt *prompter, opts backupOptions) error {
+	cfg, err := configManager.Load()
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return fmt.Errorf("Error: Could not load configuration")
+	}
+	plan, err := backup.ReadPlan(opts.apply)
+	if err != nil {
+		logger.Error("Failed to read plan: %v", err)
+		return withExitCode(exitUsage, fmt.Errorf("Error: Could not read the plan in %s", opts.apply))
+	}
+
+	fileSystem := testFS
+	if fileSystem == nil {
+		osFS, err := scan.NewFileSystem(scan.Options{})
+		if err != nil {
+			logger.Error("Failed to initialize file system: %v", err)
+			return fmt.Errorf("Error: Could not initialize scanner")
+		}
+		fileSystem = osFS
+	}
+
+	printPlan(plan)
+	if !opts.yes {
+		if ok, err := prompt.confirm("Apply this plan?", true); err != nil || !ok {
+			fmt.Println("Backup cancelled")
+			return nil
+		}
+	}
+	b := &backup.Backup{Client: client, FS: fileSystem, OnUpload: printUpload}
+	return applyBackupPlan(configManager, cfg, b, plan)
+}
+
+// applyBackupPlan carries out a plan and records the backup in the config
+func applyBackupPlan(configManager types.ConfigManager, cfg *types.Config, b *backup.Backup, plan *backup.Plan) error {
+	result, err := b.Apply(plan)
+	if errors.Is(err, backup.ErrStalePlan) {
+		logger.Error("Backup failed: %v", err)
+		return withExitCode(exitStalePlan, fmt.Errorf("Error: The plan is out of date, nothing was changed in %s. Make a new plan", plan.Repo))
+	}
+	if err != nil {
+		logger.Error("Backup failed: %v", err)
+		return withExitCode(exitUpload, fmt.Errorf("Error: Backup failed, nothing was changed in %s", plan.Repo))
+	}
+
+	cfg.Repository = plan.Repo
+	cfg.Machine = result.Manifest.Machine
+	cfg.LastBackup = time.Now()
+	if result.Commit != "" {
+		cfg.LastBackup = result.Manifest.LastSync
+	}
+	if err := configManager.Save(cfg); err != nil {
+		logger.Error("Failed to save configuration: %v", err)
+		return fmt.Errorf("Error: Backup succeeded but could not record it in the configuration")
+	}
+
+	if result.Commit == "" {
+		logger.Info("Backup of %s in %s is up to date", plan.Machine, plan.Repo)
+		fmt.Printf("Everything is up to date in %s (machine %s), %d files skipped\n", plan.Repo, plan.Machine, result.Skipped)
+		return nil
+	}
+	logger.Info("Backed up %s to %s in commit %s", plan.Machine, plan.Repo, result.Commit)
+	fmt.Printf("Backed up to %s (machine %s): %s\n", plan.Repo, plan.Machine, backupSummary(result))
+	return nil
+}
+
+func printUpload(done, total int, file types.DotFile) {
+	fmt.Printf("[%d/%d] %s\n", done+1, total, file.Path)
+}
+
+// printPlan shows what a plan changes and where
+func printPlan(plan *backup.Plan) {
+	fmt.Printf("Backup plan for machine %s\n", plan.Machine)
+	fmt.Printf("  Repository:     %s (branch %s)\n", plan.Repo, plan.Branch)
+	fmt.Printf("  Machine path:   %s\n", plan.MachinePath)
+	if plan.Format == backup.FormatArchive {
+		fmt.Printf("  Snapshot:       %s\n", plan.Snapshot)
+	}
+	fmt.Printf("  Commit message: %s\n", plan.Message)
+	if plan.UpToDate() {
+		fmt.Printf("Nothing to change, %d files unchanged\n", plan.Skipped)
+		printLargeFiles(plan)
+		return
+	}
+
+	for _, action := range backup.PlanActions {
+		for _, file := range plan.Files {
+			if file.Action != action {
+				continue
+			}
+			switch {
+			case action == backup.ActionDelete:
+				fmt.Printf("  %-7s %s\n", action, file.Path)
+			case file.Chunks > 0:
+				fmt.Printf("  %-7s %s (%s in %d chunks)\n", action, file.Path, output.FormatBytes(file.Size), file.Chunks)
+			default:
+				fmt.Printf("  %-7s %s (%s)\n", action, file.Path, output.FormatBytes(file.Size))
+			}
+		}
+	}
+	summary := fmt.Sprintf("%d to add, %d to update, %d to delete, %d unchanged",
+		plan.Count(backup.ActionAdd), plan.Count(backup.ActionUpdate), plan.Count(backup.ActionDelete), plan.Skipped)
+	if plan.Kept > 0 {
+		summary += fmt.Sprintf(", %d kept after local deletion", plan.Kept)
+	}
+	fmt.Printf("%s; %s to upload\n", summary, output.FormatBytes(plan.TotalBytes))
+	printLargeFiles(plan)
+}
+
+// printLargeFiles reports the files the size policy warned about or left out
+func printLargeFiles(plan *backup.Plan) {
+	for _, warning := range plan.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	for _, path := range plan.Rejected {
+		fmt.Printf("Left out %s: larger than backup.large_file_size\n", path)
+	}
+}
+
+// backupSummary counts what a backup did, e.g. "2 uploaded, 5 skipped, 1
+// removed"
+func backupSummary(result *backup.Result) string {
+	summary := fmt.Sprintf("%d uploaded, %d skipped, %d removed", result.Uploaded, result.Skipped, result.Removed)
+	if result.Kept > 0 {
+		summary += fmt.Sprintf(", %d kept after local deletion", result.Kept)
+	}
+	return summary
+}
+
+// backupTarget returns the repository and machine to back up to, from the
+// flags or by asking. With --yes the last backup's answers are used for
+// anything the flags leave out.
+func backupTarget(client types.GitHubClient, prompt *prompter, cfg *types.Config, opts backupOptions) (string, string, error) {
+	repo := opts.repo
+	if repo == "" && opts.yes {
+		repo = cfg.Repository
+	}
+	if repo == "" && opts.yes {
+		return "", "", withExitCode(exitUsage, fmt.Errorf("Error: No repository to back up to. Use --repo owner/name"))
+	}
+	if repo == "" {
+		var err error
+		if repo, err = selectRepository(client, prompt, cfg.Repository, !opts.dryRun && opts.planOut == ""); err != nil {
+			return "", "", err
+		}
+	}
+
+	machine := opts.machine
+	if machine == "" && opts.yes {
+		machine = cfg.Machine.Hostname
+		if machine == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				logger.Error("Could not get hostname: %v", err)
+				return "", "", withExitCode(exitUsage, fmt.Errorf("Error: No machine name. Use --machine"))
+			}
+			machine = hostname
+		}
+	}
+	if machine == "" {
+		var err error
+		if machine, err = selectMachine(client, prompt, repo, cfg.Machine.Hostname); err != nil {
+			return "", "", err
+		}
+	}
+	if !validMachineName(machine) {
+		return "", "", withExitCode(exitUsage, fmt.Errorf("Error: Invalid machine name %q", machine))
+	}
+	return repo, machine, nil
+}
+
+// selectRepository picks one of the user's private repositories or, if
+// create is set, creates a new one. The repository used last time is the
+// default.
+func selectRepository(client types.GitHubClient, prompt *prompter, last string, create bool) (string, error) {
+	repos, err := client.ListRepositories()
+	if err != nil {
+		logger.Error("Failed to list repositories: %v", err)
+		return "", fmt.Errorf("Error: Could not list repositories")
+	}
+
+	var names []string
+	def := 0
+	for _, repo := range repos {
+		if !repo.Private {
+			continue
+		}
+		if repo.Name == last {
+			def = len(names)
+		}
+		names = append(names, repo.Name)
+	}
+
+	if !create {
+		if len(names) == 0 {
+			return "", withExitCode(exitUsage, fmt.Errorf("Error: You have no private repositories yet. Run 'dotback backup' to create one"))
+		}
+		choice, err := prompt.choose("Select a repository for your backup:", names, def)
+		if err != nil {
+			return "", fmt.Errorf("Error: No repository selected")
+		}
+		return names[choice], nil
+	}
+
+	if len(names) > 0 {
+		options := append(append([]string{}, names...), "Create a new private repository")
+		choice, err := prompt.choose("Select a repository for your backup:", options, def)
+		if err != nil {
+			return "", fmt.Errorf("Error: No repository selected")
+		}
+		if choice < len(names) {
+			return names[choice], nil
+		}
+	} else {
+		fmt.Println("You have no private repositories yet.")
+	}
+
+	name, err := prompt.ask("Name of the new private repository", backup.DefaultRepository)
+	if err != nil || name == "" {
+		return "", fmt.Errorf("Error: No repository selected")
+	}
+	if err := client.CreateRepository(name, "Dotfiles backed up with DotBack", true); err != nil {
+		logger.Error("Failed to create repository: %v", err)
+		return "", fmt.Errorf("Error: Could not create repository %s", name)
+	}
+	logger.Info("Created private repository %s", name)
+	return name, nil
+}
+
+// selectMachine picks a machine already in the repository or creates a new
+// one, suggesting this computer's hostname
+func selectMachine(client types.GitHubClient, prompt *prompter, repo, last string) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Debug("Could not get hostname: %v", err)
+	}
+	if last == "" {
+		last = hostname
+	}
+
+	// A new repository has no machines directory yet
+	machines, err := client.ListFiles(repo, backup.MachinesDir)
+	if err != nil {
+		logger.Debug("No machines found in %s: %v", repo, err)
+		machines = nil
+	}
+
+	if len(machines) > 0 {
+		def := len(machines)
+		for i, machine := range machines {
+			if machine == last {
+				def = i
+			}
+		}
+		options := append(append([]string{}, machines...), "Create a new machine")
+		choice, err := prompt.choose("Select the machine to back up as:", options, def)
+		if err != nil {
+			return "", fmt.Errorf("Error: No machine selected")
+		}
+		if choice < len(machines) {
+			return machines[choice], nil
+		}
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		name, err := prompt.ask("Machine name", last)
+		if err != nil || name == "" {
+			break
+		}
+		if !validMachineName(name) {
+			fmt.Println("Machine names cannot contain slashes")
+			continue
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("Error: No machine selected")
+}
+
+// validMachineName reports whether name can be used as a directory under
+// machines/ in the repository
+func validMachineName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// backupScanOptions applies the scan limits for a backup. A file size limit
+// left unset in the config file is raised to backup.DefaultMaxFileSize, so
+// that the size policy rather than the scan decides about large files.
+// SQLite databases keep scan.DefaultMaxSQLiteSize, as most are live
+// application state.
+func backupScanOptions(cfg *types.Config, matcher *ignore.Matcher) scan.Options {
+	opts := scanOptions(cfg, matcher)
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = backup.DefaultMaxFileSize
+	}
+	return opts
+}
+
+// collectBackupFiles scans for the files to back up and leaves out those
+// that look like they contain secrets
+func collectBackupFiles(cfg *types.Config, testFS types.FileSystem, args []string) (types.FileSystem, []types.DotFile, []types.App, error) {
+	matcher, err := loadIgnore(cfg)
+	if err != nil {
+		logger.Error("Failed to load ignore rules: %v", err)
+		return nil, nil, nil, fmt.Errorf("Error: Could not load ignore rules")
+	}
+	if err := checkSymlinks(cfg); err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	status := newStatusLine()
+	defer status.Clear()
+
+	fileSystem := testFS
+	if fileSystem == nil {
+		opts := backupScanOptions(cfg, matcher)
+		opts.Context = ctx
+		opts.OnProgress = func(progress scan.Progress) {
+			status.Update(formatProgress(progress))
+		}
+		opts.Cache = openHashCache(false)
+		defer saveHashCache(opts.Cache)
+
+		osFS, err := scan.NewFileSystem(opts)
+		if err != nil {
+			logger.Error("Failed to initialize file system: %v", err)
+			return nil, nil, nil, fmt.Errorf("Error: Could not initialize scanner")
+		}
+		fileSystem = osFS
+	}
+
+	logger.Info("Scanning for dotfiles")
+	files, err := fileSystem.FindDotFiles(args)
+	if err != nil {
+		return nil, nil, nil, scanError(err)
+	}
+	apps, err := fileSystem.FindAppConfigs(nil)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, nil, nil, scanError(err)
+		}
+		logger.Error("App detection failed: %v", err)
+		return nil, nil, nil, withExitCode(exitScan, fmt.Errorf("Error: Could not detect applications"))
+	}
+	status.Clear()
+
+	files, blocked := scan.SplitSecrets(files, secretAllowMatcher(cfg))
+	if len(blocked) > 0 {
+		fmt.Printf("Leaving out %d files that look like they contain secrets:\n", len(blocked))
+		for _, file := range blocked {
+			fmt.Printf("  %s (%s)\n", file.Path, strings.Join(file.Secrets, ", "))
+		}
+		fmt.Println("Add them to \"secret_allow\" in the config file to back them up.")
+	}
+	return fileSystem, leaveOutRendered(files), apps, nil
+}
+
+// leaveOutRendered drops the files that a restore rendered from templates,
+// so that the templates stay in the backup instead of their output. The
+// backup keeps them as long as they exist here.
+func leaveOutRendered(files []types.DotFile) []types.DotFile {
+	stateDir, err := config.GetStateDir()
+	if err != nil {
+		logger.Debug("Not checking for rendered files: %v", err)
+		return files
+	}
+	rendered := restore.LoadState(filepath.Join(stateDir, restore.StateFileName)).Rendered()
+	if len(rendered) == 0 {
+		return files
+	}
+
+	kept := make([]types.DotFile, 0, len(files))
+	var left []string
+	for _, file := range files {
+		if rendered[file.Path] {
+			left = append(left, file.Path)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	if len(left) > 0 {
+		fmt.Printf("Leaving out %d files rendered from templates by restore; the backup keeps their templates:\n", len(left))
+		for _, path := range left {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	return kept
+}
+
+// secretAllowMatcher builds the matcher for the secret_allow config list
+func secretAllowMatcher(cfg *types.Config) *ignore.Matcher {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	allow := ignore.NewMatcher(home)
+	allow.AddPatterns("secret_allow", cfg.SecretAllow)
+	return allow
+}
+
+// selectApps lets the user choose applications from a checklist. Files
+// that belong to no application are offered as one "Other" entry.
+func selectApps(prompt *prompter, question string, files []types.DotFile, apps []types.App) ([]types.DotFile, []types.App, error) {
+	grouped, other := scan.GroupFiles(files, apps)
+	if len(grouped) == 0 && len(other) == 0 {
+		return nil, nil, nil
+	}
+
+	var options []string
+	for _, app := range grouped {
+		options = append(options, fmt.Sprintf("%s (%d files)", appLabel(app), len(app.ConfigFiles)))
+	}
+	if len(other) > 0 {
+		options = append(options, fmt.Sprintf("Other (%d files)", len(other)))
+	}
+
+	picked, err := prompt.chooseMany(question, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error: No applications selected")
+	}
+
+	var selectedFiles []types.DotFile
+	var selectedApps []types.App
+	for _, i := range picked {
+		if i == len(grouped) {
+			selectedFiles = append(selectedFiles, other...)
+			continue
+		}
+		selectedApps = append(selectedApps, grouped[i])
+		selectedFiles = append(selectedFiles, grouped[i].ConfigFiles...)
+	}
+	return selectedFiles, selectedApps, nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/auth/github"
+	"github.com/amroessam/dotback/internal/backup"
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/scan"
+)
+
+// setupBackupConfig points the config directory at a temporary directory
+func setupBackupConfig(t *testing.T) *config.Manager {
+	tempDir := t.TempDir()
+	oldGetConfigDir := config.GetConfigDir
+	t.Cleanup(func() { config.GetConfigDir = oldGetConfigDir })
+	config.GetConfigDir = func() (string, error) {
+		return tempDir, nil
+	}
+
+	manager, err := config.NewManager()
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	return manager
+}
+
+func TestRunBackupWizard(t *testing.T) {
+	setupScanHome(t)
+	manager := setupBackupConfig(t)
+
+	client := github.NewMockClient("token", false, "testuser")
+	client.Repos = []types.Repository{
+		{Owner: "testuser", Name: "public-stuff", Private: false},
+		{Owner: "testuser", Name: "dotfiles", Private: true},
+	}
+
+	// Pick the only private repo, name the machine, back up every app and confirm
+	prompt, _ := newTestPrompter("1\nlaptop\n\n\n")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{}, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	if !strings.Contains(out, "Backed up to dotfiles (machine laptop): 2 uploaded, 0 skipped, 0 removed") {
+		t.Errorf("Output = %s", out)
+	}
+
+	if got := string(client.Files["dotfiles/machines/laptop/files/home/.bashrc"]); got != "alias ll='ls -l'\n" {
+		t.Errorf("Uploaded .bashrc = %q", got)
+	}
+	if _, ok := client.Files["dotfiles/machines/laptop/files/config/nvim/init.lua"]; !ok {
+		t.Errorf("init.lua not uploaded: %v", client.Files)
+	}
+	if len(client.Commits) != 1 {
+		t.Errorf("Commits = %v, want a single commit", client.Commits)
+	}
+	if manifest := string(client.Files["dotfiles/machines/laptop/manifest.json"]); !strings.Contains(manifest, `"path": "~/.bashrc"`) {
+		t.Errorf("Manifest = %s", manifest)
+	}
+
+	cfg, err := manager.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Repository != "dotfiles" || cfg.Machine.Hostname != "laptop" {
+		t.Errorf("Recorded repository %q machine %q", cfg.Repository, cfg.Machine.Hostname)
+	}
+	if cfg.LastBackup.IsZero() || !cfg.Machine.LastSync.Equal(cfg.LastBackup) {
+		t.Errorf("LastBackup = %v, LastSync = %v", cfg.LastBackup, cfg.Machine.LastSync)
+	}
+	if len(cfg.Machine.DotFiles) != 2 || cfg.Machine.DotFiles[0].Path != "~/.bashrc" {
+		t.Errorf("Recorded files = %v", cfg.Machine.DotFiles)
+	}
+	if len(cfg.Machine.Apps) != 2 {
+		t.Errorf("Recorded apps = %v", cfg.Machine.Apps)
+	}
+
+	// The second run offers the existing machine and only backs up bash,
+	// which has not changed
+	prompt, asked := newTestPrompter("\n\n1\n\n")
+	out = captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{}, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	if !strings.Contains(asked.String(), "1) laptop") || !strings.Contains(out, "Everything is up to date in dotfiles (machine laptop), 2 files skipped") {
+		t.Errorf("Prompts = %s\nOutput = %s", asked, out)
+	}
+}
+
+func TestRunBackupWizardCreatesRepository(t *testing.T) {
+	setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", false, "testuser")
+
+	// No private repos: accept the default name, then decline the backup
+	prompt, _ := newTestPrompter("\nlaptop\n\nn\n")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{}, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	if len(client.Repos) != 1 || client.Repos[0].Name != "dotfiles" || !client.Repos[0].Private {
+		t.Errorf("Repos = %v", client.Repos)
+	}
+	if !strings.Contains(out, "Backup cancelled") || len(client.Files) != 0 {
+		t.Errorf("Output = %s, files = %v", out, client.Files)
+	}
+}
+
+func TestRunBackupWizardClientFailure(t *testing.T) {
+	setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", true, "testuser")
+
+	prompt, _ := newTestPrompter("")
+	err := runBackupWizard(manager, client, nil, prompt, backupOptions{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Could not list repositories") {
+		t.Errorf("runBackupWizard() error = %v", err)
+	}
+}
+
+// tokenManager overrides the keyring of a config manager
+type tokenManager struct {
+	*config.Manager
+	token string
+	err   error
+}
+
+func (m *tokenManager) GetToken() (string, error) {
+	return m.token, m.err
+}
+
+func TestGitHubClientNonInteractive(t *testing.T) {
+	manager := setupBackupConfig(t)
+
+	tests := []struct {
+		name     string
+		manager  types.ConfigManager
+		envToken string
+		client   types.GitHubClient
+		wantCode int
+	}{
+		{name: "Keyring token", manager: &tokenManager{Manager: manager, token: "stored"}, client: github.NewMockClient("", false, "u"), wantCode: 0},
+		{name: "GITHUB_TOKEN", manager: &tokenManager{Manager: manager}, envToken: "env", client: github.NewMockClient("", false, "u"), wantCode: 0},
+		{name: "Keyring unavailable with GITHUB_TOKEN", manager: &tokenManager{Manager: manager, err: errors.New("no keyring")}, envToken: "env", client: github.NewMockClient("", false, "u"), wantCode: 0},
+		{name: "No token", manager: &tokenManager{Manager: manager}, client: github.NewMockClient("", false, "u"), wantCode: exitAuth},
+		{name: "Rejected token", manager: &tokenManager{Manager: manager, token: "stored"}, client: github.NewMockClient("", true, "u"), wantCode: exitAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_TOKEN", tt.envToken)
+			// An empty prompter fails any question instead of blocking
+			prompt, asked := newTestPrompter("")
+			_, err := githubClient(tt.manager, prompt, true, tt.client)
+			if got := exitCode(err); got != tt.wantCode {
+				t.Errorf("githubClient() error = %v, exit code %d, want %d", err, got, tt.wantCode)
+			}
+			if asked.Len() != 0 {
+				t.Errorf("githubClient() asked %q", asked.String())
+			}
+		})
+	}
+}
+
+func TestRunBackupNonInteractive(t *testing.T) {
+	home := setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", false, "testuser")
+
+	opts := backupOptions{
+		repo:    "someone/dotfiles",
+		machine: "ci",
+		paths:   []string{filepath.Join(home, ".bashrc")},
+		message: "Nightly backup",
+		yes:     true,
+	}
+	prompt, asked := newTestPrompter("")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, opts, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	if asked.Len() != 0 {
+		t.Errorf("runBackupWizard() asked %q", asked.String())
+	}
+	if !strings.Contains(out, "Backed up to someone/dotfiles (machine ci): 1 uploaded, 0 skipped, 0 removed") {
+		t.Errorf("Output = %s", out)
+	}
+	if len(client.Commits) != 1 || client.Commits[0] != "Nightly backup" {
+		t.Errorf("Commits = %v", client.Commits)
+	}
+	if _, ok := client.Files["someone/dotfiles/machines/ci/files/home/.bashrc"]; !ok {
+		t.Errorf("Files = %v", client.Files)
+	}
+
+	// The next run reuses the recorded repository and machine
+	prompt, _ = newTestPrompter("")
+	captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{yes: true}, nil)
+	})
+	if runErr != nil || len(client.Commits) != 2 {
+		t.Fatalf("runBackupWizard() error = %v, commits = %v", runErr, client.Commits)
+	}
+	if _, ok := client.Files["someone/dotfiles/machines/ci/files/config/nvim/init.lua"]; !ok {
+		t.Errorf("Files = %v", client.Files)
+	}
+}
+
+func TestRunBackupUpToDate(t *testing.T) {
+	setupScanHome(t)
+	oldGetConfigDir := config.GetConfigDir
+	t.Cleanup(func() { config.GetConfigDir = oldGetConfigDir })
+	config.GetConfigDir = config.DefaultGetConfigDir
+	manager, err := config.NewManager()
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	client := github.NewMockClient("token", false, "testuser")
+
+	// The config file in the home directory is rewritten by every backup
+	// but is not part of it, so the second backup has nothing to do
+	var out string
+	for i := 0; i < 2; i++ {
+		prompt, _ := newTestPrompter("")
+		var runErr error
+		out = captureStdout(t, func() {
+			runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: "laptop", yes: true}, nil)
+		})
+		if runErr != nil {
+			t.Fatalf("runBackupWizard() error = %v", runErr)
+		}
+	}
+	if len(client.Commits) != 1 || !strings.Contains(out, "Everything is up to date") {
+		t.Errorf("Commits = %v, output:\n%s", client.Commits, out)
+	}
+}
+
+func TestRunBackupNonInteractiveErrors(t *testing.T) {
+	setupScanHome(t)
+
+	tests := []struct {
+		name      string
+		opts      backupOptions
+		client    *github.MockClient
+		configure func(cfg *types.Config)
+		wantCode  int
+	}{
+		{name: "No repository", opts: backupOptions{yes: true}, client: github.NewMockClient("", false, "u"), wantCode: exitUsage},
+		{name: "Invalid machine", opts: backupOptions{yes: true, repo: "dotfiles", machine: "a/b"}, client: github.NewMockClient("", false, "u"), wantCode: exitUsage},
+		{name: "Commit fails", opts: backupOptions{yes: true, repo: "dotfiles", machine: "ci"}, client: github.NewMockClient("", true, "u"), wantCode: exitUpload},
+		{name: "Invalid size policy", opts: backupOptions{yes: true, repo: "dotfiles", machine: "ci"}, client: github.NewMockClient("", false, "u"), configure: func(cfg *types.Config) { cfg.Backup.SizePolicy = "ignore" }, wantCode: exitUsage},
+		{name: "Invalid symlink policy", opts: backupOptions{yes: true, repo: "dotfiles", machine: "ci"}, client: github.NewMockClient("", false, "u"), configure: func(cfg *types.Config) { cfg.Symlinks.Paths = map[string]string{"~/.vimrc": "copy"} }, wantCode: exitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := setupBackupConfig(t)
+			if tt.configure != nil {
+				cfg, _ := manager.Load()
+				tt.configure(cfg)
+				if err := manager.Save(cfg); err != nil {
+					t.Fatalf("Failed to save config: %v", err)
+				}
+			}
+			prompt, _ := newTestPrompter("")
+			var err error
+			captureStdout(t, func() {
+				err = runBackupWizard(manager, tt.client, nil, prompt, tt.opts, nil)
+			})
+			if got := exitCode(err); got != tt.wantCode {
+				t.Errorf("runBackupWizard() error = %v, exit code %d, want %d", err, got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRunBackupDryRunAndApply(t *testing.T) {
+	setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", false, "testuser")
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+
+	opts := backupOptions{repo: "dotfiles", machine: "laptop", message: "Planned backup", yes: true, dryRun: true}
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, opts, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	for _, want := range []string{
+		"Repository:     dotfiles (branch main)",
+		"Machine path:   machines/laptop",
+		"Commit message: Planned backup",
+		"add     ~/.bashrc",
+		"2 to add, 0 to update, 0 to delete, 0 unchanged",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output does not contain %q:\n%s", want, out)
+		}
+	}
+	if len(client.Commits) != 0 || len(client.Files) != 0 {
+		t.Errorf("Dry run changed the repository: %v", client.Files)
+	}
+
+	// Save the plan, then apply it
+	opts.dryRun = false
+	opts.planOut = planPath
+	captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, opts, nil)
+	})
+	if runErr != nil || len(client.Commits) != 0 {
+		t.Fatalf("runBackupWizard() error = %v, commits = %v", runErr, client.Commits)
+	}
+
+	out = captureStdout(t, func() {
+		runErr = runApplyPlan(manager, client, nil, prompt, backupOptions{apply: planPath, yes: true})
+	})
+	if runErr != nil {
+		t.Fatalf("runApplyPlan() error = %v", runErr)
+	}
+	if len(client.Commits) != 1 || client.Commits[0] != "Planned backup" || !strings.Contains(out, "2 uploaded") {
+		t.Errorf("Commits = %v, output = %s", client.Commits, out)
+	}
+
+	// The same plan cannot be applied twice
+	captureStdout(t, func() {
+		runErr = runApplyPlan(manager, client, nil, prompt, backupOptions{apply: planPath, yes: true})
+	})
+	if exitCode(runErr) != exitStalePlan || len(client.Commits) != 1 {
+		t.Errorf("runApplyPlan() error = %v, commits = %v", runErr, client.Commits)
+	}
+}
+
+func TestRunBackupApplyConflicts(t *testing.T) {
+	oldFlags := backupFlags
+	t.Cleanup(func() { backupFlags = oldFlags })
+	backupFlags = backupOptions{apply: "plan.json", repo: "dotfiles"}
+
+	if err := runBackup(nil, nil, nil, nil); exitCode(err) != exitUsage {
+		t.Errorf("runBackup() error = %v, want a usage error", err)
+	}
+}
+
+func TestRunBackupArchive(t *testing.T) {
+	setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", false, "testuser")
+	prompt, _ := newTestPrompter("")
+
+	opts := backupOptions{repo: "dotfiles", machine: "laptop", yes: true, dryRun: true, format: backup.FormatArchive}
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, opts, nil)
+	})
+	if runErr != nil || !strings.Contains(out, "Snapshot:       machines/laptop/snapshots/") {
+		t.Fatalf("runBackupWizard() error = %v, output:\n%s", runErr, out)
+	}
+
+	opts.dryRun = false
+	out = captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, opts, nil)
+	})
+	if runErr != nil || !strings.Contains(out, "2 uploaded") {
+		t.Fatalf("runBackupWizard() error = %v, output:\n%s", runErr, out)
+	}
+	remote, err := backup.OpenRemote(client, "dotfiles", "laptop")
+	if err != nil || remote.Format() != backup.FormatArchive || len(remote.Manifest.DotFiles) != 2 {
+		t.Errorf("OpenRemote() = %+v, %v", remote, err)
+	}
+}
+
+func TestRunBackupLargeFile(t *testing.T) {
+	home := setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", false, "testuser")
+
+	// Larger than the scan's default limit and the default chunk size
+	font := filepath.Join(home, ".fonts", "Big.ttf")
+	content := bytes.Repeat([]byte("\x00font data "), (backup.DefaultChunkSize+scan.DefaultMaxFileSize)/11)
+	if err := os.MkdirAll(filepath.Dir(font), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(font, content, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	// SQLite databases keep their own, lower limit
+	database := append([]byte("SQLite format 3\x00"), bytes.Repeat([]byte("row data "), scan.DefaultMaxSQLiteSize/9+1)...)
+	if err := os.WriteFile(filepath.Join(home, ".history.db"), database, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: "laptop", yes: true}, nil)
+	})
+	if runErr != nil || !strings.Contains(out, "3 uploaded") {
+		t.Fatalf("runBackupWizard() error = %v, output:\n%s", runErr, out)
+	}
+	for _, part := range []string{".part000", ".part001"} {
+		if _, ok := client.Files["dotfiles/machines/laptop/files/home/.fonts/Big.ttf"+part]; !ok {
+			t.Errorf("Missing chunk %s", part)
+		}
+	}
+	if _, ok := client.Files["dotfiles/machines/laptop/files/home/.history.db"]; ok {
+		t.Error("Backed up a SQLite database larger than the SQLite limit")
+	}
+
+	// Restoring joins the chunks again
+	if err := os.Remove(font); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	out = captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", mode: "copy", include: []string{"~/.fonts"}, yes: true})
+	})
+	if runErr != nil {
+		t.Fatalf("runRestoreWizard() error = %v, output:\n%s", runErr, out)
+	}
+	if restored, err := os.ReadFile(font); err != nil || !bytes.Equal(restored, content) {
+		t.Errorf("Restored font has %d bytes, %v, want %d", len(restored), err, len(content))
+	}
+}
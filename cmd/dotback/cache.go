@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/scan"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local hash cache",
+	Long: `DotBack remembers the hash of every file it scans, keyed by the file's
+device, inode, size and modification time, so unchanged files are not
+hashed again. The cache is stored in $XDG_CACHE_HOME/dotback/hash-cache.json,
+which defaults to ~/.cache/dotback/hash-cache.json.`,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached file hashes",
+	Long:  `Remove the hash cache. The next scan hashes every file again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCacheClear(cmd, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheClearCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) error {
+	path, err := hashCachePath()
+	if err != nil {
+		logger.Error("Failed to locate hash cache: %v", err)
+		return fmt.Errorf("Error: Could not locate hash cache")
+	}
+
+	if err := scan.ClearCache(path); err != nil {
+		logger.Error("Failed to clear hash cache: %v", err)
+		return fmt.Errorf("Error: Could not clear hash cache")
+	}
+
+	// Older versions kept the cache in the config directory
+	if configDir, err := config.GetConfigDir(); err == nil {
+		if err := scan.ClearCache(filepath.Join(configDir, scan.CacheFileName)); err != nil {
+			logger.Debug("Failed to remove old hash cache: %v", err)
+		}
+	}
+
+	logger.Info("Cleared hash cache %s", path)
+	fmt.Println("Hash cache cleared")
+	return nil
+}
+
+// hashCachePath returns where the hash cache is stored
+func hashCachePath() (string, error) {
+	cacheDir, err := config.GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, scan.CacheFileName), nil
+}
+
+// openHashCache loads the hash cache, or returns nil when caching is disabled
+// or the cache cannot be located
+func openHashCache(disabled bool) *scan.Cache {
+	if disabled {
+		return nil
+	}
+	path, err := hashCachePath()
+	if err != nil {
+		logger.Debug("Hash cache disabled: %v", err)
+		return nil
+	}
+	return scan.OpenCache(path)
+}
+
+// saveHashCache writes the cache back to disk. Failing to save only costs
+// time on the next scan, so it is logged rather than returned.
+func saveHashCache(cache *scan.Cache) {
+	if cache == nil {
+		return
+	}
+	hits, misses := cache.Stats()
+	logger.Debug("Hash cache: %d hits, %d misses", hits, misses)
+	if err := cache.Save(); err != nil {
+		logger.Error("Failed to save hash cache: %v", err)
+	}
+}
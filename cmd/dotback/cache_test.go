@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/scan"
+)
+
+func TestRunCacheClear(t *testing.T) {
+	tempDir := t.TempDir()
+	oldGetConfigDir := config.GetConfigDir
+	oldGetCacheDir := config.GetCacheDir
+	defer func() {
+		config.GetConfigDir = oldGetConfigDir
+		config.GetCacheDir = oldGetCacheDir
+	}()
+	config.GetConfigDir = func() (string, error) {
+		return filepath.Join(tempDir, "config"), nil
+	}
+	config.GetCacheDir = func() (string, error) {
+		return filepath.Join(tempDir, "cache"), nil
+	}
+
+	cachePath := filepath.Join(tempDir, "cache", scan.CacheFileName)
+	oldCachePath := filepath.Join(tempDir, "config", scan.CacheFileName)
+	for _, path := range []string{cachePath, oldCachePath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(`{"version": 1, "entries": {}}`), 0644); err != nil {
+			t.Fatalf("Failed to write cache: %v", err)
+		}
+	}
+
+	out := captureStdout(t, func() {
+		if err := runCacheClear(cacheClearCmd, nil); err != nil {
+			t.Fatalf("runCacheClear() error = %v", err)
+		}
+	})
+	if !strings.Contains(out, "Hash cache cleared") {
+		t.Errorf("Output = %q", out)
+	}
+	for _, path := range []string{cachePath, oldCachePath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Cache file %s still exists", path)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+)
+
+// Exit codes of commands that run unattended. They are documented in the
+// README, so existing values must not change.
+const (
+	exitFailure     = 1 // Any error without a more specific code
+	exitUsage       = 2 // Invalid or missing flags, or input needed without a terminal
+	exitAuth        = 3 // No GitHub token, or the token was rejected
+	exitScan        = 4 // Scanning for dotfiles failed
+	exitUpload      = 5 // Nothing could be committed to the repository
+	exitStalePlan   = 6 // A saved plan no longer matches the files or the repository
+	exitInterrupted = 130
+)
+
+// exitError attaches an exit code to a user-facing error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode returns err with an exit code attached, or nil if err is nil
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the code the process should exit with after err
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return exitFailure
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "Success", err: nil, want: 0},
+		{name: "Plain error", err: fmt.Errorf("Error: boom"), want: exitFailure},
+		{name: "With code", err: withExitCode(exitAuth, fmt.Errorf("Error: no token")), want: exitAuth},
+		{name: "Wrapped", err: fmt.Errorf("context: %w", withExitCode(exitUsage, fmt.Errorf("bad flag"))), want: exitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if withExitCode(exitScan, nil) != nil {
+		t.Error("withExitCode(nil) should return nil")
+	}
+	if got := withExitCode(exitScan, fmt.Errorf("Error: scan")).Error(); got != "Error: scan" {
+		t.Errorf("Error() = %q", got)
+	}
+}
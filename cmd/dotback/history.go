@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amroessam/dotback/internal/backup"
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/output"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <path>",
+	Short: "List the backed up versions of a file",
+	Long: `List the versions of a file in the backups of a machine, newest first,
+with the commit that first recorded each one. Backups that left the file
+unchanged are not listed.
+
+The path is a file on this computer, such as ~/.bashrc, or a path as it
+appears in the backup, such as '$XDG_CONFIG_HOME/nvim/init.lua'. To put an
+older version back, restore just that file at its commit:
+  dotback restore --at <commit> --include <path>
+
+The repository comes from --repo or the last backup and the machine from
+--machine or this computer. --limit sets how many of the machine's most
+recent backups are searched.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runHistory(cmd, args, nil); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+// historyOptions holds the flags of the history command
+type historyOptions struct {
+	repo    string
+	machine string
+	limit   int
+}
+
+var historyFlags historyOptions
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFlags.repo, "repo", "", "Repository to read, as owner/name or name")
+	historyCmd.Flags().StringVar(&historyFlags.machine, "machine", "", "Machine whose backups to read")
+	historyCmd.Flags().IntVar(&historyFlags.limit, "limit", 50, "Number of recent backups to search")
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistory(cmd *cobra.Command, args []string, testClient types.GitHubClient) error {
+	opts := historyFlags
+	if opts.limit <= 0 {
+		return withExitCode(exitUsage, fmt.Errorf("Error: --limit must be positive"))
+	}
+
+	configManager, err := config.NewManager()
+	if err != nil {
+		logger.Error("Failed to initialize config manager: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+	client, err := githubClient(configManager, newPrompter(), !isTerminal(os.Stdin), testClient)
+	if err != nil {
+		return err
+	}
+	return showHistory(configManager, client, args[0], opts)
+}
+
+// showHistory prints the versions of the file at path
+func showHistory(configManager types.ConfigManager, client types.GitHubClient, path string, opts historyOptions) error {
+	cfg, err := configManager.Load()
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return fmt.Errorf("Error: Could not load configuration")
+	}
+	dirs, err := xdg.Load()
+	if err != nil {
+		logger.Error("Failed to resolve directories: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+
+	repo := opts.repo
+	if repo == "" {
+		if repo = cfg.Repository; repo == "" {
+			return withExitCode(exitUsage, fmt.Errorf("Error: No repository to read. Use --repo owner/name"))
+		}
+	}
+	machine := opts.machine
+	if machine == "" {
+		if machine = cfg.Machine.Hostname; machine == "" {
+			if machine, err = os.Hostname(); err != nil {
+				logger.Debug("Could not get hostname: %v", err)
+			}
+		}
+	}
+	if !validMachineName(machine) {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Invalid machine name %q. Use --machine", machine))
+	}
+
+	portable, err := portablePath(dirs, path)
+	if err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Invalid path %s", path))
+	}
+	versions, err := backup.History(client, repo, machine, portable, opts.limit)
+	if err != nil {
+		logger.Error("Failed to read history: %v", err)
+		return fmt.Errorf("Error: Could not read the history of %s in %s", machine, repo)
+	}
+	if len(versions) == 0 {
+		fmt.Printf("No versions of %s in the last %d backups of %s in %s\n", portable, opts.limit, machine, repo)
+		return nil
+	}
+
+	fmt.Printf("Versions of %s backed up from %s in %s:\n", portable, machine, repo)
+	rows := make([][]string, 0, len(versions))
+	for _, version := range versions {
+		rows = append(rows, []string{
+			shortSHA(version.Commit.SHA),
+			version.Commit.Time.Local().Format("2006-01-02 15:04"),
+			output.FormatBytes(version.File.Size),
+			firstLine(version.Commit.Message),
+		})
+	}
+	if err := output.WriteTable(os.Stdout, []string{"COMMIT", "DATE", "SIZE", "MESSAGE"}, rows); err != nil {
+		logger.Error("Failed to write output: %v", err)
+		return fmt.Errorf("Error: Could not write history")
+	}
+	fmt.Printf("Restore a version with: dotback restore --at <commit> --include '%s'\n", portable)
+	return nil
+}
+
+// portablePath returns the path of a file as it is recorded in backups.
+// Paths already written that way are kept; others are made absolute first.
+func portablePath(dirs xdg.Dirs, path string) (string, error) {
+	resolved := dirs.Resolve(path)
+	if !filepath.IsAbs(resolved) {
+		var err error
+		if resolved, err = filepath.Abs(resolved); err != nil {
+			return "", err
+		}
+	}
+	return dirs.Portable(filepath.Clean(resolved)), nil
+}
+
+// shortSHA abbreviates a commit SHA the way git does
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// firstLine returns the subject of a commit message
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return line
+}
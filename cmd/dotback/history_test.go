@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/auth/github"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+func TestShowHistory(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	first := client.History[0].SHA
+	backupNewVersion(t, home, manager, client, "alias ll='ls -la'\n")
+	second := backupNewVersion(t, home, manager, client, "alias ll='ls -lah'\n")
+
+	// The repository and machine default to those of the last backup
+	var err error
+	out := captureStdout(t, func() {
+		err = showHistory(manager, client, home+"/.bashrc", historyOptions{limit: 50})
+	})
+	if err != nil {
+		t.Fatalf("showHistory() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 6 || !strings.Contains(lines[0], "Versions of ~/.bashrc backed up from laptop in dotfiles") ||
+		!strings.HasPrefix(lines[2], shortSHA(second)) || !strings.HasPrefix(lines[4], shortSHA(first)) ||
+		!strings.Contains(lines[5], "dotback restore --at <commit> --include '~/.bashrc'") {
+		t.Errorf("showHistory() output:\n%s", out)
+	}
+
+	// The nvim config did not change after the first backup
+	out = captureStdout(t, func() {
+		err = showHistory(manager, client, "~/.config/nvim/init.lua", historyOptions{limit: 50})
+	})
+	if err != nil || !strings.Contains(out, "$XDG_CONFIG_HOME/nvim/init.lua") || strings.Count(out, "\n") != 4 {
+		t.Errorf("showHistory() error = %v, output:\n%s", err, out)
+	}
+
+	out = captureStdout(t, func() {
+		err = showHistory(manager, client, "~/.missing", historyOptions{limit: 50})
+	})
+	if err != nil || !strings.Contains(out, "No versions of ~/.missing") {
+		t.Errorf("showHistory() error = %v, output:\n%s", err, out)
+	}
+
+	failing := github.NewMockClient("token", true, "testuser")
+	captureStdout(t, func() {
+		err = showHistory(manager, failing, "~/.bashrc", historyOptions{limit: 50})
+	})
+	if err == nil {
+		t.Error("showHistory() with a failing client should return an error")
+	}
+}
+
+func TestPortablePath(t *testing.T) {
+	t.Setenv("HOME", "/home/user")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	dirs, err := xdg.Load()
+	if err != nil {
+		t.Fatalf("xdg.Load() error = %v", err)
+	}
+	for path, want := range map[string]string{
+		"/home/user/.bashrc":              "~/.bashrc",
+		"~/.bashrc":                       "~/.bashrc",
+		"~/.config/nvim/init.lua":         "$XDG_CONFIG_HOME/nvim/init.lua",
+		"$XDG_CONFIG_HOME/nvim/init.lua":  "$XDG_CONFIG_HOME/nvim/init.lua",
+		"/home/user/.config/git/../tmux/": "$XDG_CONFIG_HOME/tmux",
+		"/etc/hosts":                      "/etc/hosts",
+	} {
+		if got, err := portablePath(dirs, path); err != nil || got != want {
+			t.Errorf("portablePath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errNoInput is returned when stdin is closed before a question is answered
+var errNoInput = errors.New("no input")
+
+// maxAttempts bounds how often an invalid answer is asked again
+const maxAttempts = 3
+
+// prompter asks questions on stdout and reads the answers from stdin
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter() *prompter {
+	return &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+}
+
+// readLine returns the next line without its line ending
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errNoInput
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ask returns the answer to a question, or def when the answer is empty
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// confirm asks a yes/no question
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for i := 0; i < maxAttempts; i++ {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, hint)
+		answer, err := p.readLine()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "Please answer y or n")
+	}
+	return false, fmt.Errorf("no valid answer")
+}
+
+// choose lists the options and returns the index of the one picked. An
+// empty answer picks def.
+func (p *prompter) choose(question string, options []string, def int) (int, error) {
+	fmt.Fprintln(p.out, question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+	}
+	for i := 0; i < maxAttempts; i++ {
+		fmt.Fprintf(p.out, "Enter a number [%d]: ", def+1)
+		answer, err := p.readLine()
+		if err != nil {
+			return 0, err
+		}
+		if answer == "" {
+			return def, nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		fmt.Fprintf(p.out, "Please enter a number between 1 and %d\n", len(options))
+	}
+	return 0, fmt.Errorf("no valid answer")
+}
+
+// chooseMany lists the options and returns the indexes of those picked as a
+// comma-separated list of numbers. An empty answer picks every option.
+func (p *prompter) chooseMany(question string, options []string) ([]int, error) {
+	return p.checklist(question, options, true)
+}
+
+// chooseAny is chooseMany for optional choices: an empty answer picks none
+// of the options
+func (p *prompter) chooseAny(question string, options []string) ([]int, error) {
+	return p.checklist(question, options, false)
+}
+
+// checklist lists the options and returns the indexes of those picked. An
+// empty answer picks every option when all is set and none otherwise.
+func (p *prompter) checklist(question string, options []string, all bool) ([]int, error) {
+	fmt.Fprintln(p.out, question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+	}
+	def := "none"
+	if all {
+		def = "all"
+	}
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		fmt.Fprintf(p.out, "Enter numbers separated by commas [%s]: ", def)
+		answer, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !all && (answer == "" || strings.EqualFold(answer, "none")) {
+			return nil, nil
+		}
+		if answer == "" || strings.EqualFold(answer, "all") {
+			every := make([]int, len(options))
+			for i := range every {
+				every[i] = i
+			}
+			return every, nil
+		}
+		if picked, ok := parseChoices(answer, len(options)); ok {
+			return picked, nil
+		}
+		fmt.Fprintf(p.out, "Please enter numbers between 1 and %d\n", len(options))
+	}
+	return nil, fmt.Errorf("no valid answer")
+}
+
+// parseChoices parses a list such as "1, 3,4" into zero-based indexes
+func parseChoices(answer string, count int) ([]int, bool) {
+	seen := make(map[int]bool)
+	var picked []int
+	for _, field := range strings.Split(answer, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > count {
+			return nil, false
+		}
+		if !seen[n] {
+			seen[n] = true
+			picked = append(picked, n-1)
+		}
+	}
+	return picked, len(picked) > 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestPrompter answers questions from input and records what was asked
+func newTestPrompter(input string) (*prompter, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &prompter{in: bufio.NewReader(strings.NewReader(input)), out: &out}, &out
+}
+
+func TestPrompterAsk(t *testing.T) {
+	p, out := newTestPrompter("\ncustom\n")
+
+	if got, err := p.ask("Name", "default"); err != nil || got != "default" {
+		t.Errorf("ask() = %q, %v, want default", got, err)
+	}
+	if got, err := p.ask("Name", "default"); err != nil || got != "custom" {
+		t.Errorf("ask() = %q, %v, want custom", got, err)
+	}
+	if _, err := p.ask("Name", ""); err != errNoInput {
+		t.Errorf("ask() at EOF error = %v, want errNoInput", err)
+	}
+	if !strings.Contains(out.String(), "Name [default]: ") {
+		t.Errorf("Output = %q", out.String())
+	}
+}
+
+func TestPrompterConfirm(t *testing.T) {
+	p, _ := newTestPrompter("maybe\nyes\n\nN\n")
+
+	if got, err := p.confirm("Continue?", false); err != nil || !got {
+		t.Errorf("confirm() = %v, %v, want true after an invalid answer", got, err)
+	}
+	if got, err := p.confirm("Continue?", true); err != nil || !got {
+		t.Errorf("confirm() = %v, %v, want default true", got, err)
+	}
+	if got, err := p.confirm("Continue?", true); err != nil || got {
+		t.Errorf("confirm() = %v, %v, want false", got, err)
+	}
+}
+
+func TestPrompterChoose(t *testing.T) {
+	p, out := newTestPrompter("7\n2\n\n")
+	options := []string{"one", "two", "three"}
+
+	if got, err := p.choose("Pick:", options, 0); err != nil || got != 1 {
+		t.Errorf("choose() = %d, %v, want 1", got, err)
+	}
+	if got, err := p.choose("Pick:", options, 2); err != nil || got != 2 {
+		t.Errorf("choose() = %d, %v, want default 2", got, err)
+	}
+	if !strings.Contains(out.String(), "  3) three") || !strings.Contains(out.String(), "between 1 and 3") {
+		t.Errorf("Output = %q", out.String())
+	}
+}
+
+func TestPrompterChooseMany(t *testing.T) {
+	p, _ := newTestPrompter("\n3, 1,3\nx\n2\n")
+	options := []string{"one", "two", "three"}
+
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{name: "Empty picks all", want: []int{0, 1, 2}},
+		{name: "List", want: []int{2, 0}},
+		{name: "Invalid then valid", want: []int{1}},
+	}
+	for _, tt := range tests {
+		got, err := p.chooseMany("Pick:", options)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: chooseMany() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestPrompterChooseAny(t *testing.T) {
+	p, _ := newTestPrompter("\nnone\n2,1\nall\n")
+	options := []string{"one", "two", "three"}
+
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{name: "Empty picks none"},
+		{name: "None"},
+		{name: "List", want: []int{1, 0}},
+		{name: "All", want: []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		got, err := p.chooseAny("Pick:", options)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: chooseAny() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amroessam/dotback/internal/backup"
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/amroessam/dotback/internal/restore"
+	"github.com/amroessam/dotback/internal/scan"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore your dotfiles from a backup",
+	Long: `Restore the dotfiles of a machine from your backup repository. An
+interactive wizard asks you to log in if needed and to select the
+repository and the machine, which defaults to this computer's hostname.
+
+Files are downloaded into dotback's store in ~/.local/share/dotback/store
+and linked into place with symlinks, so editing a restored file edits the
+copy in the store. Restoring again updates the copies in the store; one
+that was edited since it was restored is saved first, like a replaced
+file. The restore config can choose another mode per path or
+per application, and --mode uses one mode for every file:
+  symlink   link to the copy in the store (the default)
+  copy      write a regular file
+  hardlink  hard link to the copy in the store
+  render    expand the file as a Go template, e.g. {{ .Hostname }}
+How each file was put in place is recorded in
+~/.local/state/dotback/restored.json.
+
+When a file already exists and differs from the backup, the wizard asks
+whether to overwrite it, skip it, keep both or show a diff first. Keeping
+both renames the existing file with an .orig suffix. Every file that is
+replaced is first saved under ~/.local/state/dotback/conflicts/<timestamp>/,
+which has an index.json listing them. --on-conflict answers for every file:
+  backup  save the existing file and replace it
+  skip    leave the existing file (the default with --yes)
+  fail    leave the existing file and report the file as failed
+
+To restore only some files, --include and --exclude take paths or glob
+patterns, --app takes applications recorded in the backup, such as
+--app nvim,tmux, and --label picks the machine by the labels in its
+manifest, such as --label role=server. Without filters the wizard offers
+the machines' labels, and the backup's applications and other paths, as
+checklists.
+
+--at restores the backup as it was at an earlier point of the repository's
+history. It takes a commit SHA, a tag, a branch or a date, such as
+2024-05-01 or "2024-05-01 14:30", which means the last backup before then.
+To find the backups that changed a file, run dotback history <path>.
+
+With --yes no questions are asked: the repository comes from --repo or the
+last backup and the machine from --machine, the only machine matching
+--label or the hostname.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRestore(cmd, args, nil, nil); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+// restoreOptions holds the flags of the restore command. Each one that is
+// set replaces the matching question of the wizard.
+type restoreOptions struct {
+	repo       string
+	machine    string
+	mode       string
+	onConflict string
+	include    []string
+	exclude    []string
+	apps       []string
+	labels     []string
+	at         string
+	yes        bool
+}
+
+var restoreFlags restoreOptions
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreFlags.repo, "repo", "", "Repository to restore from, as owner/name or name")
+	restoreCmd.Flags().StringVar(&restoreFlags.machine, "machine", "", "Machine whose files to restore")
+	restoreCmd.Flags().StringVar(&restoreFlags.mode, "mode", "", "Restore every file as symlink, copy, hardlink or render")
+	restoreCmd.Flags().StringVar(&restoreFlags.onConflict, "on-conflict", "", "What to do with existing files that differ: backup, skip or fail")
+	restoreCmd.Flags().StringSliceVar(&restoreFlags.include, "include", nil, "Restore only paths matching these globs")
+	restoreCmd.Flags().StringSliceVar(&restoreFlags.exclude, "exclude", nil, "Leave out paths matching these globs")
+	restoreCmd.Flags().StringSliceVar(&restoreFlags.apps, "app", nil, "Restore only the files of these applications, e.g. nvim,tmux")
+	restoreCmd.Flags().StringSliceVar(&restoreFlags.labels, "label", nil, "Restore the machine with these labels, as key=value")
+	restoreCmd.Flags().StringVar(&restoreFlags.at, "at", "", "Restore the backup as it was at a commit, tag or date")
+	restoreCmd.Flags().BoolVarP(&restoreFlags.yes, "yes", "y", false, "Restore without asking any questions")
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runRestore(cmd *cobra.Command, args []string, testClient types.GitHubClient, testFS types.FileSystem) error {
+	logger.Info("Starting restore")
+	opts := restoreFlags
+
+	if err := restore.CheckMode(opts.mode); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: %v", err))
+	}
+	if _, err := conflictResolution(opts.onConflict); err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if _, err := restore.ParseLabels(opts.labels); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: %v", err))
+	}
+	if !opts.yes && !isTerminal(os.Stdin) {
+		return withExitCode(exitUsage, fmt.Errorf("Error: No terminal to ask questions on. Use --yes to restore without prompts"))
+	}
+	prompt := newPrompter()
+
+	configManager, err := config.NewManager()
+	if err != nil {
+		logger.Error("Failed to initialize config manager: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+	client, err := githubClient(configManager, prompt, opts.yes, testClient)
+	if err != nil {
+		return err
+	}
+	return runRestoreWizard(configManager, client, testFS, prompt, opts)
+}
+
+// runRestoreWizard runs the steps of the restore once a client is
+// available. Questions answered by opts are skipped.
+func runRestoreWizard(configManager types.ConfigManager, client types.GitHubClient, testFS types.FileSystem, prompt *prompter, opts restoreOptions) error {
+	cfg, err := configManager.Load()
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return fmt.Errorf("Error: Could not load configuration")
+	}
+	dirs, err := xdg.Load()
+	if err != nil {
+		logger.Error("Failed to resolve directories: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		logger.Error("Failed to get data directory: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+	stateDir, err := config.GetStateDir()
+	if err != nil {
+		logger.Error("Failed to get state directory: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+	resolution, err := conflictResolution(opts.onConflict)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	labels, err := restore.ParseLabels(opts.labels)
+	if err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: %v", err))
+	}
+	modesConfig := cfg.Restore
+	if opts.mode != "" {
+		modesConfig = types.RestoreConfig{Mode: opts.mode}
+	}
+
+	repo, machine, err := restoreTarget(client, prompt, cfg, opts, labels)
+	if err != nil {
+		return err
+	}
+	source, err := restoreSource(client, repo, opts.at)
+	if err != nil {
+		return err
+	}
+	remote, err := backup.OpenRemote(source, repo, machine)
+	if errors.Is(err, types.ErrNotFound) && opts.at != "" {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Machine %s has no backup in %s at %s", machine, repo, opts.at))
+	}
+	if errors.Is(err, types.ErrNotFound) {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Machine %s has no backup in %s", machine, repo))
+	}
+	if err != nil {
+		logger.Error("Failed to read backup: %v", err)
+		return fmt.Errorf("Error: Could not read the backup of machine %s in %s", machine, repo)
+	}
+	modes, err := restore.NewModes(modesConfig, dirs, remote.Manifest.Apps)
+	if err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Invalid restore config: %v", err))
+	}
+	if !restore.MatchLabels(remote.Manifest.Machine, labels) {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Machine %s does not have the labels %s", machine, formatLabels(labels)))
+	}
+	files, err := selectRestoreFiles(prompt, remote.Manifest, dirs, opts)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("Nothing to restore")
+		return nil
+	}
+
+	fileSystem := testFS
+	if fileSystem == nil {
+		osFS, err := scan.NewFileSystem(scan.Options{})
+		if err != nil {
+			logger.Error("Failed to initialize file system: %v", err)
+			return fmt.Errorf("Error: Could not initialize file system")
+		}
+		fileSystem = osFS
+	}
+
+	if !opts.yes {
+		question := fmt.Sprintf("Restore %d files into %s?", len(files), dirs.Home)
+		if ok, err := prompt.confirm(question, true); err != nil || !ok {
+			fmt.Println("Restore cancelled")
+			return nil
+		}
+	}
+
+	r := &restore.Restore{
+		Source:    remote,
+		FS:        fileSystem,
+		Dirs:      dirs,
+		Machine:   machine,
+		Store:     filepath.Join(dataDir, restore.StoreDir),
+		Modes:     modes,
+		Conflicts: filepath.Join(stateDir, restore.ConflictsDir, restore.ConflictDirName(time.Now())),
+		State:     restore.LoadState(filepath.Join(stateDir, restore.StateFileName)),
+		OnFile:    printRestoreProgress,
+	}
+	switch {
+	case resolution != "":
+		r.OnConflict = func(restore.Conflict) (restore.Resolution, error) { return resolution, nil }
+	case !opts.yes:
+		r.OnConflict = func(conflict restore.Conflict) (restore.Resolution, error) {
+			return askConflict(prompt, conflict)
+		}
+	}
+	result := r.Run(files)
+	recordRestore(stateDir, result, repo, machine)
+	printRestoreResult(machine, repo, result, r.Conflicts)
+	if failed := result.Count(restore.StatusFailed); failed > 0 {
+		return fmt.Errorf("Error: %d files could not be restored", failed)
+	}
+	return nil
+}
+
+// restoreSource returns the client to read the backup with: one reading
+// the repository at the commit --at refers to, or client itself without it
+func restoreSource(client types.GitHubClient, repo, at string) (types.GitHubClient, error) {
+	if at == "" {
+		return client, nil
+	}
+	commit, err := backup.ResolveAt(client, repo, at)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, withExitCode(exitUsage, fmt.Errorf("Error: No backup at %s in %s", at, repo))
+	}
+	if err != nil {
+		logger.Error("Failed to resolve %s: %v", at, err)
+		return nil, fmt.Errorf("Error: Could not read the history of %s", repo)
+	}
+	fmt.Printf("Restoring from commit %s of %s: %s\n", shortSHA(commit.SHA), commit.Time.Local().Format("2006-01-02 15:04"), firstLine(commit.Message))
+	return backup.AtCommit(client, commit.SHA), nil
+}
+
+// selectRestoreFiles returns the files of the backup to restore: those
+// matching the filters given as flags or, without any, those of the
+// applications and paths picked from a checklist
+func selectRestoreFiles(prompt *prompter, manifest *backup.Manifest, dirs xdg.Dirs, opts restoreOptions) ([]types.DotFile, error) {
+	files := manifest.DotFiles
+	fmt.Printf("Machine %s has %d files, backed up %s\n", manifest.Hostname, len(files), manifest.LastSync.Local().Format("2006-01-02 15:04"))
+
+	selection := restore.Selection{Include: opts.include, Exclude: opts.exclude, Apps: opts.apps}
+	if !selection.Empty() {
+		selected, err := selection.Filter(files, manifest.Apps, dirs)
+		if err != nil {
+			return nil, withExitCode(exitUsage, fmt.Errorf("Error: %v", err))
+		}
+		fmt.Printf("Selected %d of %d files\n", len(selected), len(files))
+		return selected, nil
+	}
+	if opts.yes {
+		return files, nil
+	}
+	choices := restoreChoices(files, manifest.Apps)
+	if len(choices) < 2 {
+		return files, nil
+	}
+
+	options := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		options = append(options, fmt.Sprintf("%s (%d files)", choice.label, len(choice.files)))
+	}
+	picked, err := prompt.chooseMany("Select the applications and paths to restore:", options)
+	if err != nil {
+		return nil, fmt.Errorf("Error: No files selected")
+	}
+	var selected []types.DotFile
+	for _, i := range picked {
+		selected = append(selected, choices[i].files...)
+	}
+	return selected, nil
+}
+
+// restoreChoice is an entry of the restore checklist
+type restoreChoice struct {
+	label string
+	files []types.DotFile
+}
+
+// restoreChoices groups files for the restore checklist: the files of each
+// application, then the remaining files by their top-level path, such as
+// ~/.ssh for ~/.ssh/config
+func restoreChoices(files []types.DotFile, apps []types.App) []restoreChoice {
+	grouped, other := scan.GroupFiles(files, apps)
+	var choices []restoreChoice
+	for _, app := range grouped {
+		choices = append(choices, restoreChoice{label: appLabel(app), files: app.ConfigFiles})
+	}
+	index := make(map[string]int)
+	for _, file := range other {
+		top := file.Path
+		if parts := strings.SplitN(file.Path, "/", 3); len(parts) == 3 {
+			top = parts[0] + "/" + parts[1]
+		}
+		i, ok := index[top]
+		if !ok {
+			i = len(choices)
+			index[top] = i
+			choices = append(choices, restoreChoice{label: top})
+		}
+		choices[i].files = append(choices[i].files, file)
+	}
+	return choices
+}
+
+// recordRestore adds the restored files to the record of how each file was
+// put in place. The files are already restored, so a failure is only
+// reported.
+func recordRestore(stateDir string, result *restore.Result, repo, machine string) {
+	path := filepath.Join(stateDir, restore.StateFileName)
+	state := restore.LoadState(path)
+	state.Record(result, repo, machine, time.Now())
+	if err := state.Save(path); err != nil {
+		logger.Error("Failed to save restore state: %v", err)
+		fmt.Printf("Warning: Could not record the restored files in %s\n", path)
+	}
+}
+
+func printRestoreProgress(done, total int, file types.DotFile) {
+	fmt.Printf("[%d/%d] %s\n", done+1, total, file.Path)
+}
+
+// printRestoreResult lists the files that were not restored and counts
+// what happened to every file
+func printRestoreResult(machine, repo string, result *restore.Result, conflicts string) {
+	saved := 0
+	for _, file := range result.Files {
+		if file.Saved != "" {
+			saved++
+		}
+		for _, warning := range file.Warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+		if file.Status != restore.StatusRestored {
+			fmt.Printf("  %-7s %s: %s\n", file.Status, file.Path, file.Reason)
+		}
+	}
+	fmt.Printf("Restored machine %s from %s: %d restored, %d skipped, %d failed\n", machine, repo,
+		result.Count(restore.StatusRestored), result.Count(restore.StatusSkipped), result.Count(restore.StatusFailed))
+	if saved > 0 {
+		fmt.Printf("Saved %d replaced files to %s\n", saved, conflicts)
+	}
+}
+
+// conflictResolution returns the resolution chosen with --on-conflict, or
+// "" to ask about each conflict
+func conflictResolution(onConflict string) (restore.Resolution, error) {
+	switch onConflict {
+	case "":
+		return "", nil
+	case "backup":
+		return restore.ResolveOverwrite, nil
+	case "skip":
+		return restore.ResolveSkip, nil
+	case "fail":
+		return restore.ResolveFail, nil
+	}
+	return "", fmt.Errorf("Error: Invalid --on-conflict %q, expected backup, skip or fail", onConflict)
+}
+
+// askConflict asks what to do with an existing file that differs from the
+// restored one, showing the diff as often as asked
+func askConflict(prompt *prompter, conflict restore.Conflict) (restore.Resolution, error) {
+	options := []string{
+		"Overwrite it (the existing file is saved first)",
+		"Skip it",
+		"Keep both (the existing file is renamed to " + filepath.Base(conflict.Target) + ".orig)",
+		"Show the differences",
+	}
+	resolutions := []restore.Resolution{restore.ResolveOverwrite, restore.ResolveSkip, restore.ResolveKeepBoth}
+	question := fmt.Sprintf("%s already exists and differs from the backup:", conflict.Target)
+	for {
+		choice, err := prompt.choose(question, options, 1)
+		if err != nil {
+			return restore.ResolveSkip, nil
+		}
+		if choice < len(resolutions) {
+			return resolutions[choice], nil
+		}
+		fmt.Fprint(prompt.out, conflict.Diff())
+	}
+}
+
+// restoreTarget returns the repository and machine to restore from, from
+// the flags or by asking. With --yes the last backup's repository and the
+// hostname are used for anything the flags leave out.
+func restoreTarget(client types.GitHubClient, prompt *prompter, cfg *types.Config, opts restoreOptions, labels map[string]string) (string, string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Debug("Could not get hostname: %v", err)
+	}
+
+	repo := opts.repo
+	if repo == "" && opts.yes {
+		if repo = cfg.Repository; repo == "" {
+			return "", "", withExitCode(exitUsage, fmt.Errorf("Error: No repository to restore from. Use --repo owner/name"))
+		}
+	}
+	if repo == "" {
+		if repo, err = selectRepository(client, prompt, cfg.Repository, false); err != nil {
+			return "", "", err
+		}
+	}
+
+	machine := opts.machine
+	if machine == "" && opts.yes && len(labels) == 0 {
+		if machine = hostname; machine == "" {
+			return "", "", withExitCode(exitUsage, fmt.Errorf("Error: No machine name. Use --machine"))
+		}
+	}
+	if machine == "" {
+		if machine, err = selectRestoreMachine(client, prompt, repo, hostname, labels, opts.yes); err != nil {
+			return "", "", err
+		}
+	}
+	if !validMachineName(machine) {
+		return "", "", withExitCode(exitUsage, fmt.Errorf("Error: Invalid machine name %q", machine))
+	}
+	return repo, machine, nil
+}
+
+// selectRestoreMachine picks one of the machines backed up in the
+// repository that have the wanted labels. The machine named after this
+// computer is the default; without prompts it is picked if it matches,
+// and otherwise only a single match is.
+func selectRestoreMachine(client types.GitHubClient, prompt *prompter, repo, hostname string, labels map[string]string, yes bool) (string, error) {
+	machines, err := client.ListFiles(repo, backup.MachinesDir)
+	if err != nil {
+		logger.Debug("No machines found in %s: %v", repo, err)
+	}
+	if len(machines) == 0 {
+		return "", withExitCode(exitUsage, fmt.Errorf("Error: No machines are backed up in %s", repo))
+	}
+	if len(labels) > 0 {
+		var matching []string
+		found := fetchMachines(client, repo, machines)
+		for _, machine := range machines {
+			if info, ok := found[machine]; ok && restore.MatchLabels(info, labels) {
+				matching = append(matching, machine)
+			}
+		}
+		if len(matching) == 0 {
+			return "", withExitCode(exitUsage, fmt.Errorf("Error: No machines in %s have the labels %s", repo, formatLabels(labels)))
+		}
+		machines = matching
+	}
+	if len(labels) == 0 && !yes && len(machines) > 1 {
+		if machines, err = selectMachineLabels(client, prompt, repo, machines); err != nil {
+			return "", err
+		}
+	}
+
+	def := 0
+	for i, machine := range machines {
+		if machine == hostname {
+			def = i
+		}
+	}
+	if yes {
+		if machines[def] == hostname || len(machines) == 1 {
+			return machines[def], nil
+		}
+		return "", withExitCode(exitUsage, fmt.Errorf("Error: Machines %s all match. Use --machine", strings.Join(machines, ", ")))
+	}
+	choice, err := prompt.choose("Select the machine to restore:", machines, def)
+	if err != nil {
+		return "", fmt.Errorf("Error: No machine selected")
+	}
+	return machines[choice], nil
+}
+
+// fetchMachines returns the machine recorded in the manifest of each of
+// the machines, leaving out those whose manifest cannot be read
+func fetchMachines(client types.GitHubClient, repo string, machines []string) map[string]types.Machine {
+	found := make(map[string]types.Machine, len(machines))
+	for _, machine := range machines {
+		manifest, err := backup.FetchManifest(client, repo, machine)
+		if err != nil {
+			logger.Debug("Skipping machine %s: %v", machine, err)
+			continue
+		}
+		if manifest == nil {
+			logger.Debug("Skipping machine %s, which has no manifest", machine)
+			continue
+		}
+		found[machine] = manifest.Machine
+	}
+	return found
+}
+
+// selectMachineLabels offers the labels of the machines as a checklist and
+// returns the machines that have one of the picked values for every picked
+// label. Picking none keeps every machine.
+func selectMachineLabels(client types.GitHubClient, prompt *prompter, repo string, machines []string) ([]string, error) {
+	found := fetchMachines(client, repo, machines)
+	seen := make(map[string]bool)
+	var options []string
+	for _, info := range found {
+		for key, value := range info.Labels {
+			if option := key + "=" + value; !seen[option] {
+				seen[option] = true
+				options = append(options, option)
+			}
+		}
+	}
+	if len(options) == 0 {
+		return machines, nil
+	}
+	sort.Strings(options)
+
+	picked, err := prompt.chooseAny("Select labels to narrow down the machines, or none for all:", options)
+	if err != nil {
+		return nil, fmt.Errorf("Error: No labels selected")
+	}
+	if len(picked) == 0 {
+		return machines, nil
+	}
+	wanted := make(map[string]map[string]bool)
+	var pairs []string
+	for _, i := range picked {
+		key, value, _ := strings.Cut(options[i], "=")
+		if wanted[key] == nil {
+			wanted[key] = make(map[string]bool)
+		}
+		wanted[key][value] = true
+		pairs = append(pairs, options[i])
+	}
+
+	var matching []string
+	for _, machine := range machines {
+		info, ok := found[machine]
+		if !ok {
+			continue
+		}
+		match := true
+		for key, values := range wanted {
+			if value, ok := info.Labels[key]; !ok || !values[value] {
+				match = false
+			}
+		}
+		if match {
+			matching = append(matching, machine)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, withExitCode(exitUsage, fmt.Errorf("Error: No machines in %s have the labels %s", repo, strings.Join(pairs, ", ")))
+	}
+	return matching, nil
+}
+
+// formatLabels lists labels as sorted key=value pairs
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/auth/github"
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/restore"
+)
+
+// setupRestoreBackup backs up the test home as machine "laptop" and
+// removes the backed up files, leaving a home to restore into
+func setupRestoreBackup(t *testing.T) (string, *config.Manager, *github.MockClient) {
+	home := setupScanHome(t)
+	manager := setupBackupConfig(t)
+	client := github.NewMockClient("token", false, "testuser")
+
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: "laptop", yes: true}, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	for _, path := range []string{".bashrc", ".config/nvim/init.lua"} {
+		if err := os.Remove(filepath.Join(home, path)); err != nil {
+			t.Fatalf("Failed to remove %s: %v", path, err)
+		}
+	}
+	return home, manager, client
+}
+
+// backupNewVersion backs up the test home again with new content for
+// .bashrc and removes the backed up files. It returns the commit.
+func backupNewVersion(t *testing.T, home string, manager *config.Manager, client *github.MockClient, bashrc string) string {
+	for path, content := range map[string]string{".bashrc": bashrc, ".config/nvim/init.lua": "vim.opt.number = true\n"} {
+		if err := os.WriteFile(filepath.Join(home, path), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: "laptop", yes: true}, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runBackupWizard() error = %v", runErr)
+	}
+	for _, path := range []string{".bashrc", ".config/nvim/init.lua"} {
+		if err := os.Remove(filepath.Join(home, path)); err != nil {
+			t.Fatalf("Failed to remove %s: %v", path, err)
+		}
+	}
+	return client.History[len(client.History)-1].SHA
+}
+
+func TestRunRestoreWizard(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte("# mine\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Pick the machine, restore every application and confirm
+	prompt, asked := newTestPrompter("1\n\n\n")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles"})
+	})
+	if runErr != nil {
+		t.Fatalf("runRestoreWizard() error = %v, output:\n%s", runErr, out)
+	}
+	if !strings.Contains(asked.String(), "laptop") {
+		t.Errorf("Machines offered = %q", asked.String())
+	}
+	if !strings.Contains(out, "Restored machine laptop from dotfiles: 1 restored, 1 skipped, 0 failed") ||
+		!strings.Contains(out, "skipped ~/.bashrc: already exists") {
+		t.Errorf("Output = %s", out)
+	}
+
+	initLua := filepath.Join(home, ".config/nvim/init.lua")
+	target, err := os.Readlink(initLua)
+	if err != nil || !strings.HasPrefix(target, filepath.Join(home, ".local/share/dotback/store")) {
+		t.Errorf("init.lua links to %q, %v", target, err)
+	}
+	if content, _ := os.ReadFile(initLua); string(content) != "vim.opt.number = true\n" {
+		t.Errorf("init.lua = %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".bashrc")); string(content) != "# mine\n" {
+		t.Errorf("Existing .bashrc was overwritten: %q", content)
+	}
+}
+
+func TestRunRestoreNonInteractive(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+
+	// The repository comes from the last backup
+	prompt, asked := newTestPrompter("")
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{machine: "laptop", yes: true})
+	})
+	if runErr != nil || asked.Len() != 0 {
+		t.Fatalf("runRestoreWizard() error = %v, asked %q", runErr, asked.String())
+	}
+	if !strings.Contains(out, "2 restored, 0 skipped, 0 failed") {
+		t.Errorf("Output = %s", out)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".bashrc")); string(content) != "alias ll='ls -l'\n" {
+		t.Errorf(".bashrc = %q", content)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts restoreOptions
+	}{
+		{name: "Unknown machine", opts: restoreOptions{repo: "dotfiles", machine: "desktop", yes: true}},
+		{name: "Invalid machine", opts: restoreOptions{repo: "dotfiles", machine: "a/b", yes: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			captureStdout(t, func() {
+				err = runRestoreWizard(manager, client, nil, prompt, tt.opts)
+			})
+			if exitCode(err) != exitUsage {
+				t.Errorf("runRestoreWizard() error = %v, want a usage error", err)
+			}
+		})
+	}
+}
+
+func TestRunRestoreModes(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	cfg, err := manager.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg.Restore.Paths = map[string]string{"~/.config/nvim": restore.ModeCopy}
+	if err := manager.Save(cfg); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", yes: true})
+	})
+	if runErr != nil {
+		t.Fatalf("runRestoreWizard() error = %v", runErr)
+	}
+	if info, err := os.Lstat(filepath.Join(home, ".config/nvim/init.lua")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("init.lua = %v, %v, want a copy", info, err)
+	}
+	if info, err := os.Lstat(filepath.Join(home, ".bashrc")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf(".bashrc = %v, %v, want a symlink", info, err)
+	}
+
+	state := restore.LoadState(filepath.Join(home, ".local/state/dotback", restore.StateFileName))
+	modes := map[string]string{}
+	for _, file := range state.Files {
+		modes[file.Path] = file.Mode
+		if err := file.Check(); err != nil {
+			t.Errorf("Check(%s) error = %v", file.Path, err)
+		}
+	}
+	if modes["~/.bashrc"] != restore.ModeSymlink || modes["$XDG_CONFIG_HOME/nvim/init.lua"] != restore.ModeCopy {
+		t.Errorf("Recorded modes = %v", modes)
+	}
+
+	// --mode replaces the config for every file
+	os.Remove(filepath.Join(home, ".bashrc"))
+	captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", mode: restore.ModeCopy, yes: true})
+	})
+	if info, err := os.Lstat(filepath.Join(home, ".bashrc")); runErr != nil || err != nil || !info.Mode().IsRegular() {
+		t.Errorf("runRestoreWizard() error = %v, .bashrc = %v, want a copy", runErr, info)
+	}
+
+	restoreFlags = restoreOptions{mode: "move", yes: true}
+	defer func() { restoreFlags = restoreOptions{} }()
+	if err := runRestore(nil, nil, client, nil); exitCode(err) != exitUsage {
+		t.Errorf("runRestore() with an invalid mode error = %v, want a usage error", err)
+	}
+}
+
+func TestRunRestoreConflicts(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	bashrc := filepath.Join(home, ".bashrc")
+	writeMine := func() {
+		os.Remove(bashrc)
+		if err := os.WriteFile(bashrc, []byte("# mine\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	writeMine()
+
+	// Restore every application, confirm, show the diff, then keep both
+	prompt, asked := newTestPrompter("\n\n4\n3\n")
+	opts := restoreOptions{repo: "dotfiles", machine: "laptop", mode: restore.ModeCopy}
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, opts)
+	})
+	if runErr != nil {
+		t.Fatalf("runRestoreWizard() error = %v", runErr)
+	}
+	if !strings.Contains(asked.String(), "-# mine\n+alias ll='ls -l'\n") {
+		t.Errorf("Prompts = %s", asked.String())
+	}
+	if content, _ := os.ReadFile(bashrc + ".orig"); string(content) != "# mine\n" {
+		t.Errorf("Kept .bashrc = %q", content)
+	}
+	if !strings.Contains(out, "Saved 1 replaced files to "+filepath.Join(home, ".local/state/dotback/conflicts")) {
+		t.Errorf("Output = %s", out)
+	}
+
+	tests := []struct {
+		onConflict string
+		wantErr    bool
+		want       string
+	}{
+		{onConflict: "skip", want: "# mine\n"},
+		{onConflict: "fail", wantErr: true, want: "# mine\n"},
+		{onConflict: "backup", want: "alias ll='ls -l'\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.onConflict, func(t *testing.T) {
+			writeMine()
+			opts.onConflict, opts.yes = tt.onConflict, true
+			var err error
+			out := captureStdout(t, func() {
+				err = runRestoreWizard(manager, client, nil, prompt, opts)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runRestoreWizard() error = %v, output:\n%s", err, out)
+			}
+			if content, _ := os.ReadFile(bashrc); string(content) != tt.want {
+				t.Errorf(".bashrc = %q, want %q", content, tt.want)
+			}
+		})
+	}
+
+	restoreFlags = restoreOptions{onConflict: "ask", yes: true}
+	defer func() { restoreFlags = restoreOptions{} }()
+	if err := runRestore(nil, nil, client, nil); exitCode(err) != exitUsage {
+		t.Errorf("runRestore() with an invalid --on-conflict error = %v, want a usage error", err)
+	}
+}
+
+func TestRunRestoreSelection(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	bashrc, initLua := filepath.Join(home, ".bashrc"), filepath.Join(home, ".config/nvim/init.lua")
+	restored := func() (bool, bool) {
+		_, bashErr := os.Lstat(bashrc)
+		_, nvimErr := os.Lstat(initLua)
+		return bashErr == nil, nvimErr == nil
+	}
+	reset := func() {
+		os.Remove(bashrc)
+		os.Remove(initLua)
+	}
+
+	// Pick nvim from the checklist
+	prompt, asked := newTestPrompter("1\n\n")
+	var runErr error
+	captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop"})
+	})
+	if runErr != nil || !strings.Contains(asked.String(), "Select the applications and paths to restore:") {
+		t.Fatalf("runRestoreWizard() error = %v, asked:\n%s", runErr, asked.String())
+	}
+	if gotBash, gotNvim := restored(); gotBash == gotNvim {
+		t.Errorf("Restored .bashrc %v, init.lua %v, want one of them", gotBash, gotNvim)
+	}
+
+	tests := []struct {
+		name     string
+		opts     restoreOptions
+		wantBash bool
+		wantNvim bool
+		wantCode int
+	}{
+		{name: "App", opts: restoreOptions{apps: []string{"nvim"}}, wantNvim: true},
+		{name: "Include", opts: restoreOptions{include: []string{"~/.bashrc"}}, wantBash: true},
+		{name: "Exclude", opts: restoreOptions{exclude: []string{"~/.config"}}, wantBash: true},
+		{name: "Unknown app", opts: restoreOptions{apps: []string{"emacs"}}, wantCode: exitUsage},
+		{name: "Label mismatch", opts: restoreOptions{labels: []string{"role=server"}}, wantCode: exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			opts := tt.opts
+			opts.repo, opts.machine, opts.yes = "dotfiles", "laptop", true
+			var err error
+			captureStdout(t, func() {
+				err = runRestoreWizard(manager, client, nil, prompt, opts)
+			})
+			if exitCode(err) != tt.wantCode {
+				t.Fatalf("runRestoreWizard() error = %v, want exit code %d", err, tt.wantCode)
+			}
+			if gotBash, gotNvim := restored(); gotBash != tt.wantBash || gotNvim != tt.wantNvim {
+				t.Errorf("Restored .bashrc %v, init.lua %v", gotBash, gotNvim)
+			}
+		})
+	}
+}
+
+func TestRestoreChoices(t *testing.T) {
+	files := []types.DotFile{
+		{Path: "~/.ssh/config"},
+		{Path: "$XDG_CONFIG_HOME/nvim/init.lua"},
+		{Path: "~/.tmux.conf"},
+		{Path: "~/.ssh/known_hosts"},
+		{Path: "/etc/hosts"},
+	}
+	apps := []types.App{{Name: "nvim", Version: "0.9.5", ConfigFiles: []types.DotFile{{Path: "$XDG_CONFIG_HOME/nvim/init.lua"}}}}
+
+	var got []string
+	for _, choice := range restoreChoices(files, apps) {
+		got = append(got, fmt.Sprintf("%s: %d", choice.label, len(choice.files)))
+	}
+	want := []string{"nvim 0.9.5: 1", "~/.ssh: 2", "~/.tmux.conf: 1", "/etc: 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreChoices() = %v, want %v", got, want)
+	}
+}
+
+func TestRunRestoreLabels(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	initLua := filepath.Join(home, ".config/nvim/init.lua")
+	if err := os.WriteFile(initLua, []byte("vim.opt.number = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, machine := range []types.Machine{
+		{Hostname: "web1", Labels: map[string]string{"role": "server", "site": "fra"}},
+		{Hostname: "web2", Labels: map[string]string{"role": "server", "site": "ams"}},
+	} {
+		cfg, err := manager.Load()
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		cfg.Machine = machine
+		if err := manager.Save(cfg); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		prompt, _ := newTestPrompter("")
+		var runErr error
+		captureStdout(t, func() {
+			runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: machine.Hostname, yes: true, paths: []string{initLua}}, nil)
+		})
+		if runErr != nil {
+			t.Fatalf("runBackupWizard() error = %v", runErr)
+		}
+	}
+	os.Remove(initLua)
+
+	// A machine backed up before manifests existed has no labels to match
+	client.Files["dotfiles/machines/old/files/home/.bashrc"] = []byte("alias ll='ls -l'\n")
+
+	prompt, _ := newTestPrompter("")
+	var err error
+	out := captureStdout(t, func() {
+		err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", labels: []string{"site=ams"}, yes: true})
+	})
+	if err != nil || !strings.Contains(out, "Restored machine web2 from dotfiles") {
+		t.Errorf("runRestoreWizard() error = %v, output:\n%s", err, out)
+	}
+
+	for _, labels := range [][]string{{"role=server"}, {"role=desktop"}} {
+		captureStdout(t, func() {
+			err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", labels: labels, yes: true})
+		})
+		if exitCode(err) != exitUsage {
+			t.Errorf("runRestoreWizard() with labels %v error = %v, want a usage error", labels, err)
+		}
+	}
+
+	// Only matching machines are offered
+	prompt, asked := newTestPrompter("")
+	captureStdout(t, func() {
+		runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", labels: []string{"role=server"}})
+	})
+	if !strings.Contains(asked.String(), "web1") || strings.Contains(asked.String(), "laptop") {
+		t.Errorf("Machines offered:\n%s", asked.String())
+	}
+
+	// The wizard offers the labels as a checklist: pick site=ams, then the
+	// only machine left, and confirm
+	prompt, asked = newTestPrompter("2\n\n\n")
+	out = captureStdout(t, func() {
+		err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles"})
+	})
+	if err != nil || !strings.Contains(out, "Restored machine web2 from dotfiles") {
+		t.Errorf("runRestoreWizard() error = %v, output:\n%s", err, out)
+	}
+	if !strings.Contains(asked.String(), "  1) role=server\n  2) site=ams\n  3) site=fra\n") || strings.Contains(asked.String(), "laptop") {
+		t.Errorf("Prompts:\n%s", asked.String())
+	}
+}
+
+func TestRunRestoreAt(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	first := client.History[0].SHA
+	backupNewVersion(t, home, manager, client, "alias ll='ls -la'\n")
+	client.Tags["v1"] = first
+
+	for _, at := range []string{first, "v1"} {
+		os.Remove(filepath.Join(home, ".bashrc"))
+		prompt, _ := newTestPrompter("")
+		var err error
+		out := captureStdout(t, func() {
+			err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", mode: restore.ModeCopy, include: []string{"~/.bashrc"}, at: at, yes: true})
+		})
+		if err != nil || !strings.Contains(out, "Restoring from commit "+shortSHA(first)) {
+			t.Fatalf("runRestoreWizard() at %s error = %v, output:\n%s", at, err, out)
+		}
+		if content, err := os.ReadFile(filepath.Join(home, ".bashrc")); err != nil || string(content) != "alias ll='ls -l'\n" {
+			t.Errorf("Restored .bashrc at %s = %q, %v", at, content, err)
+		}
+	}
+
+	// Files already restored as symlinks go back to the older version too
+	os.Remove(filepath.Join(home, ".bashrc"))
+	prompt, _ := newTestPrompter("")
+	var err error
+	captureStdout(t, func() {
+		err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", yes: true})
+	})
+	if content, _ := os.ReadFile(filepath.Join(home, ".bashrc")); err != nil || string(content) != "alias ll='ls -la'\n" {
+		t.Fatalf("runRestoreWizard() error = %v, .bashrc = %q", err, content)
+	}
+	out := captureStdout(t, func() {
+		err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", include: []string{"~/.bashrc"}, at: first, yes: true})
+	})
+	if err != nil || !strings.Contains(out, "1 restored, 0 skipped, 0 failed") || strings.Contains(out, "Saved") {
+		t.Errorf("runRestoreWizard() at %s error = %v, output:\n%s", first, err, out)
+	}
+	if info, err := os.Lstat(filepath.Join(home, ".bashrc")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Restored .bashrc at %s is not a link: %v", first, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(home, ".bashrc")); err != nil || string(content) != "alias ll='ls -l'\n" {
+		t.Errorf("Restored .bashrc at %s = %q, %v", first, content, err)
+	}
+
+	// Unknown refs and dates before the first backup are usage errors
+	for _, at := range []string{"unknown", "2000-01-01"} {
+		prompt, _ := newTestPrompter("")
+		var err error
+		captureStdout(t, func() {
+			err = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", at: at, yes: true})
+		})
+		if exitCode(err) != exitUsage {
+			t.Errorf("runRestoreWizard() at %s error = %v, want a usage error", at, err)
+		}
+	}
+}
+
+func TestRunBackupAfterRestore(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", yes: true})
+	})
+	if runErr != nil {
+		t.Fatalf("runRestoreWizard() error = %v", runErr)
+	}
+
+	// Editing a restored file through its link is backed up under the
+	// file's own path, and nothing of dotback's store or state is
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte("# edited\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: "laptop", yes: true}, nil)
+	})
+	if runErr != nil || !strings.Contains(out, "1 uploaded, 1 skipped, 0 removed") {
+		t.Fatalf("runBackupWizard() error = %v, output:\n%s", runErr, out)
+	}
+	if got := string(client.Files["dotfiles/machines/laptop/files/home/.bashrc"]); got != "# edited\n" {
+		t.Errorf("Backed up .bashrc = %q", got)
+	}
+	for key := range client.Files {
+		if strings.Contains(key, "dotback") {
+			t.Errorf("Backed up %s", key)
+		}
+	}
+}
+
+func TestRunBackupAfterRender(t *testing.T) {
+	home, manager, client := setupRestoreBackup(t)
+	template := "export HOST={{ .Hostname }}\n"
+	backupNewVersion(t, home, manager, client, template)
+
+	prompt, _ := newTestPrompter("")
+	var runErr error
+	captureStdout(t, func() {
+		runErr = runRestoreWizard(manager, client, nil, prompt, restoreOptions{repo: "dotfiles", machine: "laptop", mode: restore.ModeRender, include: []string{"~/.bashrc"}, yes: true})
+	})
+	if content, _ := os.ReadFile(filepath.Join(home, ".bashrc")); runErr != nil || strings.Contains(string(content), "{{") {
+		t.Fatalf("runRestoreWizard() error = %v, rendered %q", runErr, content)
+	}
+
+	// The rendered output does not replace the template in the backup
+	out := captureStdout(t, func() {
+		runErr = runBackupWizard(manager, client, nil, prompt, backupOptions{repo: "dotfiles", machine: "laptop", yes: true, keepDeleted: true}, nil)
+	})
+	if runErr != nil || !strings.Contains(out, "Leaving out 1 files rendered from templates") {
+		t.Fatalf("runBackupWizard() error = %v, output:\n%s", runErr, out)
+	}
+	if got := string(client.Files["dotfiles/machines/laptop/files/home/.bashrc"]); got != template {
+		t.Errorf("Backed up .bashrc = %q, want the template", got)
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/ignore"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/output"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/amroessam/dotback/internal/scan"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanVerbose bool
+	scanExplain string
+	scanOutput  string
+	scanNoCache bool
+	scanChanges bool
+)
+
+// scanReport is the machine-readable form of a scan
+type scanReport struct {
+	Apps     []types.App     `json:"apps"`
+	DotFiles []types.DotFile `json:"dot_files"`
+	Skipped  []scan.Skip     `json:"skipped,omitempty"`
+}
+
+// changesReport is the machine-readable form of scan --changes
+type changesReport struct {
+	Hostname string          `json:"hostname"`
+	LastSync time.Time       `json:"last_sync"`
+	Changes  []scan.Change   `json:"changes"`
+	Totals   scan.DiffTotals `json:"totals"`
+}
+
+// dotFileWalker is implemented by file systems that can stream results
+type dotFileWalker interface {
+	Walk(paths []string, fn scan.WalkFunc) error
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [paths...]",
+	Short: "Scan the system for dotfiles",
+	Long: `Scan the system for dotfiles and application configurations.
+Without arguments the home directory is scanned. Pass one or more paths
+to scan only those files or directories.
+
+Files matching the rules in ~/.dotbackignore, $XDG_CONFIG_HOME/dotback/.dotbackignore
+or the "ignore" list in the config file are skipped. The rules use gitignore
+syntax. Use --explain to see which rule applies to a path.
+
+Use --output to print the results as json, yaml, table or ndjson. The ndjson
+format prints one file per line while the scan is still running.
+
+Use --changes to compare the files on disk with the machine state recorded
+at the last backup. Every path is reported as added, modified, deleted,
+type-changed (file vs. symlink) or unchanged, followed by totals.
+
+Hashes of unchanged files are reused from the hash cache. Use --no-cache to
+hash every file, or 'dotback cache clear' to reset the cache.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runScan(cmd, args, nil); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+func init() {
+	scanCmd.Flags().BoolVarP(&scanVerbose, "verbose", "v", false, "Show every file found")
+	scanCmd.Flags().StringVar(&scanExplain, "explain", "", "Show which ignore rule includes or excludes a path")
+	scanCmd.Flags().StringVarP(&scanOutput, "output", "o", "", "Output format: json, yaml, table or ndjson")
+	scanCmd.Flags().BoolVar(&scanNoCache, "no-cache", false, "Hash every file instead of reusing cached hashes")
+	scanCmd.Flags().BoolVar(&scanChanges, "changes", false, "Show what changed since the last recorded machine state")
+	rootCmd.AddCommand(scanCmd)
+}
+
+func runScan(cmd *cobra.Command, args []string, testFS types.FileSystem) error {
+	format, err := output.ParseFormat(scanOutput)
+	if err != nil {
+		return fmt.Errorf("Error: %v", err)
+	}
+	if format != output.FormatText {
+		// Keep stdout clean for machine-readable output
+		logger.SetOutput(os.Stderr)
+		defer logger.SetOutput(os.Stdout)
+	}
+
+	logger.Info("Scanning for dotfiles")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return fmt.Errorf("Error: Could not load configuration")
+	}
+	matcher, err := loadIgnore(cfg)
+	if err != nil {
+		logger.Error("Failed to load ignore rules: %v", err)
+		return fmt.Errorf("Error: Could not load ignore rules")
+	}
+	if err := checkSymlinks(cfg); err != nil {
+		return err
+	}
+
+	// Ctrl-C stops the scan cleanly instead of killing it mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	status := newStatusLine()
+	defer status.Clear()
+
+	var skipped []scan.Skip
+	opts := scanOptions(cfg, matcher)
+	opts.Context = ctx
+	opts.OnSkip = func(skip scan.Skip) {
+		skipped = append(skipped, skip)
+	}
+	opts.OnProgress = func(progress scan.Progress) {
+		status.Update(formatProgress(progress))
+	}
+
+	fileSystem := testFS
+	if fileSystem == nil {
+		opts.Cache = openHashCache(scanNoCache)
+		defer saveHashCache(opts.Cache)
+
+		osFS, err := scan.NewFileSystem(opts)
+		if err != nil {
+			logger.Error("Failed to initialize file system: %v", err)
+			return fmt.Errorf("Error: Could not initialize scanner")
+		}
+		fileSystem = osFS
+	}
+
+	if scanExplain != "" {
+		return explainPath(fileSystem.GetAbsolutePath(scanExplain), matcher)
+	}
+
+	if scanChanges {
+		return showChanges(fileSystem, args, format, status)
+	}
+
+	if format == output.FormatNDJSON {
+		return streamScan(fileSystem, args)
+	}
+
+	files, err := fileSystem.FindDotFiles(args)
+	if err != nil {
+		return scanError(err)
+	}
+
+	apps, err := fileSystem.FindAppConfigs(nil)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return scanError(err)
+		}
+		logger.Error("App detection failed: %v", err)
+		return fmt.Errorf("Error: Could not detect applications")
+	}
+	apps, other := scan.GroupFiles(files, apps)
+	status.Clear()
+
+	switch format {
+	case output.FormatJSON:
+		err = output.WriteJSON(os.Stdout, scanReport{Apps: apps, DotFiles: files, Skipped: skipped})
+	case output.FormatYAML:
+		err = output.WriteYAML(os.Stdout, scanReport{Apps: apps, DotFiles: files, Skipped: skipped})
+	case output.FormatTable:
+		err = writeScanTable(apps, other)
+	default:
+		printScanSummary(files, apps, other, skipped)
+	}
+	if err != nil {
+		logger.Error("Failed to write output: %v", err)
+		return fmt.Errorf("Error: Could not write scan results")
+	}
+	return nil
+}
+
+// streamScan prints each file as a line of JSON as soon as it is found
+func streamScan(fileSystem types.FileSystem, args []string) error {
+	write := func(file types.DotFile) error {
+		return output.WriteNDJSON(os.Stdout, file)
+	}
+
+	var err error
+	if walker, ok := fileSystem.(dotFileWalker); ok {
+		err = walker.Walk(args, write)
+	} else {
+		var files []types.DotFile
+		if files, err = fileSystem.FindDotFiles(args); err == nil {
+			for _, file := range files {
+				if err = write(file); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		return scanError(err)
+	}
+	return nil
+}
+
+// showChanges compares the current scan with the machine saved in the config
+func showChanges(fileSystem types.FileSystem, args []string, format output.Format, status *statusLine) error {
+	files, err := fileSystem.FindDotFiles(args)
+	if err != nil {
+		return scanError(err)
+	}
+	status.Clear()
+
+	configManager, err := config.NewManager()
+	if err != nil {
+		logger.Error("Failed to initialize config manager: %v", err)
+		return fmt.Errorf("Error: Could not initialize configuration")
+	}
+	if _, err := configManager.Load(); err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return fmt.Errorf("Error: Could not load configuration")
+	}
+	machine, err := configManager.GetMachine()
+	if err != nil {
+		logger.Error("Failed to load machine state: %v", err)
+		return fmt.Errorf("Error: Could not load machine state")
+	}
+
+	changes := diffMachine(fileSystem, args, machine.DotFiles, files)
+	report := changesReport{
+		Hostname: machine.Hostname,
+		LastSync: machine.LastSync,
+		Changes:  changes,
+		Totals:   scan.CountChanges(changes),
+	}
+
+	switch format {
+	case output.FormatJSON:
+		err = output.WriteJSON(os.Stdout, report)
+	case output.FormatYAML:
+		err = output.WriteYAML(os.Stdout, report)
+	case output.FormatNDJSON:
+		for _, change := range changes {
+			if err = output.WriteNDJSON(os.Stdout, change); err != nil {
+				break
+			}
+		}
+	case output.FormatTable:
+		var rows [][]string
+		for _, change := range changes {
+			rows = append(rows, []string{string(change.Kind), change.Path})
+		}
+		err = output.WriteTable(os.Stdout, []string{"STATUS", "PATH"}, rows)
+	default:
+		printChanges(report)
+	}
+	if err != nil {
+		logger.Error("Failed to write output: %v", err)
+		return fmt.Errorf("Error: Could not write scan results")
+	}
+	return nil
+}
+
+// diffMachine compares the recorded files with the scan results. Recorded
+// paths may be portable (~/ or $XDG_*) and are resolved first. When only
+// some paths were scanned, recorded files outside them are left out, as are
+// recorded files that still exist but were excluded from the scan.
+func diffMachine(fileSystem types.FileSystem, args []string, recorded, files []types.DotFile) []scan.Change {
+	var roots []string
+	for _, arg := range args {
+		roots = append(roots, fileSystem.GetAbsolutePath(arg))
+	}
+
+	var previous []types.DotFile
+	for _, file := range recorded {
+		file.Path = fileSystem.GetAbsolutePath(file.Path)
+		if len(roots) > 0 && !underAny(file.Path, roots) {
+			continue
+		}
+		previous = append(previous, file)
+	}
+
+	var changes []scan.Change
+	for _, change := range scan.Diff(previous, files) {
+		if change.Kind == scan.ChangeDeleted && fileSystem.Exists(change.Path) {
+			logger.Debug("Not reporting %s as deleted: it exists but was excluded from the scan", change.Path)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// underAny reports whether path is one of roots or inside one of them
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func printChanges(report changesReport) {
+	if report.LastSync.IsZero() && report.Totals.Added == len(report.Changes) {
+		fmt.Println("No machine state recorded yet; every file is reported as added")
+	} else {
+		fmt.Printf("Changes since %s\n", report.LastSync.Format("2006-01-02 15:04"))
+	}
+
+	for _, change := range report.Changes {
+		if change.Kind == scan.ChangeUnchanged && !scanVerbose {
+			continue
+		}
+		fmt.Printf("  %-13s %s\n", change.Kind, change.Path)
+	}
+
+	totals := report.Totals
+	fmt.Printf("%d added, %d modified, %d deleted, %d type-changed, %d unchanged\n",
+		totals.Added, totals.Modified, totals.Deleted, totals.TypeChanged, totals.Unchanged)
+}
+
+// scanError converts a scan failure into the message shown to the user
+func scanError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		logger.Info("Scan cancelled")
+		return withExitCode(exitInterrupted, fmt.Errorf("Error: Scan cancelled"))
+	}
+	logger.Error("Scan failed: %v", err)
+	return withExitCode(exitScan, fmt.Errorf("Error: Could not scan for dotfiles"))
+}
+
+func formatProgress(progress scan.Progress) string {
+	return fmt.Sprintf("Scanned %d files, %s hashed: %s", progress.FilesSeen, output.FormatBytes(progress.BytesHashed), progress.CurrentDir)
+}
+
+func writeScanTable(apps []types.App, other []types.DotFile) error {
+	var rows [][]string
+	addRows := func(app string, files []types.DotFile) {
+		for _, file := range files {
+			rows = append(rows, []string{
+				app,
+				file.Path,
+				file.LastModified.Format("2006-01-02 15:04"),
+				shortHash(file.Hash),
+				fmt.Sprint(file.IsSymlink),
+			})
+		}
+	}
+	for _, app := range apps {
+		addRows(app.Name, app.ConfigFiles)
+	}
+	addRows("-", other)
+	return output.WriteTable(os.Stdout, []string{"APP", "PATH", "MODIFIED", "HASH", "SYMLINK"}, rows)
+}
+
+func printScanSummary(files []types.DotFile, apps []types.App, other []types.DotFile, skipped []scan.Skip) {
+	symlinks := 0
+	for _, file := range files {
+		if file.IsSymlink {
+			symlinks++
+		}
+	}
+
+	fmt.Printf("Found %d dotfiles (%d symlinks) in %d applications\n", len(files), symlinks, len(apps))
+	for _, app := range apps {
+		printGroup(appLabel(app), app.ConfigFiles)
+	}
+	if len(other) > 0 {
+		printGroup("Other", other)
+	}
+
+	if len(skipped) > 0 {
+		if scanVerbose {
+			fmt.Printf("\nSkipped (%d entries)\n", len(skipped))
+			for _, skip := range skipped {
+				fmt.Printf("  %s: %s\n", skip.Path, skip.Reason)
+			}
+		} else {
+			fmt.Printf("Skipped %d entries (use --verbose to see why)\n", len(skipped))
+		}
+	}
+
+	var flagged []types.DotFile
+	for _, file := range files {
+		if len(file.Secrets) > 0 {
+			flagged = append(flagged, file)
+		}
+	}
+	if len(flagged) > 0 {
+		fmt.Printf("\nWarning: %d files look like they contain secrets and will not be backed up\n", len(flagged))
+		fmt.Println("unless they are listed in \"secret_allow\" in the config file:")
+		for _, file := range flagged {
+			fmt.Printf("  %s (%s)\n", file.Path, strings.Join(file.Secrets, ", "))
+		}
+	}
+}
+
+// loadConfig reads the config file, returning defaults when it does not exist
+func loadConfig() (*types.Config, error) {
+	configManager, err := config.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	return configManager.Load()
+}
+
+// loadIgnore builds the ignore matcher from the ignore files and the config
+// file. A custom $XDG_CACHE_HOME inside the home directory is ignored just
+// like the default ~/.cache, and so are dotback's own directories, which
+// change with every backup and restore.
+func loadIgnore(cfg *types.Config) (*ignore.Matcher, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := xdg.Load()
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := ignore.Load(dirs.Home, configDir, cfg.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(dirs.Home, dirs.CacheHome); err == nil && rel != ".cache" && rel != "." && !strings.HasPrefix(rel, "..") {
+		matcher.AddPatterns(xdg.CacheHomeVar, []string{"/" + filepath.ToSlash(rel) + "/"})
+	}
+	for _, getDir := range []config.GetConfigDirFunc{config.GetConfigDir, config.GetDataDir, config.GetStateDir, config.GetCacheDir} {
+		dir, err := getDir()
+		if err != nil {
+			logger.Debug("Not ignoring a dotback directory: %v", err)
+			continue
+		}
+		if rel, err := filepath.Rel(dirs.Home, dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			matcher.AddPatterns("dotback", []string{"/" + filepath.ToSlash(rel) + "/"})
+		}
+	}
+	return matcher, nil
+}
+
+// scanOptions applies the scan limits from the config file
+func scanOptions(cfg *types.Config, matcher *ignore.Matcher) scan.Options {
+	opts := scan.Options{
+		Ignore:        matcher,
+		MaxFileSize:   cfg.Scan.MaxFileSize,
+		MaxSQLiteSize: cfg.Scan.MaxSQLiteSize,
+		SkipBinary:    cfg.Scan.SkipBinary,
+		RecordOwner:   cfg.Scan.RecordOwner,
+		Symlinks:      cfg.Symlinks,
+	}
+	// Restored files link into the data directory
+	if dataDir, err := config.GetDataDir(); err == nil {
+		opts.ManagedDirs = []string{dataDir}
+	}
+	return opts
+}
+
+// checkSymlinks returns a usage error if the config file has an unknown
+// symlink policy
+func checkSymlinks(cfg *types.Config) error {
+	if err := scan.CheckSymlinkPolicy(cfg.Symlinks.Default); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("Error: Invalid symlinks.default in the config file: %v", err))
+	}
+	for path, policy := range cfg.Symlinks.Paths {
+		if err := scan.CheckSymlinkPolicy(policy); err != nil {
+			return withExitCode(exitUsage, fmt.Errorf("Error: Invalid symlink policy for %s in the config file: %v", path, err))
+		}
+	}
+	return nil
+}
+
+func explainPath(path string, matcher *ignore.Matcher) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("Error: Could not read %s", path)
+	}
+
+	result := matcher.Explain(path, info.IsDir())
+	switch {
+	case result.Rule == nil:
+		fmt.Printf("%s: included (no rule matched)\n", path)
+	case result.Parent != "":
+		fmt.Printf("%s: excluded because %s is excluded by %s\n", path, result.Parent, result.Rule)
+	case result.Ignored:
+		fmt.Printf("%s: excluded by %s\n", path, result.Rule)
+	default:
+		fmt.Printf("%s: included by %s\n", path, result.Rule)
+	}
+	return nil
+}
+
+func appLabel(app types.App) string {
+	if app.Version == "" {
+		return app.Name
+	}
+	return app.Name + " " + app.Version
+}
+
+func printGroup(label string, files []types.DotFile) {
+	if !scanVerbose {
+		fmt.Printf("  %-24s %d files\n", label, len(files))
+		return
+	}
+	fmt.Printf("\n%s (%d files)\n", label, len(files))
+	for _, file := range files {
+		printDotFile(file)
+	}
+}
+
+func printDotFile(file types.DotFile) {
+	suffix := ""
+	if file.IsSymlink {
+		suffix = " (symlink)"
+	}
+	if file.Binary {
+		suffix += " (binary)"
+	}
+	if len(file.Secrets) > 0 {
+		suffix += " [secret: " + strings.Join(file.Secrets, ", ") + "]"
+	}
+	fmt.Printf("  %s  %s  %s%s\n", file.LastModified.Format("2006-01-02 15:04"), shortHash(file.Hash), file.Path, suffix)
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/config"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/scan"
+)
+
+// captureStdout runs fn and returns everything it printed to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	oldStdout := os.Stdout
+	defer func() { os.Stdout = oldStdout }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout = w
+
+	done := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		buf.ReadFrom(r)
+		done <- buf.String()
+	}()
+
+	fn()
+	w.Close()
+	return <-done
+}
+
+// setupScanHome points HOME at a temporary directory containing a few dotfiles
+func setupScanHome(t *testing.T) string {
+	home, err := os.MkdirTemp("", "dotback-home-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	t.Setenv("HOME", home)
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME"} {
+		t.Setenv(name, "")
+	}
+
+	for path, content := range map[string]string{
+		".bashrc":               "alias ll='ls -l'\n",
+		".config/nvim/init.lua": "vim.opt.number = true\n",
+		"Documents/notes.txt":   "not a dotfile\n",
+	} {
+		full := filepath.Join(home, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	return home
+}
+
+func TestRunScan(t *testing.T) {
+	home := setupScanHome(t)
+
+	tests := []struct {
+		name        string
+		verbose     bool
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:        "Summary",
+			verbose:     false,
+			contains:    []string{"Found 2 dotfiles", "in 2 applications", "bash", "nvim"},
+			notContains: []string{filepath.Join(home, ".bashrc")},
+		},
+		{
+			name:        "Verbose",
+			verbose:     true,
+			contains:    []string{"Found 2 dotfiles", "nvim", filepath.Join(home, ".bashrc"), filepath.Join(home, ".config/nvim/init.lua")},
+			notContains: []string{"notes.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanVerbose = tt.verbose
+			defer func() { scanVerbose = false }()
+
+			var runErr error
+			output := captureStdout(t, func() {
+				runErr = runScan(nil, nil, nil)
+			})
+			if runErr != nil {
+				t.Fatalf("runScan() error = %v", runErr)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(output, s) {
+					t.Errorf("Expected output to contain %q, got: %s", s, output)
+				}
+			}
+			for _, s := range tt.notContains {
+				if strings.Contains(output, s) {
+					t.Errorf("Expected output not to contain %q, got: %s", s, output)
+				}
+			}
+		})
+	}
+}
+
+func TestRunScanExplain(t *testing.T) {
+	home := setupScanHome(t)
+	if err := os.WriteFile(filepath.Join(home, ".dotbackignore"), []byte(".config/\n!.bashrc\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ignore file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{name: "Excluded by parent", path: "~/.config/nvim/init.lua", expected: "excluded because " + filepath.Join(home, ".config") + " is excluded by " + filepath.Join(home, ".dotbackignore") + ":1: .config/"},
+		{name: "Included by negation", path: "~/.bashrc", expected: "included by " + filepath.Join(home, ".dotbackignore") + ":2: !.bashrc"},
+		{name: "No rule", path: "~/Documents/notes.txt", expected: "included (no rule matched)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanExplain = tt.path
+			defer func() { scanExplain = "" }()
+
+			var runErr error
+			output := captureStdout(t, func() {
+				runErr = runScan(nil, nil, nil)
+			})
+			if runErr != nil {
+				t.Fatalf("runScan() error = %v", runErr)
+			}
+			if !strings.Contains(output, tt.expected) {
+				t.Errorf("Expected output to contain %q, got: %s", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRunScanOutput(t *testing.T) {
+	home := setupScanHome(t)
+
+	t.Run("JSON", func(t *testing.T) {
+		scanOutput = "json"
+		defer func() { scanOutput = "" }()
+
+		var runErr error
+		out := captureStdout(t, func() {
+			runErr = runScan(nil, nil, nil)
+		})
+		if runErr != nil {
+			t.Fatalf("runScan() error = %v", runErr)
+		}
+
+		var report scanReport
+		if err := json.Unmarshal([]byte(out), &report); err != nil {
+			t.Fatalf("Output is not valid JSON: %v\n%s", err, out)
+		}
+		if len(report.DotFiles) != 2 || len(report.Apps) != 2 {
+			t.Errorf("Report = %+v, want 2 files in 2 apps", report)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		scanOutput = "ndjson"
+		defer func() { scanOutput = "" }()
+
+		var runErr error
+		out := captureStdout(t, func() {
+			runErr = runScan(nil, nil, nil)
+		})
+		if runErr != nil {
+			t.Fatalf("runScan() error = %v", runErr)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected 2 lines, got: %s", out)
+		}
+		for _, line := range lines {
+			var file types.DotFile
+			if err := json.Unmarshal([]byte(line), &file); err != nil {
+				t.Errorf("Line is not valid JSON: %v\n%s", err, line)
+			}
+			if !strings.HasPrefix(file.Path, home) {
+				t.Errorf("Unexpected path %q", file.Path)
+			}
+		}
+	})
+
+	t.Run("YAML and table", func(t *testing.T) {
+		for format, expected := range map[string]string{"yaml": "dot_files:", "table": "APP"} {
+			scanOutput = format
+			var runErr error
+			out := captureStdout(t, func() {
+				runErr = runScan(nil, nil, nil)
+			})
+			scanOutput = ""
+			if runErr != nil {
+				t.Fatalf("runScan() error = %v", runErr)
+			}
+			if !strings.Contains(out, expected) || strings.Contains(out, "INFO:") {
+				t.Errorf("Unexpected %s output: %s", format, out)
+			}
+		}
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		scanOutput = "xml"
+		defer func() { scanOutput = "" }()
+		if err := runScan(nil, nil, nil); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
+
+func TestRunScanSecretsWarning(t *testing.T) {
+	home := setupScanHome(t)
+	if err := os.MkdirAll(filepath.Join(home, ".aws"), 0700); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".aws", "credentials"), []byte("[default]\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var runErr error
+	output := captureStdout(t, func() {
+		runErr = runScan(nil, nil, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runScan() error = %v", runErr)
+	}
+	for _, expected := range []string{"Warning: 1 files look like they contain secrets", filepath.Join(home, ".aws", "credentials") + " (credential-file)"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got: %s", expected, output)
+		}
+	}
+}
+
+func TestRunScanSkipped(t *testing.T) {
+	home := setupScanHome(t)
+	configDir := filepath.Join(home, ".config", "dotback")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"scan": {"max_file_size": 64}}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".zsh_history"), []byte(strings.Repeat("ls\n", 100)), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	scanVerbose = true
+	defer func() { scanVerbose = false }()
+
+	var runErr error
+	output := captureStdout(t, func() {
+		runErr = runScan(nil, nil, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runScan() error = %v", runErr)
+	}
+	expected := filepath.Join(home, ".zsh_history") + ": larger than 64 B (300 B)"
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected output to contain %q, got: %s", expected, output)
+	}
+}
+
+func TestLoadIgnoreXDGCache(t *testing.T) {
+	home := setupScanHome(t)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".xdg-cache"))
+
+	matcher, err := loadIgnore(&types.Config{})
+	if err != nil {
+		t.Fatalf("loadIgnore() error = %v", err)
+	}
+	result := matcher.Explain(filepath.Join(home, ".xdg-cache", "app", "data"), false)
+	if !result.Ignored || result.Rule.Source != "$XDG_CACHE_HOME" {
+		t.Errorf("Explain() = %+v, want ignored by $XDG_CACHE_HOME", result)
+	}
+}
+
+func TestLoadIgnoreDotbackDirs(t *testing.T) {
+	home := setupScanHome(t)
+	oldGetConfigDir := config.GetConfigDir
+	t.Cleanup(func() { config.GetConfigDir = oldGetConfigDir })
+	config.GetConfigDir = config.DefaultGetConfigDir
+
+	matcher, err := loadIgnore(&types.Config{})
+	if err != nil {
+		t.Fatalf("loadIgnore() error = %v", err)
+	}
+	for _, path := range []string{
+		".config/dotback/config.json",
+		".local/share/dotback/store/machines/laptop/files/home/.bashrc",
+		".local/state/dotback/restored.json",
+	} {
+		if result := matcher.Explain(filepath.Join(home, path), false); !result.Ignored || result.Rule.Source != "dotback" {
+			t.Errorf("Explain(%s) = %+v, want ignored by dotback", path, result)
+		}
+	}
+	if result := matcher.Explain(filepath.Join(home, ".config/nvim/init.lua"), false); result.Ignored {
+		t.Errorf("Explain(init.lua) = %+v", result)
+	}
+}
+
+func TestRunScanChanges(t *testing.T) {
+	home := setupScanHome(t)
+	configDir := filepath.Join(home, ".config", "dotback")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	sum := sha256.Sum256([]byte("vim.opt.number = true\n"))
+	cfg := types.Config{Machine: types.Machine{
+		Hostname: "test-host",
+		LastSync: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		DotFiles: []types.DotFile{
+			{Path: filepath.Join(home, ".bashrc"), Hash: "outdated"},
+			{Path: "$XDG_CONFIG_HOME/nvim/init.lua", Hash: hex.EncodeToString(sum[:])},
+			{Path: "~/.vimrc", Hash: "gone"},
+		},
+	}}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to encode config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), data, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	scanChanges = true
+	defer func() { scanChanges = false }()
+
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = runScan(nil, nil, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runScan() error = %v", runErr)
+	}
+	// The config file written above is dotback's own and not reported
+	for _, expected := range []string{
+		"Changes since 2024-01-02 03:04",
+		"modified      " + filepath.Join(home, ".bashrc"),
+		"deleted       " + filepath.Join(home, ".vimrc"),
+		"0 added, 1 modified, 1 deleted, 0 type-changed, 1 unchanged",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected output to contain %q, got: %s", expected, out)
+		}
+	}
+	if strings.Contains(out, "unchanged     ") {
+		t.Errorf("Unchanged files should only be listed with --verbose: %s", out)
+	}
+
+	// Scanning a single path only compares recorded files under it
+	scanOutput = "json"
+	defer func() { scanOutput = "" }()
+	out = captureStdout(t, func() {
+		runErr = runScan(nil, []string{filepath.Join(home, ".bashrc")}, nil)
+	})
+	if runErr != nil {
+		t.Fatalf("runScan() error = %v", runErr)
+	}
+	var report changesReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("Failed to parse output: %v\n%s", err, out)
+	}
+	if report.Hostname != "test-host" || report.Totals != (scan.DiffTotals{Modified: 1}) {
+		t.Errorf("report = %+v", report)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// statusInterval limits how often the status line is redrawn
+const statusInterval = 100 * time.Millisecond
+
+// statusLine keeps a single, continuously rewritten line of progress on a
+// terminal. It does nothing when the output is not a terminal, so logs and
+// pipes are not filled with carriage returns.
+type statusLine struct {
+	mu      sync.Mutex
+	w       io.Writer
+	enabled bool
+	last    time.Time
+	shown   bool
+}
+
+func newStatusLine() *statusLine {
+	return &statusLine{w: os.Stderr, enabled: isTerminal(os.Stderr)}
+}
+
+// Update redraws the line unless it was redrawn very recently
+func (s *statusLine) Update(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.enabled || time.Since(s.last) < statusInterval {
+		return
+	}
+	s.last = time.Now()
+	s.shown = true
+	fmt.Fprintf(s.w, "\r\033[K%s", text)
+}
+
+// Clear removes the line so that regular output can follow
+func (s *statusLine) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shown {
+		fmt.Fprint(s.w, "\r\033[K")
+		s.shown = false
+	}
+}
+
+// isTerminal reports whether f is attached to a terminal. Other character
+// devices, such as /dev/null, are not terminals.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsTerminal(t *testing.T) {
+	// /dev/null is a character device but not a terminal, as when a backup
+	// is run from cron with its input redirected
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skipf("Cannot open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+	if isTerminal(devNull) {
+		t.Errorf("isTerminal(%s) = true", os.DevNull)
+	}
+
+	file, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	if isTerminal(file) {
+		t.Error("isTerminal() of a regular file = true")
+	}
+}
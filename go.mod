@@ -5,7 +5,9 @@ go 1.23.3
 require (
 	github.com/google/go-github/v60 v60.0.0
 	github.com/spf13/cobra v1.8.1
+	github.com/zalando/go-keyring v0.2.6
 	golang.org/x/oauth2 v0.25.0
+	golang.org/x/term v0.25.0
 )
 
 require (
@@ -15,6 +17,5 @@ require (
 	github.com/google/go-querystring v1.1.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/zalando/go-keyring v0.2.6 // indirect
 	golang.org/x/sys v0.26.0 // indirect
 )
@@ -2,7 +2,11 @@ package github
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/amroessam/dotback/internal/common/types"
 	"github.com/google/go-github/v60/github"
@@ -90,10 +94,13 @@ func (c *Client) ListRepositories() ([]types.Repository, error) {
 
 // CreateRepository creates a new repository
 func (c *Client) CreateRepository(name, description string, private bool) error {
+	// Auto-initialize so the Git Data API, which cannot write to an empty
+	// repository, works from the first backup
 	repo := &github.Repository{
 		Name:        github.String(name),
 		Description: github.String(description),
 		Private:     github.Bool(private),
+		AutoInit:    github.Bool(true),
 	}
 	_, _, err := c.client.Repositories.Create(c.ctx, "", repo)
 	if err != nil {
@@ -102,6 +109,19 @@ func (c *Client) CreateRepository(name, description string, private bool) error
 	return nil
 }
 
+// DefaultBranch returns the name of a repository's default branch
+func (c *Client) DefaultBranch(repo string) (string, error) {
+	owner, repo, err := c.splitRepo(repo)
+	if err != nil {
+		return "", fmt.Errorf("error getting user: %w", err)
+	}
+	repository, _, err := c.client.Repositories.Get(c.ctx, owner, repo)
+	if err != nil {
+		return "", fmt.Errorf("error getting repository: %w", err)
+	}
+	return repository.GetDefaultBranch(), nil
+}
+
 // DeleteRepository deletes a repository
 func (c *Client) DeleteRepository(name string) error {
 	user, _, err := c.client.Users.Get(c.ctx, "")
@@ -118,14 +138,14 @@ func (c *Client) DeleteRepository(name string) error {
 
 // UploadFile uploads a file to a repository
 func (c *Client) UploadFile(repo, path string, content []byte, message string) error {
-	user, _, err := c.client.Users.Get(c.ctx, "")
+	owner, repo, err := c.splitRepo(repo)
 	if err != nil {
 		return fmt.Errorf("error getting user: %w", err)
 	}
 
 	// Check if file exists to get the SHA
 	var sha *string
-	fileContent, _, _, err := c.client.Repositories.GetContents(c.ctx, user.GetLogin(), repo, path, &github.RepositoryContentGetOptions{})
+	fileContent, _, _, err := c.client.Repositories.GetContents(c.ctx, owner, repo, path, &github.RepositoryContentGetOptions{})
 	if err == nil && fileContent != nil {
 		sha = fileContent.SHA
 	}
@@ -137,7 +157,7 @@ func (c *Client) UploadFile(repo, path string, content []byte, message string) e
 		SHA:     sha,
 	}
 
-	_, _, err = c.client.Repositories.CreateFile(c.ctx, user.GetLogin(), repo, path, opts)
+	_, _, err = c.client.Repositories.CreateFile(c.ctx, owner, repo, path, opts)
 	if err != nil {
 		return fmt.Errorf("error uploading file: %w", err)
 	}
@@ -145,18 +165,40 @@ func (c *Client) UploadFile(repo, path string, content []byte, message string) e
 	return nil
 }
 
-// DownloadFile downloads a file from a repository
+// DownloadFile downloads a file from the default branch of a repository
 func (c *Client) DownloadFile(repo, path string) ([]byte, error) {
-	user, _, err := c.client.Users.Get(c.ctx, "")
+	return c.DownloadFileAt(repo, path, "")
+}
+
+// DownloadFileAt downloads a file as it was at a commit, branch or tag. An
+// empty ref is the default branch. The contents API only includes files of
+// up to 1 MB in its response, so larger files are read as raw blobs
+// instead.
+func (c *Client) DownloadFileAt(repo, path, ref string) ([]byte, error) {
+	owner, repo, err := c.splitRepo(repo)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user: %w", err)
 	}
 
-	fileContent, _, _, err := c.client.Repositories.GetContents(c.ctx, user.GetLogin(), repo, path, &github.RepositoryContentGetOptions{})
+	fileContent, _, resp, err := c.client.Repositories.GetContents(c.ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("error downloading file %s: %w", path, types.ErrNotFound)
+		}
 		return nil, fmt.Errorf("error downloading file: %w", err)
 	}
 
+	if fileContent == nil {
+		return nil, fmt.Errorf("error downloading file: %s is a directory", path)
+	}
+	if fileContent.GetEncoding() == "none" || (fileContent.Content == nil && fileContent.GetSize() > 0) {
+		content, _, err := c.client.Git.GetBlobRaw(c.ctx, owner, repo, fileContent.GetSHA())
+		if err != nil {
+			return nil, fmt.Errorf("error downloading blob: %w", err)
+		}
+		return content, nil
+	}
+
 	content, err := fileContent.GetContent()
 	if err != nil {
 		return nil, fmt.Errorf("error decoding content: %w", err)
@@ -167,12 +209,12 @@ func (c *Client) DownloadFile(repo, path string) ([]byte, error) {
 
 // ListFiles lists files in a repository path
 func (c *Client) ListFiles(repo, path string) ([]string, error) {
-	user, _, err := c.client.Users.Get(c.ctx, "")
+	owner, repo, err := c.splitRepo(repo)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user: %w", err)
 	}
 
-	_, contents, _, err := c.client.Repositories.GetContents(c.ctx, user.GetLogin(), repo, path, &github.RepositoryContentGetOptions{})
+	_, contents, _, err := c.client.Repositories.GetContents(c.ctx, owner, repo, path, &github.RepositoryContentGetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("error listing files: %w", err)
 	}
@@ -184,3 +226,178 @@ func (c *Client) ListFiles(repo, path string) ([]string, error) {
 
 	return files, nil
 }
+
+// CommitFiles writes all changes to a branch as a single commit and returns
+// the commit SHA. Blobs, one tree and one commit are created through the Git
+// Data API, then the branch is moved to the new commit in one step, so a
+// failure partway through leaves the branch untouched. An empty branch name
+// means the repository's default branch.
+func (c *Client) CommitFiles(repo, branch string, changes []types.FileChange, message string) (string, error) {
+	owner, repo, err := c.splitRepo(repo)
+	if err != nil {
+		return "", fmt.Errorf("error getting user: %w", err)
+	}
+
+	if branch == "" {
+		if branch, err = c.DefaultBranch(owner + "/" + repo); err != nil {
+			return "", err
+		}
+	}
+
+	ref, err := c.branchRef(owner, repo, branch)
+	if err != nil {
+		return "", err
+	}
+	parent, _, err := c.client.Git.GetCommit(c.ctx, owner, repo, ref.GetObject().GetSHA())
+	if err != nil {
+		return "", fmt.Errorf("error getting commit: %w", err)
+	}
+
+	entries := make([]*github.TreeEntry, 0, len(changes))
+	for _, change := range changes {
+		mode := "100644"
+		if change.Executable {
+			mode = "100755"
+		}
+		entry := &github.TreeEntry{
+			Path: github.String(change.Path),
+			Mode: github.String(mode),
+			Type: github.String("blob"),
+		}
+		// A nil SHA without content removes the path from the tree
+		if !change.Delete {
+			blob, _, err := c.client.Git.CreateBlob(c.ctx, owner, repo, &github.Blob{
+				Content:  github.String(base64.StdEncoding.EncodeToString(change.Content)),
+				Encoding: github.String("base64"),
+			})
+			if err != nil {
+				return "", fmt.Errorf("error creating blob for %s: %w", change.Path, err)
+			}
+			entry.SHA = blob.SHA
+		}
+		entries = append(entries, entry)
+	}
+
+	tree, _, err := c.client.Git.CreateTree(c.ctx, owner, repo, parent.GetTree().GetSHA(), entries)
+	if err != nil {
+		return "", fmt.Errorf("error creating tree: %w", err)
+	}
+	commit, _, err := c.client.Git.CreateCommit(c.ctx, owner, repo, &github.Commit{
+		Message: github.String(message),
+		Tree:    tree,
+		Parents: []*github.Commit{{SHA: parent.SHA}},
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating commit: %w", err)
+	}
+
+	ref.Object.SHA = commit.SHA
+	if _, _, err := c.client.Git.UpdateRef(c.ctx, owner, repo, ref, false); err != nil {
+		return "", fmt.Errorf("error updating branch %s: %w", branch, err)
+	}
+	return commit.GetSHA(), nil
+}
+
+// ResolveRef returns the commit a commit SHA, which may be abbreviated, a
+// branch or a tag refers to
+func (c *Client) ResolveRef(repo, ref string) (types.Commit, error) {
+	owner, repo, err := c.splitRepo(repo)
+	if err != nil {
+		return types.Commit{}, fmt.Errorf("error getting user: %w", err)
+	}
+
+	commit, resp, err := c.client.Repositories.GetCommit(c.ctx, owner, repo, ref, nil)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity) {
+			return types.Commit{}, fmt.Errorf("error resolving %s: %w", ref, types.ErrNotFound)
+		}
+		return types.Commit{}, fmt.Errorf("error resolving %s: %w", ref, err)
+	}
+	return toCommit(commit), nil
+}
+
+// ListCommits lists the commits of the default branch that changed path,
+// newest first. An empty path lists every commit, and a zero until every
+// commit up to the head of the branch. At most limit commits are returned.
+func (c *Client) ListCommits(repo, path string, until time.Time, limit int) ([]types.Commit, error) {
+	owner, repo, err := c.splitRepo(repo)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	opts := &github.CommitsListOptions{
+		Path:        path,
+		Until:       until,
+		ListOptions: github.ListOptions{PerPage: min(limit, 100)},
+	}
+	var commits []types.Commit
+	for len(commits) < limit {
+		page, resp, err := c.client.Repositories.ListCommits(c.ctx, owner, repo, opts)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusConflict {
+				// An empty repository has no commits
+				return nil, nil
+			}
+			return nil, fmt.Errorf("error listing commits: %w", err)
+		}
+		for _, commit := range page {
+			if len(commits) < limit {
+				commits = append(commits, toCommit(commit))
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return commits, nil
+}
+
+// toCommit converts a commit returned by the API
+func toCommit(commit *github.RepositoryCommit) types.Commit {
+	return types.Commit{
+		SHA:     commit.GetSHA(),
+		Message: commit.GetCommit().GetMessage(),
+		Time:    commit.GetCommit().GetCommitter().GetDate().Time,
+	}
+}
+
+// splitRepo returns the owner and name of a repository given as "owner/name"
+// or as a name only, which is a repository of the authenticated user
+func (c *Client) splitRepo(repo string) (string, string, error) {
+	if owner, name, ok := strings.Cut(repo, "/"); ok {
+		return owner, name, nil
+	}
+	user, _, err := c.client.Users.Get(c.ctx, "")
+	if err != nil {
+		return "", "", err
+	}
+	return user.GetLogin(), repo, nil
+}
+
+// branchRef returns the reference of a branch. An empty repository has no
+// branches and the Git Data API cannot create the first commit, so one is
+// created through the contents API first.
+func (c *Client) branchRef(owner, repo, branch string) (*github.Reference, error) {
+	ref, resp, err := c.client.Git.GetRef(c.ctx, owner, repo, "refs/heads/"+branch)
+	if err == nil {
+		return ref, nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusConflict {
+		return nil, fmt.Errorf("error getting branch %s: %w", branch, err)
+	}
+
+	_, _, err = c.client.Repositories.CreateFile(c.ctx, owner, repo, "README.md", &github.RepositoryContentFileOptions{
+		Message: github.String("Initialize backup repository"),
+		Content: []byte("# Dotfiles\n\nBacked up with DotBack.\n"),
+		Branch:  github.String(branch),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing repository: %w", err)
+	}
+	ref, _, err = c.client.Git.GetRef(c.ctx, owner, repo, "refs/heads/"+branch)
+	if err != nil {
+		return nil, fmt.Errorf("error getting branch %s: %w", branch, err)
+	}
+	return ref, nil
+}
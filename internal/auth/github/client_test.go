@@ -3,10 +3,14 @@ package github
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/amroessam/dotback/internal/common/types"
 	"github.com/google/go-github/v60/github"
@@ -249,3 +253,279 @@ func TestCreateRepository(t *testing.T) {
 		})
 	}
 }
+
+func TestDownloadFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		response     string
+		want         string
+		wantNotFound bool
+	}{
+		{
+			name:       "Existing file",
+			statusCode: http.StatusOK,
+			response:   `{"type": "file", "encoding": "base64", "content": "aGVsbG8K"}`,
+			want:       "hello\n",
+		},
+		{
+			name:       "Large file",
+			statusCode: http.StatusOK,
+			response:   `{"type": "file", "encoding": "none", "content": "", "size": 2000000, "sha": "big-sha"}`,
+			want:       "raw blob",
+		},
+		{
+			name:         "Missing file",
+			statusCode:   http.StatusNotFound,
+			response:     `{"message": "Not Found"}`,
+			wantNotFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/v3/repos/testuser/dotfiles/git/blobs/big-sha" {
+					if !strings.Contains(r.Header.Get("Accept"), "raw") {
+						t.Errorf("Blob requested with Accept %q", r.Header.Get("Accept"))
+					}
+					w.Write([]byte("raw blob"))
+					return
+				}
+				if r.URL.Path != "/api/v3/repos/testuser/dotfiles/contents/machines/laptop/manifest.json" {
+					t.Errorf("Unexpected path %s", r.URL.Path)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(tt.response))
+			})
+			defer server.Close()
+
+			got, err := client.DownloadFile("testuser/dotfiles", "machines/laptop/manifest.json")
+			if errors.Is(err, types.ErrNotFound) != tt.wantNotFound {
+				t.Fatalf("DownloadFile() error = %v, want not found %v", err, tt.wantNotFound)
+			}
+			if string(got) != tt.want {
+				t.Errorf("DownloadFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	commit := `{"sha": "%s", "commit": {"message": "%s", "committer": {"date": "%s"}}}`
+	server, client := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		repo := "/api/v3/repos/testuser/dotfiles"
+		switch r.URL.Path {
+		case repo + "/commits/v1.0":
+			fmt.Fprintf(w, commit, "abc123", "Backup 1", "2024-05-01T12:00:00Z")
+		case repo + "/commits/nope":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message": "No commit found for SHA: nope"}`))
+		case repo + "/commits":
+			query := r.URL.Query()
+			if query.Get("path") != "machines/laptop/manifest.json" || query.Get("until") != "2024-06-01T00:00:00Z" {
+				t.Errorf("Commits listed with %v", query)
+			}
+			if query.Get("page") == "" {
+				w.Header().Set("Link", `<`+"https://api.github.com/repositories/1/commits?page=2"+`>; rel="next"`)
+				fmt.Fprintf(w, "[%s]", fmt.Sprintf(commit, "def456", "Backup 2", "2024-05-02T12:00:00Z"))
+				return
+			}
+			fmt.Fprintf(w, "[%s, %s]", fmt.Sprintf(commit, "abc123", "Backup 1", "2024-05-01T12:00:00Z"), fmt.Sprintf(commit, "000000", "Initial", "2024-04-01T12:00:00Z"))
+		case repo + "/contents/machines/laptop/manifest.json":
+			if r.URL.Query().Get("ref") != "abc123" {
+				t.Errorf("File downloaded at %q", r.URL.Query().Get("ref"))
+			}
+			w.Write([]byte(`{"type": "file", "encoding": "base64", "content": "aGVsbG8K"}`))
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	defer server.Close()
+
+	got, err := client.ResolveRef("testuser/dotfiles", "v1.0")
+	if err != nil || got.SHA != "abc123" || got.Message != "Backup 1" || got.Time.Day() != 1 {
+		t.Errorf("ResolveRef() = %+v, %v", got, err)
+	}
+	if _, err := client.ResolveRef("testuser/dotfiles", "nope"); !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("ResolveRef() of an unknown ref error = %v, want not found", err)
+	}
+
+	until := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	commits, err := client.ListCommits("testuser/dotfiles", "machines/laptop/manifest.json", until, 2)
+	if err != nil || len(commits) != 2 || commits[0].SHA != "def456" || commits[1].SHA != "abc123" {
+		t.Errorf("ListCommits() = %+v, %v", commits, err)
+	}
+
+	content, err := client.DownloadFileAt("testuser/dotfiles", "machines/laptop/manifest.json", "abc123")
+	if err != nil || string(content) != "hello\n" {
+		t.Errorf("DownloadFileAt() = %q, %v", content, err)
+	}
+}
+
+// gitDataServer fakes the endpoints used by CommitFiles and records the calls
+type gitDataServer struct {
+	t          *testing.T
+	emptyRepo  bool
+	failBlobs  bool
+	calls      []string
+	tree       map[string]interface{}
+	updatedSHA string
+}
+
+func (s *gitDataServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.calls = append(s.calls, r.Method+" "+r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	repo := "/api/v3/repos/testuser/dotfiles"
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v3/user":
+		w.Write([]byte(`{"login": "testuser"}`))
+	case r.Method == http.MethodGet && r.URL.Path == repo:
+		w.Write([]byte(`{"name": "dotfiles", "default_branch": "main"}`))
+	case r.Method == http.MethodGet && r.URL.Path == repo+"/git/ref/heads/main":
+		if s.emptyRepo {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message": "Git Repository is empty."}`))
+			return
+		}
+		w.Write([]byte(`{"ref": "refs/heads/main", "object": {"sha": "parent-sha"}}`))
+	case r.Method == http.MethodPut && r.URL.Path == repo+"/contents/README.md":
+		s.emptyRepo = false
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	case r.Method == http.MethodGet && r.URL.Path == repo+"/git/commits/parent-sha":
+		w.Write([]byte(`{"sha": "parent-sha", "tree": {"sha": "base-tree"}}`))
+	case r.Method == http.MethodPost && r.URL.Path == repo+"/git/blobs":
+		if s.failBlobs {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message": "boom"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sha": "blob-sha"}`))
+	case r.Method == http.MethodPost && r.URL.Path == repo+"/git/trees":
+		if err := decodeRequest(r, &s.tree); err != nil {
+			s.t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sha": "new-tree"}`))
+	case r.Method == http.MethodPost && r.URL.Path == repo+"/git/commits":
+		var commit struct {
+			Message string   `json:"message"`
+			Tree    string   `json:"tree"`
+			Parents []string `json:"parents"`
+		}
+		if err := decodeRequest(r, &commit); err != nil {
+			s.t.Error(err)
+		}
+		if commit.Tree != "new-tree" || len(commit.Parents) != 1 || commit.Parents[0] != "parent-sha" || commit.Message != "Backup" {
+			s.t.Errorf("Unexpected commit payload: %+v", commit)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sha": "new-commit"}`))
+	case r.Method == http.MethodPatch && r.URL.Path == repo+"/git/refs/heads/main":
+		var update struct {
+			SHA   string `json:"sha"`
+			Force bool   `json:"force"`
+		}
+		if err := decodeRequest(r, &update); err != nil {
+			s.t.Error(err)
+		}
+		s.updatedSHA = update.SHA
+		w.Write([]byte(`{"ref": "refs/heads/main", "object": {"sha": "new-commit"}}`))
+	default:
+		s.t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCommitFiles(t *testing.T) {
+	changes := []types.FileChange{
+		{Path: "machines/laptop/files/home/.bashrc", Content: []byte("alias ll='ls -l'\n")},
+		{Path: "machines/laptop/files/home/bin/deploy", Content: []byte("#!/bin/sh\n"), Executable: true},
+		{Path: "machines/laptop/files/home/.old", Delete: true},
+	}
+
+	t.Run("Single commit", func(t *testing.T) {
+		fake := &gitDataServer{t: t}
+		server, client := setupTestServer(t, fake.handle)
+		defer server.Close()
+
+		sha, err := client.CommitFiles("dotfiles", "", changes, "Backup")
+		if err != nil {
+			t.Fatalf("CommitFiles() error = %v", err)
+		}
+		if sha != "new-commit" || fake.updatedSHA != "new-commit" {
+			t.Errorf("CommitFiles() = %q, ref moved to %q", sha, fake.updatedSHA)
+		}
+
+		blobs := 0
+		for _, call := range fake.calls {
+			if strings.HasSuffix(call, "/git/blobs") {
+				blobs++
+			}
+		}
+		if blobs != 2 {
+			t.Errorf("Created %d blobs, want 2", blobs)
+		}
+
+		if fake.tree["base_tree"] != "base-tree" {
+			t.Errorf("base_tree = %v", fake.tree["base_tree"])
+		}
+		entries, _ := fake.tree["tree"].([]interface{})
+		if len(entries) != 3 {
+			t.Fatalf("tree entries = %v", fake.tree["tree"])
+		}
+		if mode := entries[1].(map[string]interface{})["mode"]; mode != "100755" {
+			t.Errorf("Executable entry mode = %v, want 100755", mode)
+		}
+		deleted := entries[2].(map[string]interface{})
+		if sha, ok := deleted["sha"]; !ok || sha != nil {
+			t.Errorf("Deleted entry = %v, want a null sha", deleted)
+		}
+	})
+
+	t.Run("Owner and name", func(t *testing.T) {
+		fake := &gitDataServer{t: t}
+		server, client := setupTestServer(t, fake.handle)
+		defer server.Close()
+
+		if _, err := client.CommitFiles("testuser/dotfiles", "main", changes, "Backup"); err != nil {
+			t.Fatalf("CommitFiles() error = %v", err)
+		}
+		for _, call := range fake.calls {
+			if call == "GET /api/v3/user" {
+				t.Error("CommitFiles() looked up the user for an owner/name repository")
+			}
+		}
+	})
+
+	t.Run("Empty repository", func(t *testing.T) {
+		fake := &gitDataServer{t: t, emptyRepo: true}
+		server, client := setupTestServer(t, fake.handle)
+		defer server.Close()
+
+		if _, err := client.CommitFiles("dotfiles", "main", changes[:1], "Backup"); err != nil {
+			t.Fatalf("CommitFiles() error = %v", err)
+		}
+		if fake.updatedSHA != "new-commit" {
+			t.Errorf("ref moved to %q", fake.updatedSHA)
+		}
+	})
+
+	t.Run("Failure leaves the branch untouched", func(t *testing.T) {
+		fake := &gitDataServer{t: t, failBlobs: true}
+		server, client := setupTestServer(t, fake.handle)
+		defer server.Close()
+
+		if _, err := client.CommitFiles("dotfiles", "main", changes, "Backup"); err == nil {
+			t.Fatal("CommitFiles() should fail when a blob cannot be created")
+		}
+		if fake.updatedSHA != "" {
+			t.Errorf("ref moved to %q after a failure", fake.updatedSHA)
+		}
+	})
+}
@@ -2,15 +2,38 @@ package github
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/amroessam/dotback/internal/common/types"
 )
 
-// MockClient implements GitHubClient interface for testing
+// MockClient implements GitHubClient interface for testing. Repositories
+// and uploaded files are kept in memory so tests can inspect them.
 type MockClient struct {
 	token        string
 	shouldFail   bool
 	mockUsername string
+
+	// Repos are returned by ListRepositories; CreateRepository adds to them
+	Repos []types.Repository
+	// Files maps "repo/path" to the uploaded content
+	Files map[string][]byte
+	// Commits holds the message of every CommitFiles call
+	Commits []string
+	// History holds every CommitFiles call with the files after it, oldest
+	// first. Tags maps tag names to commit SHAs.
+	History []MockCommit
+	Tags    map[string]string
+	// Now returns the time of new commits; nil means time.Now
+	Now func() time.Time
+}
+
+// MockCommit is a commit made through a MockClient
+type MockCommit struct {
+	types.Commit
+	Files map[string][]byte
 }
 
 // NewMockClient creates a new mock client
@@ -19,6 +42,8 @@ func NewMockClient(token string, shouldFail bool, mockUsername string) *MockClie
 		token:        token,
 		shouldFail:   shouldFail,
 		mockUsername: mockUsername,
+		Files:        make(map[string][]byte),
+		Tags:         make(map[string]string),
 	}
 }
 
@@ -40,16 +65,24 @@ func (c *MockClient) ListRepositories() ([]types.Repository, error) {
 	if c.shouldFail {
 		return nil, fmt.Errorf("mock list repositories failed")
 	}
-	return []types.Repository{}, nil
+	return append([]types.Repository{}, c.Repos...), nil
 }
 
 func (c *MockClient) CreateRepository(name, description string, private bool) error {
 	if c.shouldFail {
 		return fmt.Errorf("mock create repository failed")
 	}
+	c.Repos = append(c.Repos, types.Repository{Owner: c.mockUsername, Name: name, Description: description, Private: private})
 	return nil
 }
 
+func (c *MockClient) DefaultBranch(repo string) (string, error) {
+	if c.shouldFail {
+		return "", fmt.Errorf("mock default branch failed")
+	}
+	return "main", nil
+}
+
 func (c *MockClient) DeleteRepository(name string) error {
 	if c.shouldFail {
 		return fmt.Errorf("mock delete repository failed")
@@ -61,6 +94,7 @@ func (c *MockClient) UploadFile(repo, path string, content []byte, message strin
 	if c.shouldFail {
 		return fmt.Errorf("mock upload file failed")
 	}
+	c.Files[repo+"/"+path] = content
 	return nil
 }
 
@@ -68,12 +102,138 @@ func (c *MockClient) DownloadFile(repo, path string) ([]byte, error) {
 	if c.shouldFail {
 		return nil, fmt.Errorf("mock download file failed")
 	}
-	return []byte("mock content"), nil
+	if content, ok := c.Files[repo+"/"+path]; ok {
+		return content, nil
+	}
+	return nil, fmt.Errorf("mock download %s: %w", path, types.ErrNotFound)
 }
 
-func (c *MockClient) ListFiles(repo, path string) ([]string, error) {
+func (c *MockClient) ListFiles(repo, dir string) ([]string, error) {
 	if c.shouldFail {
 		return nil, fmt.Errorf("mock list files failed")
 	}
-	return []string{}, nil
-} 
\ No newline at end of file
+
+	// Like the contents API, only the entries directly inside dir are listed
+	prefix := repo + "/" + strings.Trim(dir, "/") + "/"
+	seen := make(map[string]bool)
+	files := []string{}
+	for key := range c.Files {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			name, _, _ := strings.Cut(rest, "/")
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+} 
+func (c *MockClient) CommitFiles(repo, branch string, changes []types.FileChange, message string) (string, error) {
+	if c.shouldFail {
+		return "", fmt.Errorf("mock commit files failed")
+	}
+	for _, change := range changes {
+		if change.Delete {
+			delete(c.Files, repo+"/"+change.Path)
+		} else {
+			c.Files[repo+"/"+change.Path] = change.Content
+		}
+	}
+	c.Commits = append(c.Commits, message)
+	sha := fmt.Sprintf("mock-commit-%d", len(c.Commits))
+
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+	files := make(map[string][]byte, len(c.Files))
+	for key, content := range c.Files {
+		files[key] = content
+	}
+	c.History = append(c.History, MockCommit{Commit: types.Commit{SHA: sha, Message: message, Time: now}, Files: files})
+	return sha, nil
+}
+
+func (c *MockClient) ResolveRef(repo, ref string) (types.Commit, error) {
+	if c.shouldFail {
+		return types.Commit{}, fmt.Errorf("mock resolve ref failed")
+	}
+	if commit, ok := c.commit(ref); ok {
+		return commit.Commit, nil
+	}
+	return types.Commit{}, fmt.Errorf("mock resolve %s: %w", ref, types.ErrNotFound)
+}
+
+func (c *MockClient) ListCommits(repo, path string, until time.Time, limit int) ([]types.Commit, error) {
+	if c.shouldFail {
+		return nil, fmt.Errorf("mock list commits failed")
+	}
+
+	// Like the commits API, newest first and only commits that changed path
+	var commits []types.Commit
+	for i := len(c.History) - 1; i >= 0 && len(commits) < limit; i-- {
+		commit := c.History[i]
+		if !until.IsZero() && commit.Time.After(until) {
+			continue
+		}
+		var before map[string][]byte
+		if i > 0 {
+			before = c.History[i-1].Files
+		}
+		if path == "" || changed(before, commit.Files, repo+"/"+strings.Trim(path, "/")) {
+			commits = append(commits, commit.Commit)
+		}
+	}
+	return commits, nil
+}
+
+func (c *MockClient) DownloadFileAt(repo, path, ref string) ([]byte, error) {
+	if ref == "" {
+		return c.DownloadFile(repo, path)
+	}
+	if c.shouldFail {
+		return nil, fmt.Errorf("mock download file failed")
+	}
+	commit, ok := c.commit(ref)
+	if !ok {
+		return nil, fmt.Errorf("mock download %s at %s: %w", path, ref, types.ErrNotFound)
+	}
+	if content, ok := commit.Files[repo+"/"+path]; ok {
+		return content, nil
+	}
+	return nil, fmt.Errorf("mock download %s: %w", path, types.ErrNotFound)
+}
+
+// commit finds the commit a SHA, tag or "main" refers to
+func (c *MockClient) commit(ref string) (MockCommit, bool) {
+	if sha, ok := c.Tags[ref]; ok {
+		ref = sha
+	}
+	if ref == "main" && len(c.History) > 0 {
+		return c.History[len(c.History)-1], true
+	}
+	for _, commit := range c.History {
+		if commit.SHA == ref {
+			return commit, true
+		}
+	}
+	return MockCommit{}, false
+}
+
+// changed reports whether the files at or below path differ between two
+// commits
+func changed(before, after map[string][]byte, path string) bool {
+	inPath := func(key string) bool { return key == path || strings.HasPrefix(key, path+"/") }
+	for key, content := range after {
+		if old, ok := before[key]; inPath(key) && (!ok || string(old) != string(content)) {
+			return true
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; inPath(key) && !ok {
+			return true
+		}
+	}
+	return false
+}
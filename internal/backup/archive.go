@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+const (
+	// FormatFiles stores every file at its own path in the repository
+	FormatFiles = "files"
+	// FormatArchive packs the files into one compressed snapshot per backup
+	FormatArchive = "archive"
+
+	// SnapshotsDir holds a machine's snapshot archives
+	SnapshotsDir = "snapshots"
+	// SnapshotExt is the extension of snapshot archives. They are gzip
+	// compressed tarballs, since zstd is not in the standard library.
+	SnapshotExt = ".tar.gz"
+)
+
+// CheckFormat returns an error unless format is empty or a known layout
+func CheckFormat(format string) error {
+	switch format {
+	case "", FormatFiles, FormatArchive:
+		return nil
+	}
+	return fmt.Errorf("invalid format %q, expected %q or %q", format, FormatFiles, FormatArchive)
+}
+
+// SnapshotPath returns where the snapshot of a backup made at t is stored,
+// e.g. "machines/laptop/snapshots/20240501T120000Z.tar.gz"
+func SnapshotPath(machine string, t time.Time) string {
+	return path.Join(MachinePath(machine), SnapshotsDir, t.UTC().Format("20060102T150405Z")+SnapshotExt)
+}
+
+// Snapshot is the content of a snapshot archive
+type Snapshot struct {
+	// Manifest is the manifest embedded in the archive
+	Manifest *Manifest
+	// Files maps portable paths to content
+	Files map[string][]byte
+}
+
+// snapshotEntry is a file packed into a snapshot archive
+type snapshotEntry struct {
+	file    types.DotFile
+	content []byte
+}
+
+// writeSnapshot packs the manifest and files into a gzip compressed
+// tarball. Files are stored at their repository paths relative to the
+// machine's directory, e.g. "files/home/.bashrc".
+func writeSnapshot(manifest *Manifest, entries []snapshotEntry) ([]byte, error) {
+	data, err := manifest.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	write := func(header *tar.Header, content []byte) error {
+		if header.Typeflag == tar.TypeSymlink {
+			return tw.WriteHeader(header)
+		}
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	if err := write(&tar.Header{Name: ManifestFile, Mode: 0644, ModTime: manifest.LastSync}, data); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+	machineDir := MachinePath(manifest.Hostname) + "/"
+	for _, entry := range entries {
+		mode, err := strconv.ParseInt(entry.file.Mode, 8, 64)
+		if err != nil {
+			mode = 0644
+		}
+		header := &tar.Header{
+			Name:    strings.TrimPrefix(RepoPath(manifest.Hostname, entry.file.Path), machineDir),
+			Mode:    mode,
+			ModTime: entry.file.LastModified,
+		}
+		if entry.file.IsSymlink && entry.file.LinkTarget != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.file.LinkTarget
+		}
+		if err := write(header, entry.content); err != nil {
+			return nil, fmt.Errorf("error writing %s to snapshot: %w", entry.file.Path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadSnapshot unpacks a snapshot archive of a machine. The content of a
+// symlink is its target, as in the files layout.
+func ReadSnapshot(data []byte, machine string) (*Snapshot, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	snapshot := &Snapshot{Files: make(map[string][]byte)}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		var content []byte
+		switch header.Typeflag {
+		case tar.TypeReg:
+			if content, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("invalid snapshot: %w", err)
+			}
+		case tar.TypeSymlink:
+			content = []byte(header.Linkname)
+		default:
+			continue
+		}
+
+		if header.Name == ManifestFile {
+			if snapshot.Manifest, err = ParseManifest(content); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		portable, err := PortablePath(machine, path.Join(MachinePath(machine), header.Name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		snapshot.Files[portable] = content
+	}
+	if snapshot.Manifest == nil {
+		return nil, fmt.Errorf("invalid snapshot: no manifest")
+	}
+	return snapshot, nil
+}
+
+// FetchSnapshot downloads and unpacks the snapshot a manifest points to
+func FetchSnapshot(client types.GitHubClient, repo string, manifest *Manifest) (*Snapshot, error) {
+	paths := []string{manifest.Snapshot}
+	if manifest.SnapshotChunks > 0 {
+		paths = paths[:0]
+		for i := 0; i < manifest.SnapshotChunks; i++ {
+			paths = append(paths, ChunkPath(manifest.Snapshot, i))
+		}
+	}
+
+	var data bytes.Buffer
+	for _, p := range paths {
+		part, err := client.DownloadFile(repo, p)
+		if err != nil {
+			return nil, fmt.Errorf("error downloading snapshot %s: %w", manifest.Snapshot, err)
+		}
+		data.Write(part)
+	}
+	return ReadSnapshot(data.Bytes(), manifest.Hostname)
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestSnapshotPath(t *testing.T) {
+	at := time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	if got := SnapshotPath("laptop", at); got != "machines/laptop/snapshots/20240501T120000Z.tar.gz" {
+		t.Errorf("SnapshotPath() = %q", got)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range []string{"", FormatFiles, FormatArchive} {
+		if err := CheckFormat(format); err != nil {
+			t.Errorf("CheckFormat(%q) error = %v", format, err)
+		}
+	}
+	if err := CheckFormat("zip"); err == nil {
+		t.Error("CheckFormat() accepted an unknown format")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	manifest := NewManifest(types.Machine{
+		Hostname: "laptop",
+		DotFiles: []types.DotFile{{Path: "~/.bashrc"}, {Path: "$XDG_CONFIG_HOME/nvim/init.lua"}},
+	})
+	data, err := writeSnapshot(manifest, []snapshotEntry{
+		{file: types.DotFile{Path: "~/.bashrc", Mode: "0644", LastModified: modified}, content: []byte("alias ll='ls -l'\n")},
+		{file: types.DotFile{Path: "$XDG_CONFIG_HOME/nvim/init.lua", Mode: "0600"}, content: []byte("vim.opt.number = true\n")},
+	})
+	if err != nil {
+		t.Fatalf("writeSnapshot() error = %v", err)
+	}
+
+	snapshot, err := ReadSnapshot(data, "laptop")
+	if err != nil {
+		t.Fatalf("ReadSnapshot() error = %v", err)
+	}
+	if snapshot.Manifest.Hostname != "laptop" || len(snapshot.Manifest.DotFiles) != 2 {
+		t.Errorf("Embedded manifest = %+v", snapshot.Manifest)
+	}
+	if got := string(snapshot.Files["$XDG_CONFIG_HOME/nvim/init.lua"]); got != "vim.opt.number = true\n" {
+		t.Errorf("init.lua = %q", got)
+	}
+	if len(snapshot.Files) != 2 {
+		t.Errorf("ReadSnapshot() files = %v", snapshot.Files)
+	}
+
+	if _, err := ReadSnapshot([]byte("not an archive"), "laptop"); err == nil {
+		t.Error("ReadSnapshot() accepted invalid data")
+	}
+	empty, _ := writeSnapshot(manifest, nil)
+	if _, err := ReadSnapshot(empty, "desktop"); err != nil {
+		t.Errorf("ReadSnapshot() of an empty snapshot error = %v", err)
+	}
+}
@@ -0,0 +1,501 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/amroessam/dotback/internal/scan"
+)
+
+const (
+	// MachinesDir holds one directory per machine in the backup repository
+	MachinesDir = "machines"
+	// FilesDir holds a machine's backed up files
+	FilesDir = "files"
+
+	// DefaultRepository is suggested when creating a new backup repository
+	DefaultRepository = "dotfiles"
+)
+
+// repoRoots maps the first element of a portable path to the directory it
+// is stored under, so that no repository path starts with ~ or $
+var repoRoots = []struct {
+	portable string
+	repo     string
+}{
+	{"~", "home"},
+	{xdg.ConfigHomeVar, "config"},
+	{xdg.DataHomeVar, "data"},
+	{xdg.StateHomeVar, "state"},
+	{xdg.CacheHomeVar, "cache"},
+}
+
+// MachinePath returns the directory of a machine in the backup repository
+func MachinePath(machine string) string {
+	return path.Join(MachinesDir, machine)
+}
+
+// RepoPath returns where a file is stored in the backup repository, given
+// its portable path, e.g. "~/.bashrc" becomes
+// "machines/<machine>/files/home/.bashrc" and "$XDG_CONFIG_HOME/nvim/init.lua"
+// becomes "machines/<machine>/files/config/nvim/init.lua". Absolute paths
+// outside the home directory are stored under "root".
+func RepoPath(machine, portable string) string {
+	base := path.Join(MachinePath(machine), FilesDir)
+	first, rest, _ := strings.Cut(portable, "/")
+	for _, root := range repoRoots {
+		if first == root.portable {
+			return path.Join(base, root.repo, rest)
+		}
+	}
+	return path.Join(base, "root", strings.TrimPrefix(portable, "/"))
+}
+
+// PortablePath is the inverse of RepoPath
+func PortablePath(machine, repoPath string) (string, error) {
+	base := path.Join(MachinePath(machine), FilesDir) + "/"
+	if !strings.HasPrefix(repoPath, base) {
+		return "", fmt.Errorf("%s is not a file of machine %s", repoPath, machine)
+	}
+	first, rest, _ := strings.Cut(strings.TrimPrefix(repoPath, base), "/")
+	if first == "root" {
+		return "/" + rest, nil
+	}
+	for _, root := range repoRoots {
+		if first == root.repo {
+			return path.Join(root.portable, rest), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a file of machine %s", repoPath, machine)
+}
+
+// PortableFiles returns copies of the files with portable paths, which is
+// how they are recorded in the machine state
+func PortableFiles(dirs xdg.Dirs, files []types.DotFile) []types.DotFile {
+	result := make([]types.DotFile, len(files))
+	for i, file := range files {
+		file.Path = dirs.Portable(file.Path)
+		result[i] = file
+	}
+	return result
+}
+
+// PortableApps returns copies of the apps with portable config file paths
+func PortableApps(dirs xdg.Dirs, apps []types.App) []types.App {
+	result := make([]types.App, len(apps))
+	for i, app := range apps {
+		app.ConfigFiles = PortableFiles(dirs, app.ConfigFiles)
+		result[i] = app
+	}
+	return result
+}
+
+// Backup uploads files to a machine's directory in a backup repository
+type Backup struct {
+	Client  types.GitHubClient
+	FS      types.FileSystem
+	Dirs    xdg.Dirs
+	Repo    string
+	Machine string
+	Message string
+	// Branch is the branch to commit to; empty means the default branch
+	Branch string
+	// Apps, Labels and Description are recorded in the manifest
+	Apps        []types.App
+	Labels      map[string]string
+	Description string
+	// Time is recorded as the machine's last sync
+	Time time.Time
+	// Previous is the machine's manifest in the repository, if it has one.
+	// Files whose hash it already records are not uploaded again.
+	Previous *Manifest
+	// KeepDeleted keeps files that no longer exist locally in the backup
+	KeepDeleted bool
+	// Files larger than ChunkSize are uploaded in parts of that size, and
+	// SizePolicy applies to files larger than LargeFileSize. Zero values
+	// mean the defaults; a negative LargeFileSize disables the policy.
+	ChunkSize     int64
+	LargeFileSize int64
+	SizePolicy    string
+	// Format is FormatFiles, the default, or FormatArchive
+	Format string
+	// OnUpload is called before each file is read and staged for the commit
+	OnUpload func(done, total int, file types.DotFile)
+}
+
+// Result describes a finished backup
+type Result struct {
+	// Manifest is the machine's manifest after the backup, with portable paths
+	Manifest *Manifest
+	// Commit is the SHA of the backup commit, or empty if nothing changed
+	Commit string
+
+	Uploaded int // Files added or changed since the previous backup
+	Skipped  int // Files already up to date in the repository
+	Removed  int // Files deleted from the repository
+	Kept     int // Files kept in the repository although deleted locally
+}
+
+// Run plans the backup of the files and applies the plan right away
+func (b *Backup) Run(files []types.DotFile) (*Result, error) {
+	plan, err := b.Plan(files)
+	if err != nil {
+		return nil, err
+	}
+	return b.Apply(plan)
+}
+
+// Plan works out what a backup of the files would change without writing
+// to the repository. Files that changed since the previous backup are added
+// or updated. Files of the previous backup that were not passed in stay in
+// the backup while they exist locally; those deleted locally are deleted
+// from the repository unless KeepDeleted is set. Large files are split into
+// chunks, and warned about or left out according to SizePolicy. In the
+// archive format every file is packed into a new snapshot instead.
+func (b *Backup) Plan(files []types.DotFile) (*Plan, error) {
+	if err := CheckSizePolicy(b.SizePolicy); err != nil {
+		return nil, err
+	}
+	if err := CheckFormat(b.Format); err != nil {
+		return nil, err
+	}
+	format := b.Format
+	if format == "" {
+		format = FormatFiles
+	}
+
+	branch := b.Branch
+	if branch == "" {
+		var err error
+		if branch, err = b.Client.DefaultBranch(b.Repo); err != nil {
+			return nil, fmt.Errorf("error getting default branch: %w", err)
+		}
+	}
+
+	plan := &Plan{
+		Version:     PlanVersion,
+		CreatedAt:   b.Time,
+		Repo:        b.Repo,
+		Branch:      branch,
+		Machine:     b.Machine,
+		MachinePath: MachinePath(b.Machine),
+		Message:     b.Message,
+		Format:      format,
+		ChunkSize:   b.chunkSize(),
+	}
+	if b.Previous != nil {
+		lastSync := b.Previous.LastSync
+		plan.PreviousSync = &lastSync
+	}
+
+	local := make(map[string]string, len(files))
+	current := make([]types.DotFile, 0, len(files))
+	for _, file := range files {
+		portable := file
+		portable.Path = b.Dirs.Portable(file.Path)
+		if b.SizePolicy == SizePolicyReject && b.large(file) {
+			logger.Debug("Leaving out %s, which is larger than %d bytes", file.Path, b.largeFileSize())
+			plan.Rejected = append(plan.Rejected, portable.Path)
+			continue
+		}
+		local[portable.Path] = file.Path
+		current = append(current, portable)
+	}
+
+	if format == FormatArchive {
+		b.planArchive(plan, current, local)
+	} else {
+		b.planFiles(plan, current, local)
+	}
+	return plan, nil
+}
+
+// planFiles plans a backup in the files layout, where only changed files are
+// uploaded and the manifest keeps files not selected this time
+func (b *Backup) planFiles(plan *Plan, current []types.DotFile, local map[string]string) {
+	// Files of a snapshot have to be uploaded again
+	var previous []types.DotFile
+	if b.Previous != nil && !b.Previous.Archived() {
+		previous = b.Previous.DotFiles
+	}
+	index := make(map[string]int, len(current))
+	for i := range current {
+		current[i].Chunks = chunkCount(current[i].Size, plan.ChunkSize)
+		index[current[i].Path] = i
+	}
+
+	var carried []types.DotFile
+	for _, change := range scan.Diff(previous, current) {
+		switch {
+		case change.Kind == scan.ChangeUnchanged && change.New.Hash != "":
+			// Stays stored the way it was, even if the chunk size changed
+			current[index[change.Path]].Chunks = change.Old.Chunks
+			plan.Skipped++
+		case change.Kind == scan.ChangeAdded:
+			b.planUpload(plan, ActionAdd, current[index[change.Path]], nil, local[change.Path])
+		case change.Kind != scan.ChangeDeleted:
+			b.planUpload(plan, ActionUpdate, current[index[change.Path]], change.Old, local[change.Path])
+		case b.FS.Exists(b.Dirs.Resolve(change.Path)):
+			// Not selected this time, but still there
+			carried = append(carried, *change.Old)
+			plan.Skipped++
+		case b.KeepDeleted:
+			carried = append(carried, *change.Old)
+			plan.Kept++
+		default:
+			plan.add(ActionDelete, *change.Old, change.Old, "", b.Machine)
+		}
+	}
+	plan.Manifest = b.newManifest(append(current, carried...))
+}
+
+// planArchive plans a snapshot of exactly the selected files. Every file is
+// added to the new snapshot, and files of the machine stored in the files
+// layout are deleted from the repository.
+func (b *Backup) planArchive(plan *Plan, current []types.DotFile, local map[string]string) {
+	plan.Snapshot = SnapshotPath(b.Machine, b.Time)
+	for _, file := range current {
+		b.planUpload(plan, ActionAdd, file, nil, local[file.Path])
+		plan.Files[len(plan.Files)-1].RepoPath = plan.Snapshot
+	}
+	if b.Previous != nil && !b.Previous.Archived() {
+		for _, file := range b.Previous.DotFiles {
+			plan.Obsolete = append(plan.Obsolete, StoredPaths(b.Machine, file)...)
+		}
+	}
+
+	plan.Manifest = b.newManifest(current)
+	plan.Manifest.Format = FormatArchive
+	plan.Manifest.Snapshot = plan.Snapshot
+}
+
+// planUpload adds a file to upload to the plan, warning if it is large
+func (b *Backup) planUpload(plan *Plan, action PlanAction, file types.DotFile, old *types.DotFile, source string) {
+	if b.large(file) {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s is %d bytes, larger than %d", file.Path, file.Size, b.largeFileSize()))
+	}
+	plan.add(action, file, old, source, b.Machine)
+}
+
+// chunkSize returns the size large files are split at
+func (b *Backup) chunkSize() int64 {
+	if b.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return b.ChunkSize
+}
+
+// largeFileSize returns the size above which the size policy applies, or a
+// negative number if it never does
+func (b *Backup) largeFileSize() int64 {
+	if b.LargeFileSize == 0 {
+		return DefaultLargeFileSize
+	}
+	return b.LargeFileSize
+}
+
+// large reports whether the size policy applies to a file
+func (b *Backup) large(file types.DotFile) bool {
+	limit := b.largeFileSize()
+	return limit > 0 && file.Size > limit
+}
+
+// newManifest returns the manifest of the backup with the given files
+func (b *Backup) newManifest(files []types.DotFile) *Manifest {
+	return NewManifest(types.Machine{
+		Hostname:    b.Machine,
+		LastSync:    b.Time,
+		DotFiles:    files,
+		Apps:        b.manifestApps(),
+		Labels:      b.Labels,
+		Description: b.Description,
+	})
+}
+
+// Apply carries out a plan in a single commit together with the machine's
+// manifest. It fails with ErrStalePlan if the machine's backup or one of
+// the files to upload changed since the plan was made. Nothing is written
+// to the repository unless every file could be read and the commit
+// succeeded, and no commit is made when the plan has no changes.
+func (b *Backup) Apply(plan *Plan) (*Result, error) {
+	remote, err := FetchManifest(b.Client, plan.Repo, plan.Machine)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.basedOn(remote) {
+		return nil, fmt.Errorf("the backup of %s changed: %w", plan.Machine, ErrStalePlan)
+	}
+
+	result := &Result{Skipped: plan.Skipped, Kept: plan.Kept}
+	if plan.UpToDate() {
+		logger.Debug("Backup of %s is up to date", plan.Machine)
+		result.Manifest = remote
+		return result, nil
+	}
+
+	var changes []types.FileChange
+	manifest := plan.Manifest
+	if plan.Format == FormatArchive {
+		changes, manifest, err = b.archiveChanges(plan, result)
+	} else {
+		changes, err = b.fileChanges(plan, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range plan.Obsolete {
+		changes = append(changes, types.FileChange{Path: path, Delete: true})
+	}
+
+	data, err := manifest.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manifest: %w", err)
+	}
+	changes = append(changes, types.FileChange{Path: ManifestPath(plan.Machine), Content: data})
+
+	sha, err := b.Client.CommitFiles(plan.Repo, plan.Branch, changes, plan.Message)
+	if err != nil {
+		return nil, fmt.Errorf("error committing %d changes: %w", len(changes), err)
+	}
+	logger.Debug("Committed %d changes to %s as %s", len(changes), plan.Repo, sha)
+	result.Manifest = manifest
+	result.Commit = sha
+	return result, nil
+}
+
+// linkReader is implemented by file systems that can read symlinks
+type linkReader interface {
+	Readlink(path string) (string, error)
+}
+
+// readPlanned reads a file to upload and checks that it did not change
+// since the plan was made. The content of a preserved symlink is its
+// target.
+func (b *Backup) readPlanned(file PlannedFile, done, total int) ([]byte, error) {
+	if b.OnUpload != nil {
+		b.OnUpload(done, total, types.DotFile{Path: file.Path, Hash: file.Hash, Size: file.Size, IsSymlink: file.IsSymlink})
+	}
+	if file.IsSymlink && file.LinkTarget != "" {
+		if links, ok := b.FS.(linkReader); ok {
+			target, err := links.Readlink(file.Source)
+			if err != nil {
+				return nil, fmt.Errorf("error reading link %s: %w", file.Source, err)
+			}
+			if target != file.LinkTarget {
+				return nil, fmt.Errorf("%s changed: %w", file.Source, ErrStalePlan)
+			}
+		}
+		return []byte(file.LinkTarget), nil
+	}
+	content, err := b.FS.ReadFile(file.Source)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", file.Source, err)
+	}
+	if !file.matches(content) {
+		return nil, fmt.Errorf("%s changed: %w", file.Source, ErrStalePlan)
+	}
+	return content, nil
+}
+
+// fileChanges stages the planned files at their own paths, splitting large
+// ones into chunks
+func (b *Backup) fileChanges(plan *Plan, result *Result) ([]types.FileChange, error) {
+	uploads := plan.Count(ActionAdd) + plan.Count(ActionUpdate)
+	changes := make([]types.FileChange, 0, len(plan.Files)+1)
+	for _, file := range plan.Files {
+		for _, path := range file.Obsolete {
+			changes = append(changes, types.FileChange{Path: path, Delete: true})
+		}
+		if file.Action == ActionDelete {
+			logger.Debug("Removing %s, which was deleted locally", file.Path)
+			result.Removed++
+			continue
+		}
+
+		content, err := b.readPlanned(file, result.Uploaded, uploads)
+		if err != nil {
+			return nil, err
+		}
+		if file.Chunks == 0 {
+			logger.Debug("Staging %s as %s", file.Source, file.RepoPath)
+			changes = append(changes, types.FileChange{Path: file.RepoPath, Content: content, Executable: file.executable()})
+			result.Uploaded++
+			continue
+		}
+		chunks := splitChunks(content, plan.ChunkSize)
+		if len(chunks) != file.Chunks {
+			return nil, fmt.Errorf("%s changed size: %w", file.Source, ErrStalePlan)
+		}
+		logger.Debug("Staging %s as %d chunks", file.Source, len(chunks))
+		for i, chunk := range chunks {
+			changes = append(changes, types.FileChange{Path: ChunkPath(file.RepoPath, i), Content: chunk})
+		}
+		result.Uploaded++
+	}
+	return changes, nil
+}
+
+// archiveChanges packs the planned files and the manifest into a snapshot,
+// split into chunks if it is large. It returns the manifest to commit next
+// to the snapshot, which records the number of chunks.
+func (b *Backup) archiveChanges(plan *Plan, result *Result) ([]types.FileChange, *Manifest, error) {
+	modified := make(map[string]time.Time, len(plan.Manifest.DotFiles))
+	for _, file := range plan.Manifest.DotFiles {
+		modified[file.Path] = file.LastModified
+	}
+
+	entries := make([]snapshotEntry, 0, len(plan.Files))
+	for _, file := range plan.Files {
+		content, err := b.readPlanned(file, result.Uploaded, len(plan.Files))
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, snapshotEntry{
+			file:    types.DotFile{Path: file.Path, Mode: file.Mode, LastModified: modified[file.Path], IsSymlink: file.IsSymlink, LinkTarget: file.LinkTarget},
+			content: content,
+		})
+		result.Uploaded++
+	}
+	data, err := writeSnapshot(plan.Manifest, entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := *plan.Manifest
+	chunks := splitChunks(data, plan.ChunkSize)
+	if len(chunks) == 1 {
+		logger.Debug("Staging snapshot %s of %d files", plan.Snapshot, len(entries))
+		return []types.FileChange{{Path: plan.Snapshot, Content: data}}, &manifest, nil
+	}
+	logger.Debug("Staging snapshot %s of %d files as %d chunks", plan.Snapshot, len(entries), len(chunks))
+	manifest.SnapshotChunks = len(chunks)
+	changes := make([]types.FileChange, len(chunks))
+	for i, chunk := range chunks {
+		changes[i] = types.FileChange{Path: ChunkPath(plan.Snapshot, i), Content: chunk}
+	}
+	return changes, &manifest, nil
+}
+
+// manifestApps returns the apps backed up now, followed by those of the
+// previous backup that were not selected this time
+func (b *Backup) manifestApps() []types.App {
+	apps := PortableApps(b.Dirs, b.Apps)
+	if b.Previous == nil {
+		return apps
+	}
+	seen := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		seen[app.Name] = true
+	}
+	for _, app := range b.Previous.Apps {
+		if !seen[app.Name] {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
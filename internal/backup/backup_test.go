@@ -0,0 +1,263 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/auth/github"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/amroessam/dotback/internal/scan"
+)
+
+func TestRepoPath(t *testing.T) {
+	tests := []struct {
+		portable string
+		want     string
+	}{
+		{portable: "~/.bashrc", want: "machines/laptop/files/home/.bashrc"},
+		{portable: "$XDG_CONFIG_HOME/nvim/init.lua", want: "machines/laptop/files/config/nvim/init.lua"},
+		{portable: "$XDG_DATA_HOME/app/db", want: "machines/laptop/files/data/app/db"},
+		{portable: "/etc/hosts", want: "machines/laptop/files/root/etc/hosts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.portable, func(t *testing.T) {
+			got := RepoPath("laptop", tt.portable)
+			if got != tt.want {
+				t.Errorf("RepoPath() = %q, want %q", got, tt.want)
+			}
+			back, err := PortablePath("laptop", got)
+			if err != nil || back != tt.portable {
+				t.Errorf("PortablePath(%q) = %q, %v, want %q", got, back, err, tt.portable)
+			}
+		})
+	}
+
+	if _, err := PortablePath("laptop", "machines/desktop/files/home/.bashrc"); err == nil {
+		t.Error("PortablePath() accepted a file of another machine")
+	}
+}
+
+// newTestBackup writes files into a temporary home directory and returns a
+// backup of machine "laptop" to repository "dotfiles" of a mock client
+func newTestBackup(t *testing.T, files map[string]string) (string, *Backup, *github.MockClient) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	for path, content := range files {
+		full := filepath.Join(home, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	dirs, err := xdg.Load()
+	if err != nil {
+		t.Fatalf("xdg.Load() error = %v", err)
+	}
+	fs, err := scan.NewFileSystem(scan.Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	client := github.NewMockClient("token", false, "user")
+	return home, &Backup{
+		Client:  client,
+		FS:      fs,
+		Dirs:    dirs,
+		Repo:    "dotfiles",
+		Machine: "laptop",
+		Message: "Backup",
+	}, client
+}
+
+func TestBackupRun(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{
+		".bashrc":               "alias ll='ls -l'\n",
+		".config/nvim/init.lua": "vim.opt.number = true\n",
+	})
+	var progress []string
+	b.OnUpload = func(done, total int, file types.DotFile) {
+		progress = append(progress, file.Path)
+	}
+	files := []types.DotFile{
+		{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n")},
+		{Path: filepath.Join(home, ".config/nvim/init.lua"), Hash: hashOf("vim.opt.number = true\n")},
+	}
+
+	result, err := b.Run(files)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	uploaded := result.Manifest.DotFiles
+	if result.Commit == "" || len(client.Commits) != 1 || client.Commits[0] != "Backup" {
+		t.Errorf("Run() made commits %v, returned %q", client.Commits, result.Commit)
+	}
+	if len(uploaded) != 2 || uploaded[0].Path != "~/.bashrc" || uploaded[1].Path != "$XDG_CONFIG_HOME/nvim/init.lua" {
+		t.Errorf("Run() = %v", uploaded)
+	}
+	if result.Uploaded != 2 || result.Skipped != 0 || result.Removed != 0 {
+		t.Errorf("Run() = %+v", result)
+	}
+	if len(progress) != 2 {
+		t.Errorf("OnUpload called %d times", len(progress))
+	}
+	if got := string(client.Files["dotfiles/machines/laptop/files/config/nvim/init.lua"]); got != "vim.opt.number = true\n" {
+		t.Errorf("Uploaded init.lua = %q", got)
+	}
+
+	// The manifest is part of the same commit
+	remote, err := FetchManifest(client, "dotfiles", "laptop")
+	if err != nil || remote == nil {
+		t.Fatalf("FetchManifest() = %v, %v", remote, err)
+	}
+	if remote.Version != 1 || remote.Hostname != "laptop" || len(remote.DotFiles) != 2 || remote.DotFiles[0].Hash != files[0].Hash {
+		t.Errorf("Remote manifest = %+v", remote)
+	}
+
+	// A failed commit stops the backup
+	b.Client = github.NewMockClient("token", true, "user")
+	if _, err := b.Run(files); err == nil {
+		t.Error("Run() with a failing client should return an error")
+	}
+
+	// An unreadable file is caught before anything is committed
+	client = github.NewMockClient("token", false, "user")
+	b.Client = client
+	missing := append(files, types.DotFile{Path: filepath.Join(home, ".missing")})
+	if _, err := b.Run(missing); err == nil {
+		t.Error("Run() with a missing file should return an error")
+	}
+	if len(client.Commits) != 0 || len(client.Files) != 0 {
+		t.Errorf("Run() committed %v after a read error", client.Commits)
+	}
+}
+
+func TestBackupRunIncremental(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{
+		".bashrc": "alias ll='ls -l'\n",
+		".zshrc":  "setopt autocd\n",
+		".vimrc":  "set number\n",
+	})
+	bashrc := types.DotFile{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n")}
+	zshrc := types.DotFile{Path: filepath.Join(home, ".zshrc"), Hash: hashOf("setopt autocd\n")}
+	vimrc := types.DotFile{Path: filepath.Join(home, ".vimrc"), Hash: hashOf("set number\n")}
+	if _, err := b.Run([]types.DotFile{bashrc, zshrc, vimrc}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// Nothing changed: no commit
+	previous, _ := FetchManifest(client, "dotfiles", "laptop")
+	b.Previous = previous
+	result, err := b.Run([]types.DotFile{bashrc, zshrc, vimrc})
+	if err != nil || result.Commit != "" || result.Skipped != 3 || len(client.Commits) != 1 {
+		t.Fatalf("Run() = %+v, %v, commits %v", result, err, client.Commits)
+	}
+
+	// .bashrc changed, .zshrc was deleted and .vimrc was not selected
+	if err := os.Remove(zshrc.Path); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(bashrc.Path, []byte("alias la='ls -a'\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	bashrc.Hash = hashOf("alias la='ls -a'\n")
+	uploads := 0
+	b.OnUpload = func(done, total int, file types.DotFile) { uploads++ }
+	result, err = b.Run([]types.DotFile{bashrc})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Uploaded != 1 || result.Skipped != 1 || result.Removed != 1 || uploads != 1 {
+		t.Errorf("Run() = %+v, %d uploads", result, uploads)
+	}
+	if _, ok := client.Files["dotfiles/machines/laptop/files/home/.zshrc"]; ok {
+		t.Error(".zshrc was not removed from the repository")
+	}
+	if _, ok := client.Files["dotfiles/machines/laptop/files/home/.vimrc"]; !ok {
+		t.Error(".vimrc was removed from the repository")
+	}
+	if got := string(client.Files["dotfiles/machines/laptop/files/home/.bashrc"]); got != "alias la='ls -a'\n" {
+		t.Errorf("Uploaded .bashrc = %q", got)
+	}
+	if paths := manifestPaths(result.Manifest); len(paths) != 2 || paths[0] != "~/.bashrc" || paths[1] != "~/.vimrc" {
+		t.Errorf("Manifest files = %v", paths)
+	}
+
+	// With KeepDeleted a file deleted locally stays in the backup
+	previous, _ = FetchManifest(client, "dotfiles", "laptop")
+	b.Previous = previous
+	b.KeepDeleted = true
+	if err := os.Remove(vimrc.Path); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	result, err = b.Run([]types.DotFile{bashrc})
+	if err != nil || result.Kept != 1 || result.Removed != 0 || result.Commit != "" {
+		t.Errorf("Run() = %+v, %v", result, err)
+	}
+}
+
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func manifestPaths(m *Manifest) []string {
+	var paths []string
+	for _, file := range m.DotFiles {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+func TestBackupRunSymlink(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{"src/vimrc": "set number\n"})
+	if err := os.Symlink("src/vimrc", filepath.Join(home, ".vimrc")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	vimrc := types.DotFile{Path: filepath.Join(home, ".vimrc"), Hash: hashOf("src/vimrc"), IsSymlink: true, LinkTarget: "src/vimrc"}
+
+	for _, format := range []string{FormatFiles, FormatArchive} {
+		t.Run(format, func(t *testing.T) {
+			b.Format = format
+			b.Previous = nil
+			client.Files = map[string][]byte{}
+			if _, err := b.Run([]types.DotFile{vimrc}); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			remote, err := OpenRemote(client, "dotfiles", "laptop")
+			if err != nil {
+				t.Fatalf("OpenRemote() error = %v", err)
+			}
+			file := remote.Manifest.DotFiles[0]
+			if content, err := remote.ReadFile(file); err != nil || string(content) != "src/vimrc" || file.LinkTarget != "src/vimrc" {
+				t.Errorf("ReadFile() = %q, %v for %+v", content, err, file)
+			}
+		})
+	}
+
+	// A link retargeted after planning makes the plan stale
+	b.Format = FormatFiles
+	b.Previous = nil
+	client.Files = map[string][]byte{}
+	plan, err := b.Plan([]types.DotFile{vimrc})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := os.Remove(vimrc.Path); err != nil {
+		t.Fatalf("Failed to remove symlink: %v", err)
+	}
+	if err := os.Symlink("elsewhere", vimrc.Path); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if _, err := b.Apply(plan); !errors.Is(err, ErrStalePlan) {
+		t.Errorf("Apply() error = %v, want ErrStalePlan", err)
+	}
+}
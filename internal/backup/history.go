@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// dateLayouts are the ways a point in time can be given instead of a ref.
+// A date alone means the end of that day.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// ResolveAt returns the commit a commit SHA, tag, branch or point in time
+// refers to. For a point in time that is the last commit made before it.
+// An unknown ref or a time before the first backup is a wrapped
+// types.ErrNotFound.
+func ResolveAt(client types.GitHubClient, repo, at string) (types.Commit, error) {
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, at, time.Local)
+		if err != nil {
+			continue
+		}
+		if layout == "2006-01-02" {
+			t = t.AddDate(0, 0, 1)
+		}
+		commits, err := client.ListCommits(repo, "", t, 1)
+		if err != nil {
+			return types.Commit{}, err
+		}
+		if len(commits) == 0 {
+			return types.Commit{}, fmt.Errorf("no backup before %s: %w", at, types.ErrNotFound)
+		}
+		return commits[0], nil
+	}
+	return client.ResolveRef(repo, at)
+}
+
+// commitClient reads files as they were at a commit
+type commitClient struct {
+	types.GitHubClient
+	sha string
+}
+
+func (c *commitClient) DownloadFile(repo, path string) ([]byte, error) {
+	return c.GitHubClient.DownloadFileAt(repo, path, c.sha)
+}
+
+// AtCommit returns a client that downloads files as they were at a commit,
+// so that a Remote opened with it restores an older backup
+func AtCommit(client types.GitHubClient, sha string) types.GitHubClient {
+	return &commitClient{GitHubClient: client, sha: sha}
+}
+
+// Version is a version of a backed up file and the first backup that
+// recorded it
+type Version struct {
+	Commit types.Commit
+	File   types.DotFile
+}
+
+// History returns the versions of a machine's file, newest first, by
+// reading the manifests of the machine's last limit backups. A backup that
+// left the file unchanged does not start a new version.
+func History(client types.GitHubClient, repo, machine, portable string, limit int) ([]Version, error) {
+	commits, err := client.ListCommits(repo, ManifestPath(machine), time.Time{}, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	previous := ""
+	for i := len(commits) - 1; i >= 0; i-- {
+		manifest, err := FetchManifest(AtCommit(client, commits[i].SHA), repo, machine)
+		if err != nil {
+			return nil, fmt.Errorf("error reading backup %s: %w", commits[i].SHA, err)
+		}
+		if manifest == nil {
+			previous = ""
+			continue
+		}
+		file, ok := findFile(manifest.DotFiles, portable)
+		if !ok {
+			previous = ""
+			continue
+		}
+		if file.Hash != previous {
+			versions = append(versions, Version{Commit: commits[i], File: file})
+		}
+		previous = file.Hash
+	}
+
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
+
+// findFile returns the file with a portable path
+func findFile(files []types.DotFile, portable string) (types.DotFile, bool) {
+	for _, file := range files {
+		if file.Path == portable {
+			return file, true
+		}
+	}
+	return types.DotFile{}, false
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestHistory(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{
+		".bashrc": "alias ll='ls -l'\n",
+		".vimrc":  "set number\n",
+	})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	now := start
+	client.Now = func() time.Time { return now }
+
+	// Three backups a day apart; .vimrc changes in the second and .bashrc
+	// in the last
+	backupAt := func(day int, bashrc, vimrc string) string {
+		t.Helper()
+		now = start.AddDate(0, 0, day)
+		b.Time = now
+		for name, content := range map[string]string{".bashrc": bashrc, ".vimrc": vimrc} {
+			if err := os.WriteFile(filepath.Join(home, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		files := []types.DotFile{
+			{Path: filepath.Join(home, ".bashrc"), Hash: hashOf(bashrc)},
+			{Path: filepath.Join(home, ".vimrc"), Hash: hashOf(vimrc)},
+		}
+		result, err := b.Run(files)
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		b.Previous = result.Manifest
+		return result.Commit
+	}
+	first := backupAt(0, "alias ll='ls -l'\n", "set number\n")
+	second := backupAt(1, "alias ll='ls -l'\n", "set nonumber\n")
+	third := backupAt(2, "alias ll='ls -la'\n", "set nonumber\n")
+	client.Tags["v1"] = first
+
+	tests := []struct {
+		at   string
+		want string
+	}{
+		{first, first},
+		{"v1", first},
+		{"main", third},
+		{"2024-05-01", first},
+		{"2024-05-02 11:00", first},
+		{"2024-05-02T12:30", second},
+		{start.AddDate(0, 0, 5).Format(time.RFC3339), third},
+	}
+	for _, tt := range tests {
+		commit, err := ResolveAt(client, "dotfiles", tt.at)
+		if err != nil || commit.SHA != tt.want {
+			t.Errorf("ResolveAt(%q) = %q, %v, want %q", tt.at, commit.SHA, err, tt.want)
+		}
+	}
+	for _, at := range []string{"2024-04-30", "unknown"} {
+		if _, err := ResolveAt(client, "dotfiles", at); !errors.Is(err, types.ErrNotFound) {
+			t.Errorf("ResolveAt(%q) error = %v", at, err)
+		}
+	}
+
+	// A remote opened at a commit reads the files as they were then
+	remote, err := OpenRemote(AtCommit(client, first), "dotfiles", "laptop")
+	if err != nil {
+		t.Fatalf("OpenRemote() error = %v", err)
+	}
+	file, _ := findFile(remote.Manifest.DotFiles, "~/.bashrc")
+	if content, err := remote.ReadFile(file); err != nil || string(content) != "alias ll='ls -l'\n" {
+		t.Errorf("ReadFile() at %s = %q, %v", first, content, err)
+	}
+
+	// Unchanged backups do not start a new version
+	versions, err := History(client, "dotfiles", "laptop", "~/.bashrc", 50)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(versions) != 2 || versions[0].Commit.SHA != third || versions[1].Commit.SHA != first {
+		t.Errorf("History() = %+v", versions)
+	}
+	if len(versions) == 2 && versions[0].File.Hash != hashOf("alias ll='ls -la'\n") {
+		t.Errorf("History() newest hash = %q", versions[0].File.Hash)
+	}
+	if versions, err := History(client, "dotfiles", "laptop", "~/.vimrc", 50); err != nil || len(versions) != 2 || versions[0].Commit.SHA != second {
+		t.Errorf("History() of .vimrc = %+v, %v", versions, err)
+	}
+	if versions, err := History(client, "dotfiles", "laptop", "~/.missing", 50); err != nil || len(versions) != 0 {
+		t.Errorf("History() of a missing file = %+v, %v", versions, err)
+	}
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+const (
+	// DefaultChunkSize keeps every blob well below the 50 MB at which
+	// GitHub starts warning about large files
+	DefaultChunkSize = 32 << 20
+	// DefaultLargeFileSize is the size above which the size policy applies
+	DefaultLargeFileSize = 5 << 20
+	// DefaultMaxFileSize is the largest file a backup scans for unless the
+	// config file sets scan limits. It is well above the scan's own
+	// defaults so that large files reach the size policy and are chunked.
+	DefaultMaxFileSize = 256 << 20
+
+	// SizePolicyWarn backs up large files with a warning
+	SizePolicyWarn = "warn"
+	// SizePolicyReject leaves large files out of the backup
+	SizePolicyReject = "reject"
+)
+
+// CheckSizePolicy returns an error unless policy is empty or a known size
+// policy
+func CheckSizePolicy(policy string) error {
+	switch policy {
+	case "", SizePolicyWarn, SizePolicyReject:
+		return nil
+	}
+	return fmt.Errorf("invalid size policy %q, expected %q or %q", policy, SizePolicyWarn, SizePolicyReject)
+}
+
+// ChunkPath returns where part i of a chunked file is stored
+func ChunkPath(repoPath string, i int) string {
+	return fmt.Sprintf("%s.part%03d", repoPath, i)
+}
+
+// StoredPaths returns every path in the repository that holds part of a
+// backed up file
+func StoredPaths(machine string, file types.DotFile) []string {
+	repoPath := RepoPath(machine, file.Path)
+	if file.Chunks == 0 {
+		return []string{repoPath}
+	}
+	paths := make([]string, file.Chunks)
+	for i := range paths {
+		paths[i] = ChunkPath(repoPath, i)
+	}
+	return paths
+}
+
+// chunkCount returns how many chunks a file is split into, or 0 when it is
+// small enough to be stored whole
+func chunkCount(size, chunkSize int64) int {
+	if size <= chunkSize {
+		return 0
+	}
+	return int((size + chunkSize - 1) / chunkSize)
+}
+
+// splitChunks cuts content into chunks of chunkSize bytes
+func splitChunks(content []byte, chunkSize int64) [][]byte {
+	var chunks [][]byte
+	for len(content) > 0 {
+		n := min(int64(len(content)), chunkSize)
+		chunks = append(chunks, content[:n])
+		content = content[n:]
+	}
+	return chunks
+}
+
+// FetchFile downloads a backed up file, joining its chunks, and checks that
+// the content matches the recorded hash
+func FetchFile(client types.GitHubClient, repo, machine string, file types.DotFile) ([]byte, error) {
+	var content bytes.Buffer
+	for _, path := range StoredPaths(machine, file) {
+		part, err := client.DownloadFile(repo, path)
+		if err != nil {
+			return nil, fmt.Errorf("error downloading %s: %w", file.Path, err)
+		}
+		content.Write(part)
+	}
+
+	if err := checkHash(file, content.Bytes()); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+// checkHash returns an error if content is not what was backed up as file.
+// Symlinks are hashed by their target rather than their content, so only
+// regular files are checked.
+func checkHash(file types.DotFile, content []byte) error {
+	if file.IsSymlink || file.Hash == "" {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != file.Hash {
+		return fmt.Errorf("downloaded content of %s does not match its hash", file.Path)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestChunkPath(t *testing.T) {
+	file := types.DotFile{Path: "~/.histfile", Chunks: 2}
+	want := []string{
+		"machines/laptop/files/home/.histfile.part000",
+		"machines/laptop/files/home/.histfile.part001",
+	}
+	if got := StoredPaths("laptop", file); !reflect.DeepEqual(got, want) {
+		t.Errorf("StoredPaths() = %v, want %v", got, want)
+	}
+	file.Chunks = 0
+	if got := StoredPaths("laptop", file); len(got) != 1 || got[0] != "machines/laptop/files/home/.histfile" {
+		t.Errorf("StoredPaths() = %v", got)
+	}
+}
+
+func TestBackupRunChunked(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{".histfile": "0123456789"})
+	b.ChunkSize = 4
+	histfile := types.DotFile{Path: filepath.Join(home, ".histfile"), Hash: hashOf("0123456789"), Size: 10}
+
+	result, err := b.Run([]types.DotFile{histfile})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := result.Manifest.DotFiles[0].Chunks; got != 3 {
+		t.Errorf("Manifest records %d chunks, want 3", got)
+	}
+	if got := string(client.Files["dotfiles/machines/laptop/files/home/.histfile.part002"]); got != "89" {
+		t.Errorf("Last chunk = %q", got)
+	}
+	content, err := FetchFile(client, "dotfiles", "laptop", result.Manifest.DotFiles[0])
+	if err != nil || string(content) != "0123456789" {
+		t.Errorf("FetchFile() = %q, %v", content, err)
+	}
+
+	// Once the file is small again its chunks are removed
+	if err := os.WriteFile(histfile.Path, []byte("012"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	b.Previous = result.Manifest
+	histfile.Hash, histfile.Size = hashOf("012"), 3
+	plan, err := b.Plan([]types.DotFile{histfile})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if got := plan.Files[0].Obsolete; len(got) != 3 {
+		t.Errorf("Plan() obsolete paths = %v", got)
+	}
+	if _, err := b.Apply(plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, ok := client.Files["dotfiles/machines/laptop/files/home/.histfile.part000"]; ok {
+		t.Error("Old chunk was not removed")
+	}
+	if got := string(client.Files["dotfiles/machines/laptop/files/home/.histfile"]); got != "012" {
+		t.Errorf("Uploaded .histfile = %q", got)
+	}
+}
+
+func TestBackupSizePolicy(t *testing.T) {
+	home, b, _ := newTestBackup(t, map[string]string{
+		".bashrc":   "alias ll='ls -l'\n",
+		".histfile": "0123456789",
+	})
+	b.LargeFileSize = 5
+	files := []types.DotFile{
+		{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n"), Size: 3},
+		{Path: filepath.Join(home, ".histfile"), Hash: hashOf("0123456789"), Size: 10},
+	}
+
+	plan, err := b.Plan(files)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan.Warnings) != 1 || len(plan.Rejected) != 0 || len(plan.Files) != 2 {
+		t.Errorf("Plan() with warn policy = %+v", plan)
+	}
+
+	b.SizePolicy = SizePolicyReject
+	plan, err = b.Plan(files)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan.Warnings) != 0 || len(plan.Rejected) != 1 || plan.Rejected[0] != "~/.histfile" {
+		t.Errorf("Plan() with reject policy = %+v", plan)
+	}
+	if paths := manifestPaths(plan.Manifest); len(paths) != 1 || paths[0] != "~/.bashrc" {
+		t.Errorf("Manifest files = %v", paths)
+	}
+
+	b.SizePolicy = "ignore"
+	if _, err := b.Plan(files); err == nil {
+		t.Error("Plan() accepted an unknown size policy")
+	}
+}
+
+func TestFetchFileMismatch(t *testing.T) {
+	_, _, client := newTestBackup(t, nil)
+	client.Files["dotfiles/machines/laptop/files/home/.bashrc"] = []byte("tampered\n")
+	file := types.DotFile{Path: "~/.bashrc", Hash: hashOf("alias ll='ls -l'\n")}
+	if _, err := FetchFile(client, "dotfiles", "laptop", file); err == nil {
+		t.Error("FetchFile() accepted content that does not match the hash")
+	}
+	file.Path = "~/.missing"
+	if _, err := FetchFile(client, "dotfiles", "laptop", file); err == nil {
+		t.Error("FetchFile() of a missing file should return an error")
+	}
+}
@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+const (
+	// ManifestFile is the name of the manifest in a machine's directory
+	ManifestFile = "manifest.json"
+
+	// LayoutVersion is the newest version of the repository layout written
+	// by this build. It is increased whenever older versions could not read
+	// a repository correctly. Version 2 added snapshot archives and files
+	// split into chunks; a backup that uses neither is written as version 1.
+	LayoutVersion = 2
+)
+
+// Manifest indexes a machine's backup. It is stored next to the machine's
+// files so that restoring and comparing need only this file instead of a
+// listing of the repository. Format is the layout the files are stored in;
+// with FormatArchive, Snapshot is the archive holding them.
+type Manifest struct {
+	Version        int    `json:"version"`
+	Format         string `json:"format,omitempty"`
+	Snapshot       string `json:"snapshot,omitempty"`
+	SnapshotChunks int    `json:"snapshot_chunks,omitempty"`
+	types.Machine
+}
+
+// ManifestPath returns where a machine's manifest is stored
+func ManifestPath(machine string) string {
+	return path.Join(MachinePath(machine), ManifestFile)
+}
+
+// NewManifest returns the manifest of a machine in the current layout. The
+// machine's paths should already be portable.
+func NewManifest(machine types.Machine) *Manifest {
+	return &Manifest{Version: LayoutVersion, Machine: machine}
+}
+
+// Archived reports whether the machine's files are stored in a snapshot
+// archive rather than one by one
+func (m *Manifest) Archived() bool {
+	return m.Format == FormatArchive
+}
+
+// layoutVersion returns the oldest layout version that reads the
+// machine's backup correctly
+func (m *Manifest) layoutVersion() int {
+	if m.Archived() || m.SnapshotChunks > 0 {
+		return 2
+	}
+	for _, file := range m.DotFiles {
+		if file.Chunks > 0 {
+			return 2
+		}
+	}
+	return 1
+}
+
+// Marshal encodes the manifest as indented JSON, recording the oldest
+// layout version that can read it so that older builds refuse backups they
+// would restore incorrectly
+func (m *Manifest) Marshal() ([]byte, error) {
+	m.Version = m.layoutVersion()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseManifest decodes a manifest, refusing layouts newer than this build
+// understands
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version < 1 {
+		return nil, fmt.Errorf("invalid manifest: missing layout version")
+	}
+	if m.Version > LayoutVersion {
+		return nil, fmt.Errorf("manifest uses layout version %d, this version of dotback supports up to %d", m.Version, LayoutVersion)
+	}
+	return &m, nil
+}
+
+// FetchManifest downloads and decodes a machine's manifest. It returns nil
+// without an error when the machine has no manifest yet.
+func FetchManifest(client types.GitHubClient, repo, machine string) (*Manifest, error) {
+	data, err := client.DownloadFile(repo, ManifestPath(machine))
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error downloading manifest: %w", err)
+	}
+	return ParseManifest(data)
+}
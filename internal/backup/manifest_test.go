@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/auth/github"
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	machine := types.Machine{
+		Hostname:    "laptop",
+		LastSync:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		DotFiles:    []types.DotFile{{Path: "~/.bashrc", Hash: "abc"}},
+		Apps:        []types.App{{Name: "bash", ConfigFiles: []types.DotFile{{Path: "~/.bashrc", Hash: "abc"}}}},
+		Labels:      map[string]string{"os": "linux"},
+		Description: "Work laptop",
+	}
+
+	data, err := NewManifest(machine).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	// The machine's fields sit next to the version rather than nested
+	if !strings.Contains(string(data), `"version": 1`) || !strings.Contains(string(data), `"hostname": "laptop"`) {
+		t.Errorf("Marshal() = %s", data)
+	}
+
+	got, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("ParseManifest() error = %v", err)
+	}
+	if got.Version != 1 || got.Hostname != "laptop" || got.Description != "Work laptop" ||
+		got.Labels["os"] != "linux" || len(got.Apps) != 1 || got.DotFiles[0].Hash != "abc" || !got.LastSync.Equal(machine.LastSync) {
+		t.Errorf("ParseManifest() = %+v", got)
+	}
+}
+
+func TestManifestLayoutVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest *Manifest
+		want     int
+	}{
+		{name: "Files", manifest: NewManifest(types.Machine{DotFiles: []types.DotFile{{Path: "~/.bashrc"}}}), want: 1},
+		{name: "Chunked file", manifest: NewManifest(types.Machine{DotFiles: []types.DotFile{{Path: "~/.histfile", Chunks: 2}}}), want: 2},
+		{name: "Archive", manifest: &Manifest{Format: FormatArchive, Snapshot: "snapshots/a.tar.gz"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.manifest.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			got, err := ParseManifest(data)
+			if err != nil || got.Version != tt.want {
+				t.Errorf("ParseManifest() = %+v, %v, want version %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "Invalid JSON", data: `{`},
+		{name: "Missing version", data: `{"hostname": "laptop"}`},
+		{name: "Newer layout", data: `{"version": 99, "hostname": "laptop"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseManifest([]byte(tt.data)); err == nil {
+				t.Error("ParseManifest() should return an error")
+			}
+		})
+	}
+}
+
+func TestFetchManifest(t *testing.T) {
+	client := github.NewMockClient("token", false, "user")
+
+	manifest, err := FetchManifest(client, "dotfiles", "laptop")
+	if err != nil || manifest != nil {
+		t.Errorf("FetchManifest() without a manifest = %v, %v, want nil, nil", manifest, err)
+	}
+
+	client.Files["dotfiles/machines/laptop/manifest.json"] = []byte(`{"version": 1, "hostname": "laptop"}`)
+	manifest, err = FetchManifest(client, "dotfiles", "laptop")
+	if err != nil || manifest == nil || manifest.Hostname != "laptop" {
+		t.Errorf("FetchManifest() = %v, %v", manifest, err)
+	}
+
+	failing := github.NewMockClient("token", true, "user")
+	if _, err := FetchManifest(failing, "dotfiles", "laptop"); err == nil {
+		t.Error("FetchManifest() with a failing client should return an error")
+	}
+}
@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// PlanVersion is the version of the plan file format written by this build
+const PlanVersion = 1
+
+// ErrStalePlan is returned when applying a plan whose files or target
+// backup changed since it was made
+var ErrStalePlan = errors.New("plan is out of date")
+
+// PlanAction is what a plan does with a file
+type PlanAction string
+
+const (
+	ActionAdd    PlanAction = "add"
+	ActionUpdate PlanAction = "update"
+	ActionDelete PlanAction = "delete"
+)
+
+// PlanActions lists every action in the order they are reported
+var PlanActions = []PlanAction{ActionAdd, ActionUpdate, ActionDelete}
+
+// PlannedFile is one file a plan adds, updates or deletes
+type PlannedFile struct {
+	Action    PlanAction `json:"action"`
+	Path      string     `json:"path"`
+	RepoPath  string     `json:"repo_path"`
+	Source    string     `json:"source,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	Mode      string     `json:"mode,omitempty"`
+	IsSymlink bool       `json:"is_symlink,omitempty"`
+	// LinkTarget is what a preserved symlink points to. It is stored as the
+	// link's content.
+	LinkTarget string `json:"link_target,omitempty"`
+	// Chunks counts the parts a large file is uploaded in
+	Chunks int `json:"chunks,omitempty"`
+	// Obsolete lists repository paths of the previous backup of the file
+	// that are deleted, such as chunks it no longer needs
+	Obsolete []string `json:"obsolete,omitempty"`
+}
+
+// Plan is a backup worked out in advance. It can be saved and applied
+// later exactly as it was reviewed.
+type Plan struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Repo        string    `json:"repo"`
+	Branch      string    `json:"branch"`
+	Machine     string    `json:"machine"`
+	MachinePath string    `json:"machine_path"`
+	Message     string    `json:"message"`
+	// Format is the layout of the backup. With FormatArchive every file is
+	// packed into the archive at Snapshot.
+	Format   string        `json:"format"`
+	Snapshot string        `json:"snapshot,omitempty"`
+	Files    []PlannedFile `json:"files"`
+	// Obsolete lists repository paths deleted because the machine switched
+	// to the archive layout
+	Obsolete []string `json:"obsolete,omitempty"`
+	// TotalBytes is the size of the files to add or update
+	TotalBytes int64 `json:"total_bytes"`
+	Skipped    int   `json:"skipped"`
+	Kept       int   `json:"kept"`
+	// PreviousSync is the last sync of the backup the plan was made
+	// against, or nil if the machine had no backup yet
+	PreviousSync *time.Time `json:"previous_sync,omitempty"`
+	// ChunkSize is the size of the parts large files are split into
+	ChunkSize int64 `json:"chunk_size"`
+	// Warnings are reported before the plan is applied, e.g. for large files
+	Warnings []string `json:"warnings,omitempty"`
+	// Rejected lists files left out because of the size policy
+	Rejected []string  `json:"rejected,omitempty"`
+	Manifest *Manifest `json:"manifest"`
+}
+
+// add appends a file to the plan. old is the file in the previous backup, if
+// any, and source is the file's local path.
+func (p *Plan) add(action PlanAction, file types.DotFile, old *types.DotFile, source, machine string) {
+	planned := PlannedFile{
+		Action:   action,
+		Path:     file.Path,
+		RepoPath: RepoPath(machine, file.Path),
+	}
+	stored := map[string]bool{}
+	if action != ActionDelete {
+		planned.Source = source
+		planned.Size = file.Size
+		planned.Hash = file.Hash
+		planned.Mode = file.Mode
+		planned.IsSymlink = file.IsSymlink
+		planned.LinkTarget = file.LinkTarget
+		planned.Chunks = file.Chunks
+		p.TotalBytes += file.Size
+		for _, path := range StoredPaths(machine, file) {
+			stored[path] = true
+		}
+	}
+	if old != nil {
+		for _, path := range StoredPaths(machine, *old) {
+			if !stored[path] {
+				planned.Obsolete = append(planned.Obsolete, path)
+			}
+		}
+	}
+	p.Files = append(p.Files, planned)
+}
+
+// Count returns how many files the plan handles with an action
+func (p *Plan) Count(action PlanAction) int {
+	count := 0
+	for _, file := range p.Files {
+		if file.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// UpToDate reports whether applying the plan would change nothing
+func (p *Plan) UpToDate() bool {
+	return len(p.Files) == 0 && p.PreviousSync != nil
+}
+
+// basedOn reports whether the plan was made against the given manifest
+func (p *Plan) basedOn(remote *Manifest) bool {
+	if remote == nil || p.PreviousSync == nil {
+		return remote == nil && p.PreviousSync == nil
+	}
+	return remote.LastSync.Equal(*p.PreviousSync)
+}
+
+// matches reports whether content is what the file held when the plan was
+// made. Symlinks are hashed by their target, so only regular files are
+// checked.
+func (f PlannedFile) matches(content []byte) bool {
+	if f.IsSymlink || f.Hash == "" {
+		return true
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) == f.Hash
+}
+
+// executable reports whether any execute bit is set in the recorded mode
+func (f PlannedFile) executable() bool {
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	return err == nil && mode&0111 != 0
+}
+
+// WritePlan saves a plan as JSON. The file is only readable by the user
+// since it lists local paths.
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing plan: %w", err)
+	}
+	return nil
+}
+
+// ReadPlan loads a plan saved by WritePlan
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan: %w", err)
+	}
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("invalid plan: unsupported version %d", plan.Version)
+	}
+	if plan.Repo == "" || plan.Machine == "" || plan.Manifest == nil {
+		return nil, fmt.Errorf("invalid plan: repository, machine or manifest missing")
+	}
+	return &plan, nil
+}
@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestPlan(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{
+		".bashrc": "alias ll='ls -l'\n",
+		".zshrc":  "setopt autocd\n",
+	})
+	b.Previous = NewManifest(types.Machine{
+		Hostname: "laptop",
+		LastSync: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		DotFiles: []types.DotFile{
+			{Path: "~/.zshrc", Hash: "old"},
+			{Path: "~/.gone", Hash: "gone"},
+		},
+	})
+	b.Time = time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	files := []types.DotFile{
+		{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n"), Size: 17},
+		{Path: filepath.Join(home, ".zshrc"), Hash: hashOf("setopt autocd\n"), Size: 14},
+	}
+	plan, err := b.Plan(files)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	if plan.Repo != "dotfiles" || plan.Branch != "main" || plan.MachinePath != "machines/laptop" || plan.Message != "Backup" {
+		t.Errorf("Plan() target = %+v", plan)
+	}
+	if plan.Count(ActionAdd) != 1 || plan.Count(ActionUpdate) != 1 || plan.Count(ActionDelete) != 1 || plan.TotalBytes != 31 {
+		t.Errorf("Plan() files = %+v, total %d", plan.Files, plan.TotalBytes)
+	}
+	if len(client.Commits) != 0 {
+		t.Errorf("Plan() committed %v", client.Commits)
+	}
+
+	// A saved plan is applied exactly as it was made
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := WritePlan(path, plan); err != nil {
+		t.Fatalf("WritePlan() error = %v", err)
+	}
+	loaded, err := ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+	if loaded.Count(ActionDelete) != 1 || loaded.Files[0].Source != files[0].Path || !loaded.PreviousSync.Equal(*plan.PreviousSync) {
+		t.Errorf("ReadPlan() = %+v", loaded)
+	}
+
+	// Applying fails while the repository holds another backup
+	if _, err := b.Apply(loaded); !errors.Is(err, ErrStalePlan) {
+		t.Errorf("Apply() error = %v, want ErrStalePlan", err)
+	}
+
+	data, _ := b.Previous.Marshal()
+	client.Files["dotfiles/machines/laptop/manifest.json"] = data
+	result, err := b.Apply(loaded)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if result.Uploaded != 2 || result.Removed != 1 || len(client.Commits) != 1 || client.Commits[0] != "Backup" {
+		t.Errorf("Apply() = %+v, commits %v", result, client.Commits)
+	}
+}
+
+func TestApplyChangedFile(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{".bashrc": "alias ll='ls -l'\n"})
+	plan, err := b.Plan([]types.DotFile{{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n")}})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := b.Apply(plan); !errors.Is(err, ErrStalePlan) {
+		t.Errorf("Apply() error = %v, want ErrStalePlan", err)
+	}
+	if len(client.Commits) != 0 {
+		t.Errorf("Apply() committed %v", client.Commits)
+	}
+}
+
+func TestReadPlanErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "Invalid JSON", data: `{`},
+		{name: "Unknown version", data: `{"version": 2, "repo": "r", "machine": "m", "manifest": {"version": 1}}`},
+		{name: "Missing manifest", data: `{"version": 1, "repo": "r", "machine": "m"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "plan.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatalf("Failed to write plan: %v", err)
+			}
+			if _, err := ReadPlan(path); err == nil {
+				t.Error("ReadPlan() should return an error")
+			}
+		})
+	}
+
+	if _, err := ReadPlan(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("ReadPlan() of a missing file should return an error")
+	}
+}
+
+func TestPlannedFileExecutable(t *testing.T) {
+	tests := []struct {
+		mode string
+		want bool
+	}{
+		{mode: "0755", want: true},
+		{mode: "0700", want: true},
+		{mode: "0644", want: false},
+		{mode: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := (PlannedFile{Mode: tt.mode}).executable(); got != tt.want {
+			t.Errorf("executable(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
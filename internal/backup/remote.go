@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// Remote reads the files of a machine's backup, whichever layout they are
+// stored in
+type Remote struct {
+	Client   types.GitHubClient
+	Repo     string
+	Manifest *Manifest
+
+	snapshot *Snapshot
+}
+
+// OpenRemote reads a machine's manifest and detects its layout. The
+// snapshot of an archived machine is downloaded right away. It returns an
+// error wrapping types.ErrNotFound when the machine has no backup.
+func OpenRemote(client types.GitHubClient, repo, machine string) (*Remote, error) {
+	manifest, err := FetchManifest(client, repo, machine)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("no backup of machine %s in %s: %w", machine, repo, types.ErrNotFound)
+	}
+
+	remote := &Remote{Client: client, Repo: repo, Manifest: manifest}
+	if manifest.Archived() {
+		if remote.snapshot, err = FetchSnapshot(client, repo, manifest); err != nil {
+			return nil, err
+		}
+	}
+	return remote, nil
+}
+
+// Format returns the layout the machine's files are stored in
+func (r *Remote) Format() string {
+	if r.Manifest.Archived() {
+		return FormatArchive
+	}
+	return FormatFiles
+}
+
+// ReadFile returns the backed up content of one of the manifest's files
+func (r *Remote) ReadFile(file types.DotFile) ([]byte, error) {
+	if r.snapshot == nil {
+		return FetchFile(r.Client, r.Repo, r.Manifest.Hostname, file)
+	}
+	content, ok := r.snapshot.Files[file.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in snapshot %s: %w", file.Path, r.Manifest.Snapshot, types.ErrNotFound)
+	}
+	if err := checkHash(file, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
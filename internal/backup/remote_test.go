@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestOpenRemote(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{
+		".bashrc": "alias ll='ls -l'\n",
+		".vimrc":  "set number\n",
+	})
+	files := []types.DotFile{
+		{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n")},
+		{Path: filepath.Join(home, ".vimrc"), Hash: hashOf("set number\n")},
+	}
+
+	if _, err := OpenRemote(client, "dotfiles", "laptop"); !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("OpenRemote() without a backup error = %v", err)
+	}
+
+	// Files layout
+	b.Time = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := b.Run(files); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	remote, err := OpenRemote(client, "dotfiles", "laptop")
+	if err != nil || remote.Format() != FormatFiles {
+		t.Fatalf("OpenRemote() = %v, %v", remote, err)
+	}
+	if content, err := remote.ReadFile(remote.Manifest.DotFiles[0]); err != nil || string(content) != "alias ll='ls -l'\n" {
+		t.Errorf("ReadFile() = %q, %v", content, err)
+	}
+
+	// Switching to archives removes the files stored one by one
+	b.Previous = remote.Manifest
+	b.Format = FormatArchive
+	b.Time = b.Time.Add(time.Hour)
+	result, err := b.Run(files)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Uploaded != 2 || result.Manifest.Snapshot != "machines/laptop/snapshots/20240501T130000Z.tar.gz" {
+		t.Errorf("Run() = %+v", result)
+	}
+	for path := range client.Files {
+		if strings.Contains(path, "/files/") {
+			t.Errorf("%s was left behind after switching to archives", path)
+		}
+	}
+
+	remote, err = OpenRemote(client, "dotfiles", "laptop")
+	if err != nil || remote.Format() != FormatArchive {
+		t.Fatalf("OpenRemote() = %v, %v", remote, err)
+	}
+	if content, err := remote.ReadFile(remote.Manifest.DotFiles[1]); err != nil || string(content) != "set number\n" {
+		t.Errorf("ReadFile() = %q, %v", content, err)
+	}
+	if _, err := remote.ReadFile(types.DotFile{Path: "~/.zshrc"}); !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("ReadFile() of a file not in the snapshot error = %v", err)
+	}
+}
+
+func TestOpenRemoteChunkedSnapshot(t *testing.T) {
+	home, b, client := newTestBackup(t, map[string]string{".bashrc": "alias ll='ls -l'\n"})
+	b.Format = FormatArchive
+	b.ChunkSize = 16
+	files := []types.DotFile{{Path: filepath.Join(home, ".bashrc"), Hash: hashOf("alias ll='ls -l'\n")}}
+
+	result, err := b.Run(files)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Manifest.SnapshotChunks < 2 {
+		t.Fatalf("Snapshot stored in %d chunks", result.Manifest.SnapshotChunks)
+	}
+	remote, err := OpenRemote(client, "dotfiles", "laptop")
+	if err != nil {
+		t.Fatalf("OpenRemote() error = %v", err)
+	}
+	if content, err := remote.ReadFile(remote.Manifest.DotFiles[0]); err != nil || string(content) != "alias ll='ls -l'\n" {
+		t.Errorf("ReadFile() = %q, %v", content, err)
+	}
+}
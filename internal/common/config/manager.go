@@ -9,28 +9,69 @@ import (
 	"github.com/amroessam/dotback/internal/common/logger"
 	"github.com/amroessam/dotback/internal/common/storage"
 	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
 )
 
 const (
 	configFileName = "config.json"
+	appDirName     = "dotback"
 )
 
-// GetConfigDirFunc is a function type for getting the config directory
+// GetConfigDirFunc is a function type for getting one of dotback's directories
 type GetConfigDirFunc func() (string, error)
 
-// DefaultGetConfigDir returns the default config directory
+// DefaultGetConfigDir returns $XDG_CONFIG_HOME/dotback, which defaults to
+// ~/.config/dotback
 func DefaultGetConfigDir() (string, error) {
-	homeDir, err := os.UserHomeDir()
+	dirs, err := xdg.Load()
 	if err != nil {
-		return "", fmt.Errorf("error getting home directory: %w", err)
+		return "", err
 	}
+	return filepath.Join(dirs.ConfigHome, appDirName), nil
+}
+
+// DefaultGetDataDir returns $XDG_DATA_HOME/dotback, which defaults to
+// ~/.local/share/dotback
+func DefaultGetDataDir() (string, error) {
+	dirs, err := xdg.Load()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirs.DataHome, appDirName), nil
+}
+
+// DefaultGetStateDir returns $XDG_STATE_HOME/dotback, which defaults to
+// ~/.local/state/dotback
+func DefaultGetStateDir() (string, error) {
+	dirs, err := xdg.Load()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirs.StateHome, appDirName), nil
+}
 
-	return filepath.Join(homeDir, ".config", "dotback"), nil
+// DefaultGetCacheDir returns $XDG_CACHE_HOME/dotback, which defaults to
+// ~/.cache/dotback
+func DefaultGetCacheDir() (string, error) {
+	dirs, err := xdg.Load()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirs.CacheHome, appDirName), nil
 }
 
 // GetConfigDir is the current implementation of getting the config directory
 var GetConfigDir GetConfigDirFunc = DefaultGetConfigDir
 
+// GetDataDir is the current implementation of getting the data directory
+var GetDataDir GetConfigDirFunc = DefaultGetDataDir
+
+// GetStateDir is the current implementation of getting the state directory
+var GetStateDir GetConfigDirFunc = DefaultGetStateDir
+
+// GetCacheDir is the current implementation of getting the cache directory
+var GetCacheDir GetConfigDirFunc = DefaultGetCacheDir
+
 // Manager handles configuration storage and retrieval
 type Manager struct {
 	configPath string
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -142,3 +143,35 @@ func TestManager(t *testing.T) {
 		}
 	})
 }
+
+func TestDefaultDirs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "/xdg/data")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "/xdg/cache")
+
+	tests := []struct {
+		name string
+		fn   GetConfigDirFunc
+		want string
+	}{
+		{name: "config", fn: DefaultGetConfigDir, want: filepath.Join(home, ".config", "dotback")},
+		{name: "data", fn: DefaultGetDataDir, want: "/xdg/data/dotback"},
+		{name: "state", fn: DefaultGetStateDir, want: filepath.Join(home, ".local", "state", "dotback")},
+		{name: "cache", fn: DefaultGetCacheDir, want: "/xdg/cache/dotback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn()
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
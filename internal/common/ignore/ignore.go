@@ -0,0 +1,275 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore files read from the home and config directories
+const FileName = ".dotbackignore"
+
+// DefaultPatterns are applied before any user rules, so they can be negated
+var DefaultPatterns = []string{
+	".cache/",
+	".local/share/Trash/",
+	".Trash/",
+	"node_modules/",
+	".git/",
+	".DS_Store",
+	".npm/",
+	".cargo/registry/",
+	".rustup/",
+	".gradle/caches/",
+	".m2/repository/",
+	".vscode/extensions/",
+	".mozilla/",
+	".config/google-chrome/",
+	".config/chromium/",
+	".config/BraveSoftware/",
+}
+
+// Rule is a single parsed ignore pattern
+type Rule struct {
+	Pattern string
+	Source  string
+	Line    int
+	Negate  bool
+	DirOnly bool
+	re      *regexp.Regexp
+}
+
+// String formats the rule with its origin, e.g. ~/.dotbackignore:3: .cache/
+func (r *Rule) String() string {
+	if r.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Pattern)
+	}
+	return fmt.Sprintf("%s: %s", r.Source, r.Pattern)
+}
+
+// Result describes how the matcher decided on a path
+type Result struct {
+	Ignored bool
+	// Rule is the last rule that matched, or nil if none did
+	Rule *Rule
+	// Parent is set when the decision was inherited from an excluded directory
+	Parent string
+}
+
+// Matcher evaluates paths against an ordered list of gitignore-style rules.
+// As with git, the last matching rule wins and a file cannot be re-included
+// once one of its parent directories is excluded.
+type Matcher struct {
+	base  string
+	rules []*Rule
+}
+
+// NewMatcher creates an empty matcher whose patterns are relative to base
+func NewMatcher(base string) *Matcher {
+	return &Matcher{base: filepath.Clean(base)}
+}
+
+// Load builds the matcher used by scan and backup: the default patterns,
+// then ~/.dotbackignore, then the .dotbackignore in the config directory,
+// then the patterns from the config file
+func Load(home, configDir string, patterns []string) (*Matcher, error) {
+	m := NewMatcher(home)
+	m.AddPatterns("default", DefaultPatterns)
+	for _, path := range []string{filepath.Join(home, FileName), filepath.Join(configDir, FileName)} {
+		if err := m.AddFile(path); err != nil {
+			return nil, err
+		}
+	}
+	m.AddPatterns("config", patterns)
+	return m, nil
+}
+
+// AddPatterns adds rules that did not come from a file
+func (m *Matcher) AddPatterns(source string, patterns []string) {
+	for _, pattern := range patterns {
+		if rule := parseRule(pattern); rule != nil {
+			rule.Source = source
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// AddFile adds the rules in an ignore file. A missing file is not an error.
+func (m *Matcher) AddFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading ignore file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if rule := parseRule(scanner.Text()); rule != nil {
+			rule.Source = path
+			rule.Line = line
+			m.rules = append(m.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading ignore file: %w", err)
+	}
+	return nil
+}
+
+// Match evaluates only the path itself, without looking at its parents.
+// Walkers that prune excluded directories can use it to avoid rechecking
+// every ancestor.
+func (m *Matcher) Match(path string, isDir bool) Result {
+	rel := m.relative(path)
+	var result Result
+	for _, rule := range m.rules {
+		if rule.DirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			result = Result{Ignored: !rule.Negate, Rule: rule}
+		}
+	}
+	return result
+}
+
+// Explain evaluates the path and each of its parent directories
+func (m *Matcher) Explain(path string, isDir bool) Result {
+	rel := m.relative(path)
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if result := m.Match(m.absolute(dir), true); result.Ignored {
+			result.Parent = m.absolute(dir)
+			return result
+		}
+	}
+	return m.Match(path, isDir)
+}
+
+// Ignored reports whether the path or one of its parents is excluded
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	return m.Explain(path, isDir).Ignored
+}
+
+// relative converts a path into the slash separated form rules match against.
+// Paths outside the base directory are matched from the file system root.
+func (m *Matcher) relative(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	rel, err := filepath.Rel(m.base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (m *Matcher) absolute(rel string) string {
+	return filepath.Join(m.base, filepath.FromSlash(rel))
+}
+
+// parseRule parses one line of gitignore syntax, returning nil for blank
+// lines and comments
+func parseRule(line string) *Rule {
+	// Trailing spaces are ignored unless escaped with a backslash
+	trimmed := strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(trimmed, "\\") && len(trimmed) < len(strings.TrimRight(line, "\r")) {
+		trimmed += " "
+	}
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil
+	}
+
+	rule := &Rule{Pattern: trimmed}
+	pattern := trimmed
+	switch {
+	case strings.HasPrefix(pattern, "!"):
+		rule.Negate = true
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "\\!"), strings.HasPrefix(pattern, "\\#"):
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.DirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+
+	// A slash anywhere but the end anchors the pattern to the base directory
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	expr += translate(pattern) + "$"
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	rule.re = re
+	return rule
+}
+
+// translate converts a glob into a regular expression
+func translate(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				atStart := i == 0 || pattern[i-1] == '/'
+				switch {
+				case atStart && i+2 < len(pattern) && pattern[i+2] == '/':
+					// "**/" matches zero or more directories
+					b.WriteString("(?:.*/)?")
+					i += 2
+				case atStart && i+2 == len(pattern):
+					// trailing "/**" matches everything inside
+					b.WriteString(".*")
+					i++
+				default:
+					b.WriteString("[^/]*")
+					i++
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
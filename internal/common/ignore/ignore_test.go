@@ -0,0 +1,128 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	m := NewMatcher("/home/user")
+	m.AddPatterns("test", []string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		".cache/",
+		"/.top",
+		"build/**",
+		"**/secrets/*.pem",
+		"a/**/z",
+		"tmp[0-9]",
+		"\\!bang",
+		"\\#hash",
+		"data/",
+		"!data/keep",
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{name: "Glob anywhere", path: "/home/user/.config/app/debug.log", ignored: true},
+		{name: "Negation", path: "/home/user/.config/keep.log", ignored: false},
+		{name: "Directory only matches directory", path: "/home/user/.cache", isDir: true, ignored: true},
+		{name: "Directory only skips files", path: "/home/user/.cache", isDir: false, ignored: false},
+		{name: "Parent directory excluded", path: "/home/user/.cache/pip/http", ignored: true},
+		{name: "Nested directory only", path: "/home/user/.config/.cache/x", ignored: true},
+		{name: "Anchored at base", path: "/home/user/.top", ignored: true},
+		{name: "Anchored does not match nested", path: "/home/user/.config/.top", ignored: false},
+		{name: "Trailing double star", path: "/home/user/build/out/bin", ignored: true},
+		{name: "Trailing double star not dir itself", path: "/home/user/build", isDir: true, ignored: false},
+		{name: "Leading double star", path: "/home/user/.ssh/secrets/id.pem", ignored: true},
+		{name: "Leading double star at base", path: "/home/user/secrets/id.pem", ignored: true},
+		{name: "Middle double star zero dirs", path: "/home/user/a/z", ignored: true},
+		{name: "Middle double star many dirs", path: "/home/user/a/b/c/z", ignored: true},
+		{name: "Character class", path: "/home/user/tmp7", ignored: true},
+		{name: "Character class mismatch", path: "/home/user/tmpx", ignored: false},
+		{name: "Escaped bang", path: "/home/user/!bang", ignored: true},
+		{name: "Escaped hash", path: "/home/user/#hash", ignored: true},
+		{name: "Cannot reinclude under excluded dir", path: "/home/user/data/keep", ignored: true},
+		{name: "Outside base", path: "/opt/conf/app.log", ignored: true},
+		{name: "Unmatched", path: "/home/user/.bashrc", ignored: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Ignored(tt.path, tt.isDir); got != tt.ignored {
+				t.Errorf("Ignored(%q) = %v, want %v (%+v)", tt.path, got, tt.ignored, m.Explain(tt.path, tt.isDir))
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	m := NewMatcher("/home/user")
+	m.AddPatterns("test", []string{".cache/", "*.log", "!keep.log"})
+
+	result := m.Explain("/home/user/.cache/pip/x", false)
+	if !result.Ignored || result.Rule == nil || result.Rule.Pattern != ".cache/" {
+		t.Errorf("Explain() = %+v, want excluded by .cache/", result)
+	}
+	if result.Parent != "/home/user/.cache" {
+		t.Errorf("Explain().Parent = %q, want /home/user/.cache", result.Parent)
+	}
+
+	result = m.Explain("/home/user/keep.log", false)
+	if result.Ignored || result.Rule == nil || !result.Rule.Negate {
+		t.Errorf("Explain() = %+v, want included by !keep.log", result)
+	}
+
+	result = m.Explain("/home/user/.bashrc", false)
+	if result.Ignored || result.Rule != nil {
+		t.Errorf("Explain() = %+v, want no rule", result)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	home, err := os.MkdirTemp("", "dotback-ignore-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(home)
+
+	configDir := filepath.Join(home, ".config", "dotback")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, FileName), []byte("# home rules\n!.cache/keep/\n.zsh_history\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ignore file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, FileName), []byte(".viminfo  \n"), 0644); err != nil {
+		t.Fatalf("Failed to write ignore file: %v", err)
+	}
+
+	m, err := Load(home, configDir, []string{"*.bak"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for path, want := range map[string]bool{
+		".zsh_history":     true,
+		".viminfo":         true,
+		".vimrc.bak":       true,
+		".cache/pip/x":     true,
+		".config/nvim/lua": false,
+	} {
+		if got := m.Ignored(filepath.Join(home, path), false); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	result := m.Explain(filepath.Join(home, ".zsh_history"), false)
+	if result.Rule == nil || result.Rule.String() != filepath.Join(home, FileName)+":3: .zsh_history" {
+		t.Errorf("Rule = %v, want source with line number", result.Rule)
+	}
+}
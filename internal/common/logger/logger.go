@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 )
@@ -24,6 +25,13 @@ func SetDebugMode(enabled bool) {
 	isDebugMode = enabled
 }
 
+// SetOutput redirects debug and info messages, for example to keep stdout
+// clean for machine-readable output
+func SetOutput(w io.Writer) {
+	debugLogger.SetOutput(w)
+	infoLogger.SetOutput(w)
+}
+
 // Debug logs a debug message if debug mode is enabled
 func Debug(format string, v ...interface{}) {
 	if isDebugMode {
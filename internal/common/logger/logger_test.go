@@ -81,3 +81,25 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestSetOutput(t *testing.T) {
+	var stdout, redirected bytes.Buffer
+	debugLogger = log.New(&stdout, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
+	infoLogger = log.New(&stdout, "INFO: ", log.Ldate|log.Ltime)
+
+	SetOutput(&redirected)
+	SetDebugMode(true)
+	defer SetDebugMode(false)
+
+	Info("redirected info")
+	Debug("redirected debug")
+
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output on original writer, got %q", stdout.String())
+	}
+	for _, message := range []string{"redirected info", "redirected debug"} {
+		if !strings.Contains(redirected.String(), message) {
+			t.Errorf("expected redirected output containing %q, got %q", message, redirected.String())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is a machine-readable output format
+type Format string
+
+const (
+	FormatText   Format = ""
+	FormatJSON   Format = "json"
+	FormatYAML   Format = "yaml"
+	FormatTable  Format = "table"
+	FormatNDJSON Format = "ndjson"
+)
+
+// ParseFormat validates a --output flag value
+func ParseFormat(value string) (Format, error) {
+	switch f := Format(strings.ToLower(value)); f {
+	case FormatText, FormatJSON, FormatYAML, FormatTable, FormatNDJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (expected json, yaml, table or ndjson)", value)
+}
+
+// WriteJSON writes v as indented JSON
+func WriteJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WriteNDJSON writes v as a single line of JSON
+func WriteNDJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// WriteTable writes rows as aligned columns under the given headers
+func WriteTable(w io.Writer, headers []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteYAML writes v as YAML. The value is first encoded as JSON so that
+// struct tags and field order are the same as in the JSON output.
+func WriteYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, err := decodeNode(dec)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	writeYAMLNode(&b, root, 0)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// node is a decoded JSON value that keeps object keys in their original order
+type node struct {
+	keys   []string
+	values []*node
+	items  []*node
+	scalar interface{}
+	kind   byte // 'o'bject, 'a'rray or 's'calar
+}
+
+func decodeNode(dec *json.Decoder) (*node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		n := &node{kind: 'o'}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, key.(string))
+			n.values = append(n.values, value)
+		}
+		_, err := dec.Token()
+		return n, err
+	case json.Delim('['):
+		n := &node{kind: 'a'}
+		for dec.More() {
+			item, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+		_, err := dec.Token()
+		return n, err
+	}
+	return &node{kind: 's', scalar: tok}, nil
+}
+
+func (n *node) isEmptyCollection() bool {
+	return (n.kind == 'o' && len(n.keys) == 0) || (n.kind == 'a' && len(n.items) == 0)
+}
+
+func writeYAMLNode(b *strings.Builder, n *node, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch {
+	case n.isEmptyCollection() || n.kind == 's':
+		b.WriteString(pad + inlineYAML(n) + "\n")
+	case n.kind == 'o':
+		for i, key := range n.keys {
+			writeYAMLEntry(b, pad+yamlString(key)+":", n.values[i], indent)
+		}
+	case n.kind == 'a':
+		for _, item := range n.items {
+			if item.kind == 'o' && !item.isEmptyCollection() {
+				// The first key shares the line with the dash
+				var sub strings.Builder
+				writeYAMLNode(&sub, item, indent+1)
+				b.WriteString(pad + "- " + strings.TrimPrefix(sub.String(), pad+"  "))
+				continue
+			}
+			writeYAMLEntry(b, pad+"-", item, indent)
+		}
+	}
+}
+
+func writeYAMLEntry(b *strings.Builder, prefix string, value *node, indent int) {
+	if value.kind == 's' || value.isEmptyCollection() {
+		b.WriteString(prefix + " " + inlineYAML(value) + "\n")
+		return
+	}
+	b.WriteString(prefix + "\n")
+	writeYAMLNode(b, value, indent+1)
+}
+
+func inlineYAML(n *node) string {
+	switch n.kind {
+	case 'o':
+		return "{}"
+	case 'a':
+		return "[]"
+	}
+	switch v := n.scalar.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return fmt.Sprint(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlString(v)
+	}
+	return fmt.Sprint(n.scalar)
+}
+
+var plainYAML = regexp.MustCompile(`^[A-Za-z_/~.][A-Za-z0-9_./~+-]*$`)
+
+// leadingDotNumber matches strings such as ".5", which YAML reads as floats
+var leadingDotNumber = regexp.MustCompile(`^\.[0-9]`)
+
+// yamlString returns s unquoted when that is unambiguous, otherwise as a
+// double-quoted string, which YAML shares with JSON
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "y", "n", "~", ".inf", ".nan":
+	default:
+		if plainYAML.MatchString(s) && !leadingDotNumber.MatchString(s) {
+			return s
+		}
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// FormatBytes formats a size in bytes using binary units, e.g. 1.5 MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
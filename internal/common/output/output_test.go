@@ -0,0 +1,137 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Format
+		wantErr bool
+	}{
+		{value: "", want: FormatText},
+		{value: "json", want: FormatJSON},
+		{value: "YAML", want: FormatYAML},
+		{value: "table", want: FormatTable},
+		{value: "ndjson", want: FormatNDJSON},
+		{value: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseFormat(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	app := types.App{
+		Name:    "git",
+		Version: "2.43.0",
+		ConfigFiles: []types.DotFile{
+			{Path: "/home/user/.gitconfig", LastModified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Hash: "00ab", IsSymlink: false},
+		},
+		Dependencies: map[string]string{"on": "yes"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteYAML(&buf, map[string]interface{}{"apps": []types.App{app}, "empty": []string{}}); err != nil {
+		t.Fatalf("WriteYAML() error = %v", err)
+	}
+
+	want := `apps:
+  - name: git
+    version: "2.43.0"
+    config_files:
+      - path: /home/user/.gitconfig
+        last_modified: "2024-01-02T03:04:05Z"
+        hash: "00ab"
+        is_symlink: false
+    dependencies:
+      "on": "yes"
+empty: []
+`
+	if buf.String() != want {
+		t.Errorf("WriteYAML() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestYAMLString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{s: "~/.bashrc", want: "~/.bashrc"},
+		{s: ".config", want: ".config"},
+		{s: ".5", want: `".5"`},
+		{s: ".25e3", want: `".25e3"`},
+		{s: ".Inf", want: `".Inf"`},
+		{s: ".nan", want: `".nan"`},
+		{s: "1.5", want: `"1.5"`},
+	}
+
+	for _, tt := range tests {
+		if got := yamlString(tt.s); got != tt.want {
+			t.Errorf("yamlString(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	for _, path := range []string{"/a", "/b"} {
+		if err := WriteNDJSON(&buf, types.DotFile{Path: path}); err != nil {
+			t.Fatalf("WriteNDJSON() error = %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"path":"/a"`) {
+		t.Errorf("WriteNDJSON() = %q", buf.String())
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, []string{"APP", "PATH"}, [][]string{{"git", "/home/user/.gitconfig"}, {"nvim", "/x"}}); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+
+	want := "APP   PATH\ngit   /home/user/.gitconfig\nnvim  /x\n"
+	if buf.String() != want {
+		t.Errorf("WriteTable() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 1023, want: "1023 B"},
+		{n: 1024, want: "1.0 KiB"},
+		{n: 1536, want: "1.5 KiB"},
+		{n: 10 << 20, want: "10.0 MiB"},
+		{n: 300 << 20, want: "300.0 MiB"},
+		{n: 3 << 30, want: "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
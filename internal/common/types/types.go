@@ -1,14 +1,63 @@
 package types
 
 import (
+	"errors"
 	"time"
 )
 
+// ErrNotFound is returned by a GitHubClient when a file does not exist
+var ErrNotFound = errors.New("not found")
+
 // Config represents the application configuration
 type Config struct {
-	GitHubToken string    `json:"github_token"`
-	LastBackup  time.Time `json:"last_backup"`
-	Machine     Machine   `json:"machine"`
+	GitHubToken string        `json:"github_token"`
+	LastBackup  time.Time     `json:"last_backup"`
+	Repository  string        `json:"repository"`
+	Machine     Machine       `json:"machine"`
+	Ignore      []string      `json:"ignore"`
+	SecretAllow []string      `json:"secret_allow"`
+	Scan        ScanConfig    `json:"scan"`
+	Backup      BackupConfig  `json:"backup"`
+	Symlinks    SymlinkConfig `json:"symlinks"`
+	Restore     RestoreConfig `json:"restore"`
+}
+
+// ScanConfig holds the limits applied while scanning
+type ScanConfig struct {
+	MaxFileSize   int64 `json:"max_file_size"`
+	MaxSQLiteSize int64 `json:"max_sqlite_size"`
+	SkipBinary    bool  `json:"skip_binary"`
+	RecordOwner   bool  `json:"record_owner"`
+}
+
+// BackupConfig controls how large files are stored in the backup
+// repository. SizePolicy is "warn" or "reject" and applies to files larger
+// than LargeFileSize.
+type BackupConfig struct {
+	ChunkSize     int64  `json:"chunk_size"`
+	LargeFileSize int64  `json:"large_file_size"`
+	SizePolicy    string `json:"size_policy"`
+}
+
+// SymlinkConfig selects what a scan does with symbolic links: "preserve"
+// records the link and its target, "follow" backs up what it points to and
+// "skip" leaves it out. Paths maps paths or glob patterns, such as
+// "~/.config/nvim" or "~/bin/*", to a policy that overrides Default for
+// them and everything below them.
+type SymlinkConfig struct {
+	Default string            `json:"default"`
+	Paths   map[string]string `json:"paths"`
+}
+
+// RestoreConfig selects how restored files are put in place: "symlink"
+// links them to dotback's store, "copy" writes copies, "hardlink" links
+// them to the store's copies and "render" expands them as templates. Paths
+// maps paths or glob patterns, and Apps maps application names, to a mode
+// that overrides Mode for them. A path wins over an application.
+type RestoreConfig struct {
+	Mode  string            `json:"mode"`
+	Paths map[string]string `json:"paths"`
+	Apps  map[string]string `json:"apps"`
 }
 
 // Machine represents a machine configuration
@@ -21,12 +70,23 @@ type Machine struct {
 	Description string            `json:"description"`
 }
 
-// DotFile represents a dotfile configuration
+// DotFile represents a dotfile configuration. Mode holds the permission
+// bits in octal, e.g. "0755". Owner is "user:group" and is only recorded
+// when record_owner is set. Chunks counts the parts a large file is split
+// into in the backup repository. LinkTarget is what a preserved symlink
+// points to.
 type DotFile struct {
 	Path         string    `json:"path"`
 	LastModified time.Time `json:"last_modified"`
 	Hash         string    `json:"hash"`
 	IsSymlink    bool      `json:"is_symlink"`
+	LinkTarget   string    `json:"link_target,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	Mode         string    `json:"mode,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+	Chunks       int       `json:"chunks,omitempty"`
+	Binary       bool      `json:"binary,omitempty"`
+	Secrets      []string  `json:"secrets,omitempty"`
 }
 
 // App represents an application configuration
@@ -45,6 +105,22 @@ type Repository struct {
 	Private     bool   `json:"private"`
 }
 
+// FileChange is a file written or removed by a batch commit. Executable
+// files are committed with git's executable mode.
+type FileChange struct {
+	Path       string `json:"path"`
+	Content    []byte `json:"-"`
+	Executable bool   `json:"executable,omitempty"`
+	Delete     bool   `json:"delete,omitempty"`
+}
+
+// Commit is a commit in the history of a backup repository
+type Commit struct {
+	SHA     string    `json:"sha"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
 // GitHubClient interface defines the methods needed for GitHub operations
 type GitHubClient interface {
 	// Authentication
@@ -54,12 +130,22 @@ type GitHubClient interface {
 	// Repository operations
 	ListRepositories() ([]Repository, error)
 	CreateRepository(name, description string, private bool) error
+	DefaultBranch(repo string) (string, error)
 	DeleteRepository(name string) error
 
-	// Content operations
+	// Content operations. repo is either "owner/name" or the name of one of
+	// the authenticated user's repositories.
 	UploadFile(repo, path string, content []byte, message string) error
 	DownloadFile(repo, path string) ([]byte, error)
 	ListFiles(repo, path string) ([]string, error)
+
+	// Batch operations
+	CommitFiles(repo, branch string, changes []FileChange, message string) (string, error)
+
+	// History operations. ref is a commit SHA, a branch or a tag.
+	ResolveRef(repo, ref string) (Commit, error)
+	ListCommits(repo, path string, until time.Time, limit int) ([]Commit, error)
+	DownloadFileAt(repo, path, ref string) ([]byte, error)
 }
 
 // FileSystem interface defines the methods needed for file operations
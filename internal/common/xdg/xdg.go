@@ -0,0 +1,142 @@
+package xdg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Variables naming the base directories in portable paths
+const (
+	ConfigHomeVar = "$XDG_CONFIG_HOME"
+	DataHomeVar   = "$XDG_DATA_HOME"
+	StateHomeVar  = "$XDG_STATE_HOME"
+	CacheHomeVar  = "$XDG_CACHE_HOME"
+)
+
+// Dirs holds the base directories for the current user. Paths stored in
+// backups are converted to a portable form relative to these directories so
+// they restore correctly on machines with different XDG settings.
+type Dirs struct {
+	Home       string
+	ConfigHome string
+	DataHome   string
+	StateHome  string
+	CacheHome  string
+}
+
+// Load reads the base directories from the environment. Unset or relative
+// values fall back to the defaults from the XDG Base Directory
+// specification, as the specification requires.
+func Load() (Dirs, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Dirs{}, fmt.Errorf("error getting home directory: %w", err)
+	}
+	return Dirs{
+		Home:       home,
+		ConfigHome: lookup("XDG_CONFIG_HOME", filepath.Join(home, ".config")),
+		DataHome:   lookup("XDG_DATA_HOME", filepath.Join(home, ".local", "share")),
+		StateHome:  lookup("XDG_STATE_HOME", filepath.Join(home, ".local", "state")),
+		CacheHome:  lookup("XDG_CACHE_HOME", filepath.Join(home, ".cache")),
+	}, nil
+}
+
+func lookup(name, fallback string) string {
+	if value := os.Getenv(name); filepath.IsAbs(value) {
+		return filepath.Clean(value)
+	}
+	return fallback
+}
+
+// bases returns the base directories with their variables, most specific
+// first so nested directories win over the ones containing them
+func (d Dirs) bases() []struct{ name, dir string } {
+	bases := []struct{ name, dir string }{
+		{ConfigHomeVar, d.ConfigHome},
+		{DataHomeVar, d.DataHome},
+		{StateHomeVar, d.StateHome},
+		{CacheHomeVar, d.CacheHome},
+	}
+	sort.SliceStable(bases, func(i, j int) bool { return len(bases[i].dir) > len(bases[j].dir) })
+	return bases
+}
+
+// Portable returns path relative to the base directory that contains it,
+// e.g. "$XDG_CONFIG_HOME/nvim/init.lua" or "~/.bashrc". Paths outside the
+// home and base directories are returned unchanged. Portable paths always
+// use forward slashes.
+func (d Dirs) Portable(path string) string {
+	path = filepath.Clean(path)
+	for _, base := range d.bases() {
+		if rel, ok := within(base.dir, path); ok {
+			return join(base.name, rel)
+		}
+	}
+	if rel, ok := within(d.Home, path); ok {
+		return join("~", rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+// Resolve is the inverse of Portable. It expands a leading ~ or base
+// directory variable using this machine's directories.
+func (d Dirs) Resolve(path string) string {
+	prefix, rest, _ := strings.Cut(path, "/")
+	var dir string
+	switch prefix {
+	case "~":
+		dir = d.Home
+	case ConfigHomeVar:
+		dir = d.ConfigHome
+	case DataHomeVar:
+		dir = d.DataHome
+	case StateHomeVar:
+		dir = d.StateHome
+	case CacheHomeVar:
+		dir = d.CacheHome
+	default:
+		return filepath.FromSlash(path)
+	}
+	return filepath.Join(dir, filepath.FromSlash(rest))
+}
+
+// MatchPath reports whether an absolute path or glob pattern matches path
+// or one of the directories above it, so that "~/.config/n*" selects
+// everything inside ~/.config/nvim
+func MatchPath(pattern, path string) bool {
+	for {
+		if path == pattern {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// within returns path relative to dir, if path is dir or inside it
+func within(dir, path string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func join(prefix, rel string) string {
+	if rel == "." {
+		return prefix
+	}
+	return prefix + "/" + filepath.ToSlash(rel)
+}
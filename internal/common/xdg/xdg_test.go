@@ -0,0 +1,115 @@
+package xdg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "/custom/config")
+	t.Setenv("XDG_DATA_HOME", "relative/is/ignored")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "/custom/cache/")
+
+	dirs, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := Dirs{
+		Home:       home,
+		ConfigHome: "/custom/config",
+		DataHome:   filepath.Join(home, ".local", "share"),
+		StateHome:  filepath.Join(home, ".local", "state"),
+		CacheHome:  "/custom/cache",
+	}
+	if dirs != want {
+		t.Errorf("Load() = %+v, want %+v", dirs, want)
+	}
+}
+
+func TestPortable(t *testing.T) {
+	dirs := Dirs{
+		Home:       "/home/user",
+		ConfigHome: "/home/user/.config",
+		DataHome:   "/home/user/.local/share",
+		StateHome:  "/home/user/.local/state",
+		CacheHome:  "/var/cache/user",
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/home/user/.config/nvim/init.lua", want: "$XDG_CONFIG_HOME/nvim/init.lua"},
+		{path: "/home/user/.config", want: "$XDG_CONFIG_HOME"},
+		{path: "/home/user/.local/share/fonts/a.ttf", want: "$XDG_DATA_HOME/fonts/a.ttf"},
+		{path: "/home/user/.local/state/dotback", want: "$XDG_STATE_HOME/dotback"},
+		{path: "/var/cache/user/x", want: "$XDG_CACHE_HOME/x"},
+		{path: "/home/user/.bashrc", want: "~/.bashrc"},
+		{path: "/home/user/.configx", want: "~/.configx"},
+		{path: "/home/user", want: "~"},
+		{path: "/etc/hosts", want: "/etc/hosts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := dirs.Portable(tt.path)
+			if got != tt.want {
+				t.Errorf("Portable() = %q, want %q", got, tt.want)
+			}
+			if back := dirs.Resolve(got); back != tt.path {
+				t.Errorf("Resolve(%q) = %q, want %q", got, back, tt.path)
+			}
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/h/.config/nvim", path: "/h/.config/nvim", want: true},
+		{pattern: "/h/.config/nvim", path: "/h/.config/nvim/lua/init.lua", want: true},
+		{pattern: "/h/.config/nvim", path: "/h/.config/nvimrc", want: false},
+		{pattern: "/h/.config/n*", path: "/h/.config/nvim/init.lua", want: true},
+		{pattern: "/h/.ssh/*", path: "/h/.ssh/config", want: true},
+		{pattern: "/h/.ssh/*", path: "/h/.sshrc", want: false},
+		{pattern: "/h/.*rc", path: "/h/.config/bashrc", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("MatchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResolveOtherMachine(t *testing.T) {
+	dirs := Dirs{
+		Home:       "/Users/other",
+		ConfigHome: "/Users/other/dotfiles/config",
+		DataHome:   "/Users/other/.local/share",
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "$XDG_CONFIG_HOME/nvim/init.lua", want: "/Users/other/dotfiles/config/nvim/init.lua"},
+		{path: "$XDG_DATA_HOME/app", want: "/Users/other/.local/share/app"},
+		{path: "~/.zshrc", want: "/Users/other/.zshrc"},
+		{path: "~", want: "/Users/other"},
+		{path: "/etc/hosts", want: "/etc/hosts"},
+	}
+
+	for _, tt := range tests {
+		if got := dirs.Resolve(tt.path); got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// ApplyAttributes sets the recorded owner, permission bits and modification
+// time of a restored file. The file itself is already in place, so every
+// attribute that cannot be set is returned as a warning instead of an
+// error. Symlinks are left alone, since changing them would change the
+// file they point to.
+func ApplyAttributes(path string, file types.DotFile) []string {
+	if file.IsSymlink {
+		return nil
+	}
+
+	var warnings []string
+	if file.Owner != "" {
+		if warning := applyOwner(path, file.Owner); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	if file.Mode != "" {
+		mode, err := ParseMode(file.Mode)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", path, err))
+		} else if err := os.Chmod(path, mode); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: could not set mode %s: %v", path, file.Mode, err))
+		}
+	}
+	if !file.LastModified.IsZero() {
+		if err := os.Chtimes(path, time.Now(), file.LastModified); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: could not set modification time: %v", path, err))
+		}
+	}
+	return warnings
+}
+
+// writeFile writes content to path, creating it with the file's recorded
+// permission bits so that a private file, such as an SSH key, is never
+// readable by others while it is written. An existing file is given those
+// bits before it is rewritten. The owner keeps write permission until
+// ApplyAttributes sets the exact mode.
+func writeFile(path string, file types.DotFile, content []byte) error {
+	perm := os.FileMode(0644)
+	if file.Mode != "" {
+		if mode, err := ParseMode(file.Mode); err == nil {
+			perm = mode | 0200
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	if err := os.Chmod(path, perm); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error setting mode: %w", err)
+	}
+	if err := os.WriteFile(path, content, perm); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
+
+// ParseMode parses permission bits recorded in octal, e.g. "0755"
+func ParseMode(mode string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || bits > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return os.FileMode(bits), nil
+}
+
+// applyOwner sets the owner and group recorded as "user:group". Only root
+// can give a file to another user, so anyone else gets a warning instead.
+func applyOwner(path, owner string) string {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, err := lookupID(userName, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return fmt.Sprintf("%s: unknown user %s, owner left unchanged", path, userName)
+	}
+	gid := -1
+	if groupName != "" {
+		gid, err = lookupID(groupName, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Sprintf("%s: unknown group %s, owner left unchanged", path, groupName)
+		}
+	}
+
+	if euid := os.Geteuid(); euid != 0 && euid != uid {
+		return fmt.Sprintf("%s: not running as %s, owner left unchanged", path, userName)
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Sprintf("%s: could not set owner %s: %v", path, owner, err)
+	}
+	return ""
+}
+
+// lookupID resolves a user or group name to its ID. Names recorded as
+// numbers, because they had no name on the original machine, are used
+// as they are.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
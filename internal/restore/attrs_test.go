@@ -0,0 +1,147 @@
+package restore
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func writeTestFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("Host *\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return path
+}
+
+func TestApplyAttributes(t *testing.T) {
+	path := writeTestFile(t)
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	warnings := ApplyAttributes(path, types.DotFile{Mode: "0600", LastModified: modified})
+	if len(warnings) != 0 {
+		t.Errorf("ApplyAttributes() warnings = %v", warnings)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Mode = %v, want 0600", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime(), modified)
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Permission bits are not enforced on Windows")
+	}
+	perm := func(path string) os.FileMode {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		return info.Mode().Perm()
+	}
+
+	// New files are created private, before any attributes are applied
+	path := filepath.Join(t.TempDir(), ".ssh", "id_ed25519")
+	if err := writeFile(path, types.DotFile{Mode: "0600"}, []byte("key\n")); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+	if got := perm(path); got != 0600 {
+		t.Errorf("Mode of a new file = %v, want 0600", got)
+	}
+
+	// Existing files lose their wider bits before they are rewritten
+	existing := writeTestFile(t)
+	if err := writeFile(existing, types.DotFile{Mode: "0600"}, []byte("key\n")); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+	if got := perm(existing); got != 0600 {
+		t.Errorf("Mode of a rewritten file = %v, want 0600", got)
+	}
+
+	// Read-only files can still be rewritten
+	if err := os.Chmod(existing, 0400); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if err := writeFile(existing, types.DotFile{Mode: "0400"}, []byte("new key\n")); err != nil {
+		t.Fatalf("writeFile() of a read-only file error = %v", err)
+	}
+	if content, _ := os.ReadFile(existing); string(content) != "new key\n" {
+		t.Errorf("Rewritten file = %q", content)
+	}
+
+	// Files without a recorded mode get the usual bits
+	plain := filepath.Join(t.TempDir(), ".bashrc")
+	if err := writeFile(plain, types.DotFile{}, []byte("alias ll='ls -l'\n")); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+	if got := perm(plain); got&^0644 != 0 {
+		t.Errorf("Mode of a plain file = %v, want at most 0644", got)
+	}
+}
+
+func TestApplyAttributesWarnings(t *testing.T) {
+	path := writeTestFile(t)
+
+	warnings := ApplyAttributes(path, types.DotFile{Mode: "rwx"})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `invalid mode "rwx"`) {
+		t.Errorf("ApplyAttributes() warnings = %v", warnings)
+	}
+
+	warnings = ApplyAttributes(path, types.DotFile{Owner: "no-such-user-dotback:staff"})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "unknown user") {
+		t.Errorf("ApplyAttributes() warnings = %v", warnings)
+	}
+
+	// Links are not followed
+	if warnings := ApplyAttributes(filepath.Join(t.TempDir(), "missing"), types.DotFile{Mode: "0600", IsSymlink: true}); warnings != nil {
+		t.Errorf("ApplyAttributes() on a symlink warnings = %v", warnings)
+	}
+}
+
+func TestApplyAttributesOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file ownership is not available on Windows")
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Could not look up the current user: %v", err)
+	}
+	path := writeTestFile(t)
+
+	// The current user can keep a file it owns
+	if warnings := ApplyAttributes(path, types.DotFile{Owner: current.Username}); len(warnings) != 0 {
+		t.Errorf("ApplyAttributes() warnings = %v", warnings)
+	}
+
+	// Only root can give a file to someone else
+	if os.Geteuid() != 0 {
+		warnings := ApplyAttributes(path, types.DotFile{Owner: "0:0"})
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "not running as 0") {
+			t.Errorf("ApplyAttributes() warnings = %v", warnings)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if mode, err := ParseMode("0755"); err != nil || mode != 0755 {
+		t.Errorf("ParseMode(0755) = %v, %v", mode, err)
+	}
+	for _, invalid := range []string{"", "0999", "17777", "abc"} {
+		if _, err := ParseMode(invalid); err == nil {
+			t.Errorf("ParseMode(%q) should return an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// ConflictsDir is the directory under dotback's state directory that
+	// keeps the files restores replaced, in one directory per restore
+	ConflictsDir = "conflicts"
+	// ConflictIndexFile lists the files saved in a conflicts directory
+	ConflictIndexFile = "index.json"
+
+	// ReasonConflict fails a file that differs from the existing one
+	ReasonConflict = "conflicts with the existing file"
+	// keptSuffix is added to an existing file kept next to a restored one
+	keptSuffix = ".orig"
+)
+
+// Resolution is what happens to an existing file in the way of a restored
+// one
+type Resolution string
+
+const (
+	// ResolveOverwrite saves the existing file and replaces it
+	ResolveOverwrite Resolution = "overwrite"
+	// ResolveSkip leaves the existing file and skips the restored one
+	ResolveSkip Resolution = "skip"
+	// ResolveKeepBoth saves the existing file, renames it with an ".orig"
+	// suffix and restores the file in its place
+	ResolveKeepBoth Resolution = "keep-both"
+	// ResolveFail leaves the existing file and fails the restored one
+	ResolveFail Resolution = "fail"
+)
+
+// ConflictDirName returns the name of the conflicts directory of a restore
+// started at t, e.g. "20240501T120000Z"
+func ConflictDirName(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Conflict is an existing file that differs from the file to restore.
+// Existing and Restored are their contents; for a symlink that is its
+// target.
+type Conflict struct {
+	Path     string
+	Target   string
+	Existing []byte
+	Restored []byte
+}
+
+// Diff returns a unified diff from the existing file to the restored one
+func (c Conflict) Diff() string {
+	return Diff(c.Target+" (existing)", c.Target+" (restored)", c.Existing, c.Restored)
+}
+
+// Replaced records an existing file that a restore saved before replacing
+// it. Kept is where the file was renamed to when both were kept.
+type Replaced struct {
+	Path       string     `json:"path"`
+	Target     string     `json:"target"`
+	Saved      string     `json:"saved"`
+	Resolution Resolution `json:"resolution,omitempty"`
+	Kept       string     `json:"kept,omitempty"`
+}
+
+// ConflictIndex is the index file of a conflicts directory
+type ConflictIndex struct {
+	Machine string     `json:"machine"`
+	Created time.Time  `json:"created"`
+	Files   []Replaced `json:"files"`
+}
+
+// saveExisting copies the file at path into the conflicts directory, below
+// the absolute path of the target it was restored to. A symlink is saved
+// as a symlink.
+func (r *Restore) saveExisting(path, target string) (string, error) {
+	if r.Conflicts == "" {
+		return "", fmt.Errorf("no directory to save %s to", target)
+	}
+	saved := filepath.Join(r.Conflicts, target)
+	if err := os.MkdirAll(filepath.Dir(saved), 0755); err != nil {
+		return "", fmt.Errorf("error creating conflicts directory: %w", err)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Symlink(link, saved); err != nil {
+			return "", fmt.Errorf("error saving %s: %w", target, err)
+		}
+		return saved, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(saved, content, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("error saving %s: %w", target, err)
+	}
+	return saved, nil
+}
+
+// keptPath returns an unused name for an existing file kept next to the
+// restored one
+func keptPath(target string) string {
+	kept := target + keptSuffix
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(kept); os.IsNotExist(err) {
+			return kept
+		}
+		kept = fmt.Sprintf("%s%s.%d", target, keptSuffix, i)
+	}
+}
+
+// writeConflictIndex writes the index of the files the restore saved
+func (r *Restore) writeConflictIndex(files []Replaced) error {
+	index := ConflictIndex{Machine: r.Machine, Created: time.Now(), Files: files}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding conflicts index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(r.Conflicts, ConflictIndexFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing conflicts index: %w", err)
+	}
+	return nil
+}
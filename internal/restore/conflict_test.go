@@ -0,0 +1,136 @@
+package restore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestRestoreConflicts(t *testing.T) {
+	home, r := newTestRestore(t, mapSource{
+		"~/.bashrc":    "alias ll='ls -l'\n",
+		"~/.zshrc":     "setopt autocd\n",
+		"~/.vimrc":     "set number\n",
+		"~/.inputrc":   "set bell-style none\n",
+		"~/.gitconfig": "[user]\n",
+		"~/.profile":   "export EDITOR=nvim\n",
+	})
+	r.Conflicts = filepath.Join(home, ".local/state/dotback", ConflictsDir, "20240501T120000Z")
+	for name, content := range map[string]string{
+		".bashrc":    "# mine\n",
+		".zshrc":     "# mine\n",
+		".vimrc":     "# mine\n",
+		".inputrc":   "# mine\n",
+		".gitconfig": "[user]\n",
+	} {
+		if err := os.WriteFile(filepath.Join(home, name), []byte(content), 0640); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(home, ".profile"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	resolutions := map[string]Resolution{
+		"~/.bashrc":  ResolveOverwrite,
+		"~/.zshrc":   ResolveKeepBoth,
+		"~/.vimrc":   ResolveSkip,
+		"~/.inputrc": ResolveFail,
+	}
+	var asked []string
+	r.OnConflict = func(conflict Conflict) (Resolution, error) {
+		asked = append(asked, conflict.Path)
+		if conflict.Path == "~/.bashrc" && !strings.Contains(conflict.Diff(), "-# mine\n+alias ll='ls -l'\n") {
+			t.Errorf("Diff() = %q", conflict.Diff())
+		}
+		return resolutions[conflict.Path], nil
+	}
+
+	result := r.Run([]types.DotFile{
+		{Path: "~/.bashrc"}, {Path: "~/.zshrc"}, {Path: "~/.vimrc"},
+		{Path: "~/.inputrc"}, {Path: "~/.gitconfig"}, {Path: "~/.profile"},
+	})
+	if len(asked) != 4 {
+		t.Errorf("OnConflict called for %v", asked)
+	}
+	statuses := map[string]Status{}
+	for _, outcome := range result.Files {
+		statuses[outcome.Path] = outcome.Status
+	}
+	want := map[string]Status{
+		"~/.bashrc":    StatusRestored,
+		"~/.zshrc":     StatusRestored,
+		"~/.vimrc":     StatusSkipped,
+		"~/.inputrc":   StatusFailed,
+		"~/.gitconfig": StatusRestored,
+		"~/.profile":   StatusFailed,
+	}
+	for path, status := range want {
+		if statuses[path] != status {
+			t.Errorf("%s = %s, want %s", path, statuses[path], status)
+		}
+	}
+
+	// Replaced files are saved below their absolute paths and indexed
+	saved := filepath.Join(r.Conflicts, home, ".bashrc")
+	if content, _ := os.ReadFile(saved); string(content) != "# mine\n" {
+		t.Errorf("Saved .bashrc = %q", content)
+	}
+	if info, err := os.Stat(saved); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Saved .bashrc mode = %v, %v", info, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".zshrc.orig")); string(content) != "# mine\n" {
+		t.Errorf("Kept .zshrc = %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".zshrc")); string(content) != "setopt autocd\n" {
+		t.Errorf("Restored .zshrc = %q", content)
+	}
+	for _, name := range []string{".vimrc", ".inputrc"} {
+		if content, _ := os.ReadFile(filepath.Join(home, name)); string(content) != "# mine\n" {
+			t.Errorf("%s was replaced: %q", name, content)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.Conflicts, ConflictIndexFile))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	var index ConflictIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("Invalid index: %v", err)
+	}
+	if index.Machine != "laptop" || len(index.Files) != 3 {
+		t.Fatalf("Index = %+v", index)
+	}
+	if kept := index.Files[1]; kept.Path != "~/.zshrc" || kept.Resolution != ResolveKeepBoth || kept.Kept != filepath.Join(home, ".zshrc.orig") {
+		t.Errorf("Index entry = %+v", kept)
+	}
+	if same := index.Files[2]; same.Path != "~/.gitconfig" || same.Resolution != ResolveOverwrite {
+		t.Errorf("Index entry = %+v", same)
+	}
+
+	// Without a conflicts directory nothing is replaced
+	r.Conflicts = ""
+	r.OnConflict = func(Conflict) (Resolution, error) { return ResolveOverwrite, nil }
+	result = r.Run([]types.DotFile{{Path: "~/.vimrc"}})
+	if outcome := result.Files[0]; outcome.Status != StatusFailed || !strings.Contains(outcome.Reason, "could not be saved") {
+		t.Errorf("Run() without a conflicts directory = %+v", outcome)
+	}
+}
+
+func TestKeptPath(t *testing.T) {
+	target := filepath.Join(t.TempDir(), ".bashrc")
+	if got := keptPath(target); got != target+".orig" {
+		t.Errorf("keptPath() = %q", got)
+	}
+	if err := os.WriteFile(target+".orig", nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if got := keptPath(target); got != target+".orig.1" {
+		t.Errorf("keptPath() = %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package restore
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is how many unchanged lines surround each change
+	diffContext = 3
+	// maxDiffCells caps the size of the table used to compare two files.
+	// Larger files are only reported as different.
+	maxDiffCells = 4 << 20
+)
+
+// Diff returns a unified diff from old to new, labelled with their names.
+// Binary files and files too large to compare are only reported as
+// different.
+func Diff(oldName, newName string, old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+	if bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 {
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+	}
+	a, b := splitLines(old), splitLines(new)
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return fmt.Sprintf("Files %s and %s differ\n", oldName, newName)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	edits := diffLines(a, b)
+	for start := 0; start < len(edits); {
+		// Find the next change and the extent of its hunk, merging changes
+		// whose context would overlap
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		from := max(first-diffContext, start)
+		to := first
+		for unchanged := 0; to < len(edits) && unchanged <= 2*diffContext; to++ {
+			if edits[to].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for to > first && edits[to-1].op == ' ' {
+			to--
+		}
+		to = min(to+diffContext, len(edits))
+
+		oldStart, newStart, oldCount, newCount := 1, 1, 0, 0
+		for _, edit := range edits[:from] {
+			if edit.op != '+' {
+				oldStart++
+			}
+			if edit.op != '-' {
+				newStart++
+			}
+		}
+		for _, edit := range edits[from:to] {
+			if edit.op != '+' {
+				oldCount++
+			}
+			if edit.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, edit := range edits[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", edit.op, edit.line)
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// lineEdit is one line of a diff: ' ' keeps it, '-' removes it and '+'
+// adds it
+type lineEdit struct {
+	op   byte
+	line string
+}
+
+// diffLines returns the edits turning a into b, using the longest common
+// subsequence of their lines
+func diffLines(a, b []string) []lineEdit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var edits []lineEdit
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, lineEdit{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, lineEdit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, lineEdit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, lineEdit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, lineEdit{'+', b[j]})
+	}
+	return edits
+}
+
+// splitLines splits content into lines without their line endings
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
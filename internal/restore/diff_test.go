@@ -0,0 +1,36 @@
+package restore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	if got := Diff("a", "b", []byte("same\n"), []byte("same\n")); got != "" {
+		t.Errorf("Diff() of equal files = %q", got)
+	}
+
+	got := Diff("old", "new", []byte("one\ntwo\nthree\n"), []byte("one\n2\nthree\nfour\n"))
+	want := "--- old\n+++ new\n@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n"
+	if got != want {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+
+	// Changes far apart get their own hunks with three lines of context
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	old := strings.Join(lines, "\n") + "\n"
+	lines[1], lines[17] = "changed 2", "changed 18"
+	got = Diff("old", "new", []byte(old), []byte(strings.Join(lines, "\n")+"\n"))
+	if !strings.Contains(got, "@@ -1,5 +1,5 @@\n line 1\n-line 2\n+changed 2\n line 3\n line 4\n line 5\n") ||
+		!strings.Contains(got, "@@ -15,6 +15,6 @@\n line 15\n line 16\n line 17\n-line 18\n+changed 18\n line 19\n line 20\n") {
+		t.Errorf("Diff() = %s", got)
+	}
+
+	if got := Diff("old", "new", []byte("a\x00"), []byte("b\x00")); got != "Binary files old and new differ\n" {
+		t.Errorf("Diff() of binary files = %q", got)
+	}
+}
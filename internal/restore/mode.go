@@ -0,0 +1,101 @@
+package restore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+const (
+	// ModeSymlink links a file to its copy in the store. It is the default.
+	ModeSymlink = "symlink"
+	// ModeCopy writes a regular file with the backed up content
+	ModeCopy = "copy"
+	// ModeHardlink hard links a file to its copy in the store, which must
+	// be on the same file system
+	ModeHardlink = "hardlink"
+	// ModeRender expands a file as a Go template and writes the result
+	ModeRender = "render"
+)
+
+// CheckMode returns an error unless mode is empty or a known restore mode
+func CheckMode(mode string) error {
+	switch mode {
+	case "", ModeSymlink, ModeCopy, ModeHardlink, ModeRender:
+		return nil
+	}
+	return fmt.Errorf("invalid restore mode %q, expected %q, %q, %q or %q", mode, ModeSymlink, ModeCopy, ModeHardlink, ModeRender)
+}
+
+// modeRule applies a mode to a path and everything below it, or to the
+// paths matching a glob pattern
+type modeRule struct {
+	pattern string
+	mode    string
+}
+
+// Modes decides how each file is restored
+type Modes struct {
+	def   string
+	rules []modeRule
+	// apps maps the portable paths of application files to a mode
+	apps map[string]string
+}
+
+// NewModes resolves the paths of a restore config. apps are the
+// applications recorded in the machine's manifest, with portable paths.
+// The most specific path wins when several match.
+func NewModes(cfg types.RestoreConfig, dirs xdg.Dirs, apps []types.App) (*Modes, error) {
+	if err := CheckMode(cfg.Mode); err != nil {
+		return nil, err
+	}
+	m := &Modes{def: cfg.Mode, apps: make(map[string]string)}
+	if m.def == "" {
+		m.def = ModeSymlink
+	}
+	for pattern, mode := range cfg.Paths {
+		if err := CheckMode(mode); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		if mode == "" {
+			mode = m.def
+		}
+		m.rules = append(m.rules, modeRule{pattern: filepath.Clean(dirs.Resolve(pattern)), mode: mode})
+	}
+	sort.Slice(m.rules, func(i, j int) bool { return len(m.rules[i].pattern) > len(m.rules[j].pattern) })
+
+	for name, mode := range cfg.Apps {
+		if err := CheckMode(mode); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for _, app := range apps {
+		mode := cfg.Apps[app.Name]
+		if mode == "" {
+			continue
+		}
+		for _, file := range app.ConfigFiles {
+			m.apps[file.Path] = mode
+		}
+	}
+	return m, nil
+}
+
+// Mode returns the mode for a file restored to target
+func (m *Modes) Mode(file types.DotFile, target string) string {
+	if m == nil {
+		return ModeSymlink
+	}
+	for _, rule := range m.rules {
+		if xdg.MatchPath(rule.pattern, target) {
+			return rule.mode
+		}
+	}
+	if mode, ok := m.apps[file.Path]; ok {
+		return mode
+	}
+	return m.def
+}
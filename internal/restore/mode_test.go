@@ -0,0 +1,71 @@
+package restore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+func TestModes(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	dirs, err := xdg.Load()
+	if err != nil {
+		t.Fatalf("xdg.Load() error = %v", err)
+	}
+
+	apps := []types.App{
+		{Name: "git", ConfigFiles: []types.DotFile{{Path: "~/.gitconfig"}, {Path: "$XDG_CONFIG_HOME/git/ignore"}}},
+		{Name: "tmux", ConfigFiles: []types.DotFile{{Path: "~/.tmux.conf"}}},
+	}
+	modes, err := NewModes(types.RestoreConfig{
+		Mode: ModeCopy,
+		Paths: map[string]string{
+			"~/.config":             ModeSymlink,
+			"$XDG_CONFIG_HOME/code": ModeHardlink,
+			"~/.ssh/*":              ModeRender,
+			"~/.config/git/ignore":  ModeCopy,
+		},
+		Apps: map[string]string{"git": ModeRender, "vim": ModeSymlink},
+	}, dirs, apps)
+	if err != nil {
+		t.Fatalf("NewModes() error = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "~/.bashrc", want: ModeCopy},
+		{path: "$XDG_CONFIG_HOME/nvim/init.lua", want: ModeSymlink},
+		{path: "$XDG_CONFIG_HOME/code/settings.json", want: ModeHardlink},
+		{path: "~/.ssh/config", want: ModeRender},
+		{path: "~/.ssh/config.d/work", want: ModeRender},
+		{path: "~/.gitconfig", want: ModeRender},
+		{path: "$XDG_CONFIG_HOME/git/ignore", want: ModeCopy},
+		{path: "~/.tmux.conf", want: ModeCopy},
+	}
+	for _, tt := range tests {
+		file := types.DotFile{Path: tt.path}
+		if got := modes.Mode(file, dirs.Resolve(tt.path)); got != tt.want {
+			t.Errorf("Mode(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	var none *Modes
+	if got := none.Mode(types.DotFile{Path: "~/.bashrc"}, filepath.Join(home, ".bashrc")); got != ModeSymlink {
+		t.Errorf("Default mode = %q, want %q", got, ModeSymlink)
+	}
+	for _, cfg := range []types.RestoreConfig{
+		{Mode: "move"},
+		{Paths: map[string]string{"~/.vimrc": "move"}},
+		{Apps: map[string]string{"vim": "move"}},
+	} {
+		if _, err := NewModes(cfg, dirs, apps); err == nil {
+			t.Errorf("NewModes(%+v) accepted an unknown mode", cfg)
+		}
+	}
+}
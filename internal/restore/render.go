@@ -0,0 +1,48 @@
+package restore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"text/template"
+)
+
+// TemplateData is what templates restored with ModeRender can refer to,
+// e.g. {{ .Hostname }} or {{ if eq .OS "darwin" }}
+type TemplateData struct {
+	// Hostname is this computer's name and Machine the name of the machine
+	// the files were backed up from
+	Hostname string
+	Machine  string
+	User     string
+	Home     string
+	OS       string
+	Arch     string
+}
+
+// newTemplateData describes this computer for templates
+func newTemplateData(machine, home string) TemplateData {
+	data := TemplateData{Machine: machine, Home: home, OS: runtime.GOOS, Arch: runtime.GOARCH}
+	data.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		data.User = u.Username
+	}
+	return data
+}
+
+// Render expands content as a Go template. Besides the fields of data,
+// templates can read environment variables with {{ env "NAME" }}. Unknown
+// fields are an error rather than an empty string.
+func Render(name string, content []byte, data TemplateData) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{"env": os.Getenv}).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("error rendering template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,33 @@
+package restore
+
+import (
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	t.Setenv("EDITOR", "nvim")
+	data := TemplateData{Hostname: "desk", Machine: "laptop", User: "ana", Home: "/home/ana", OS: "linux", Arch: "amd64"}
+
+	got, err := Render("~/.gitconfig", []byte(`[core]
+	editor = {{ env "EDITOR" }}
+	excludesfile = {{ .Home }}/.gitignore
+{{ if eq .OS "darwin" }}	pager = less{{ end }}# restored on {{ .Hostname }} from {{ .Machine }}
+`), data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	want := `[core]
+	editor = nvim
+	excludesfile = /home/ana/.gitignore
+# restored on desk from laptop
+`
+	if string(got) != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	for _, content := range []string{"{{ .Hostname ", "{{ .Missing }}"} {
+		if _, err := Render("bad", []byte(content), data); err == nil {
+			t.Errorf("Render(%q) succeeded", content)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package restore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/amroessam/dotback/internal/backup"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+// StoreDir is the directory under dotback's data directory that holds
+// restored files. The files in the home directory link into it.
+const StoreDir = "store"
+
+// Status is what became of a file during a restore
+type Status string
+
+const (
+	StatusRestored Status = "restored"
+	StatusSkipped  Status = "skipped"
+	StatusFailed   Status = "failed"
+)
+
+// Statuses lists every status in the order they are reported
+var Statuses = []Status{StatusRestored, StatusSkipped, StatusFailed}
+
+const (
+	// ReasonExists skips a file whose target exists with something else
+	ReasonExists = "already exists"
+	// ReasonInPlace skips a file that an earlier restore put in place
+	ReasonInPlace = "already restored"
+)
+
+// Source reads the content of backed up files, such as a backup.Remote
+type Source interface {
+	ReadFile(file types.DotFile) ([]byte, error)
+}
+
+// Outcome describes what happened to one file
+type Outcome struct {
+	// Path is the file's portable path and Target where it was restored to
+	Path   string
+	Target string
+	Status Status
+	// Mode is how the file was put in place. Source is what a symlink
+	// points to or the store file a hard link shares, and Hash is the
+	// content that was written.
+	Mode   string
+	Source string
+	Hash   string
+	// Saved is where the file that was replaced was saved to
+	Saved string
+	// Reason explains a skipped or failed file
+	Reason string
+	// Warnings lists attributes that could not be applied
+	Warnings []string
+}
+
+// Result describes a finished restore
+type Result struct {
+	Files []Outcome
+}
+
+// Count returns how many files ended with a status
+func (r *Result) Count(status Status) int {
+	count := 0
+	for _, file := range r.Files {
+		if file.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Restore downloads a machine's files and puts them in place, by default
+// as symlinks to copies in a local store
+type Restore struct {
+	Source  Source
+	FS      types.FileSystem
+	Dirs    xdg.Dirs
+	Machine string
+	// Store is the directory files are downloaded to, mirroring their
+	// paths in the backup repository
+	Store string
+	// Modes decides how each file is put in place; nil links every file
+	Modes *Modes
+	// Conflicts is the directory existing files are saved to before they
+	// are replaced, with an index of what was saved
+	Conflicts string
+	// OnConflict decides what happens to an existing file that differs
+	// from the file to restore; nil skips every such file
+	OnConflict func(conflict Conflict) (Resolution, error)
+	// State records earlier restores. A store copy that no longer has the
+	// content recorded there was edited, and is saved before it is
+	// updated. nil treats every store copy as edited.
+	State *State
+	// OnFile is called before each file is restored
+	OnFile func(done, total int, file types.DotFile)
+
+	template TemplateData
+	replaced []Replaced
+}
+
+// StorePath returns where a file of the machine is kept in the store
+func (r *Restore) StorePath(portable string) string {
+	return filepath.Join(r.Store, filepath.FromSlash(backup.RepoPath(r.Machine, portable)))
+}
+
+// Run restores the files. A file that cannot be restored is reported as
+// failed and does not stop the others. Existing files are only replaced
+// once they are saved to the conflicts directory.
+func (r *Restore) Run(files []types.DotFile) *Result {
+	r.template = newTemplateData(r.Machine, r.Dirs.Home)
+	r.replaced = nil
+	result := &Result{}
+	for i, file := range files {
+		if r.OnFile != nil {
+			r.OnFile(i, len(files), file)
+		}
+		outcome := r.restoreFile(file)
+		if outcome.Status == StatusFailed {
+			logger.Error("Failed to restore %s: %s", file.Path, outcome.Reason)
+		}
+		result.Files = append(result.Files, outcome)
+	}
+	return result
+}
+
+// restoreFile restores one file
+func (r *Restore) restoreFile(file types.DotFile) Outcome {
+	target := r.Dirs.Resolve(file.Path)
+	outcome := Outcome{Path: file.Path, Target: target, Mode: r.Modes.Mode(file, target)}
+	fail := func(err error) Outcome {
+		outcome.Status, outcome.Reason = StatusFailed, err.Error()
+		return outcome
+	}
+
+	// A preserved symlink is recreated with its target exactly as recorded,
+	// so a relative link stays relative, whatever the mode
+	if file.IsSymlink && file.LinkTarget != "" {
+		outcome.Mode, outcome.Source = ModeSymlink, file.LinkTarget
+		if r.resolveExisting(&outcome, []byte(file.LinkTarget), true) {
+			return outcome
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fail(fmt.Errorf("error creating directory: %w", err))
+		}
+		if err := os.Symlink(file.LinkTarget, target); err != nil {
+			return fail(fmt.Errorf("error creating symlink: %w", err))
+		}
+		outcome.Status = StatusRestored
+		return outcome
+	}
+
+	content, err := r.Source.ReadFile(file)
+	if err != nil {
+		return fail(err)
+	}
+	switch outcome.Mode {
+	case ModeSymlink, ModeHardlink:
+		outcome.Source = r.StorePath(file.Path)
+	case ModeRender:
+		if file.Binary {
+			outcome.Warnings = append(outcome.Warnings, fmt.Sprintf("%s: binary file copied without rendering", target))
+		} else if content, err = Render(file.Path, content, r.template); err != nil {
+			return fail(err)
+		}
+	}
+	outcome.Hash = hashContent(content)
+	if r.linked(&outcome) {
+		return r.updateStore(outcome, file, content)
+	}
+	if r.resolveExisting(&outcome, content, false) {
+		return outcome
+	}
+
+	if err := r.deploy(&outcome, file, content); err != nil {
+		return fail(err)
+	}
+	logger.Debug("Restored %s as %s", target, outcome.Mode)
+	outcome.Status = StatusRestored
+	return outcome
+}
+
+// resolveExisting deals with a file already at the outcome's target and
+// reports whether the outcome is decided. A file already in place is
+// skipped. Any other file is saved to the conflicts directory before it
+// is replaced; when its content differs, OnConflict decides whether to
+// replace it at all. content is what would be restored, or the target of
+// a preserved symlink when link is set.
+func (r *Restore) resolveExisting(outcome *Outcome, content []byte, link bool) bool {
+	info, err := os.Lstat(outcome.Target)
+	if err != nil {
+		return false
+	}
+	if r.inPlace(outcome, content, link) {
+		outcome.Status, outcome.Reason = StatusSkipped, ReasonInPlace
+		return true
+	}
+	if info.IsDir() {
+		outcome.Status, outcome.Reason = StatusFailed, "a directory is in the way"
+		return true
+	}
+
+	var existing []byte
+	if link && info.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(outcome.Target)
+		existing = []byte(target)
+	} else {
+		existing, _ = os.ReadFile(outcome.Target)
+	}
+	resolution := ResolveOverwrite
+	if !bytes.Equal(existing, content) {
+		resolution = ResolveSkip
+		if r.OnConflict != nil {
+			conflict := Conflict{Path: outcome.Path, Target: outcome.Target, Existing: existing, Restored: content}
+			if resolution, err = r.OnConflict(conflict); err != nil {
+				outcome.Status, outcome.Reason = StatusFailed, err.Error()
+				return true
+			}
+		}
+	}
+
+	switch resolution {
+	case ResolveSkip:
+		outcome.Status, outcome.Reason = StatusSkipped, ReasonExists
+		return true
+	case ResolveFail:
+		outcome.Status, outcome.Reason = StatusFailed, ReasonConflict
+		return true
+	}
+	if err := r.replaceExisting(outcome, resolution); err != nil {
+		outcome.Status, outcome.Reason = StatusFailed, err.Error()
+		return true
+	}
+	return false
+}
+
+// replaceExisting saves the file at the outcome's target and moves it out
+// of the way, recording it in the conflicts index
+func (r *Restore) replaceExisting(outcome *Outcome, resolution Resolution) error {
+	saved, err := r.saveExisting(outcome.Target, outcome.Target)
+	if err != nil {
+		return fmt.Errorf("not replacing %s, which could not be saved: %w", outcome.Target, err)
+	}
+	outcome.Saved = saved
+	replaced := Replaced{Path: outcome.Path, Target: outcome.Target, Saved: saved, Resolution: resolution}
+
+	if resolution == ResolveKeepBoth {
+		replaced.Kept = keptPath(outcome.Target)
+		if err := os.Rename(outcome.Target, replaced.Kept); err != nil {
+			return fmt.Errorf("error renaming %s: %w", outcome.Target, err)
+		}
+	} else if err := os.Remove(outcome.Target); err != nil {
+		return fmt.Errorf("error removing %s: %w", outcome.Target, err)
+	}
+	logger.Debug("Saved %s to %s before replacing it", outcome.Target, saved)
+	r.recordReplaced(outcome, replaced)
+	return nil
+}
+
+// updateStore brings the store copy of a file that is already linked into
+// place up to date with the content to restore. A store copy that was
+// edited since it was restored is saved to the conflicts directory first.
+func (r *Restore) updateStore(outcome Outcome, file types.DotFile, content []byte) Outcome {
+	stored, err := os.ReadFile(outcome.Source)
+	if err == nil && bytes.Equal(stored, content) {
+		outcome.Status, outcome.Reason = StatusSkipped, ReasonInPlace
+		return outcome
+	}
+	if err == nil && r.edited(outcome.Target, stored) {
+		saved, err := r.saveExisting(outcome.Source, outcome.Target)
+		if err != nil {
+			outcome.Status = StatusFailed
+			outcome.Reason = fmt.Sprintf("not updating %s, whose edits could not be saved: %v", outcome.Target, err)
+			return outcome
+		}
+		outcome.Saved = saved
+		logger.Debug("Saved the edited store copy of %s to %s", outcome.Target, saved)
+		r.recordReplaced(&outcome, Replaced{Path: outcome.Path, Target: outcome.Target, Saved: saved, Resolution: ResolveOverwrite})
+	}
+
+	if err := writeFile(outcome.Source, file, content); err != nil {
+		outcome.Status, outcome.Reason = StatusFailed, fmt.Sprintf("error writing %s to the store: %v", file.Path, err)
+		return outcome
+	}
+	outcome.Warnings = append(outcome.Warnings, ApplyAttributes(outcome.Source, file)...)
+	logger.Debug("Updated the store copy of %s", outcome.Target)
+	outcome.Status = StatusRestored
+	return outcome
+}
+
+// edited reports whether a store copy no longer has the content recorded
+// when the file at target was restored
+func (r *Restore) edited(target string, stored []byte) bool {
+	deployed, ok := r.State.Lookup(target)
+	return !ok || deployed.Hash != hashContent(stored)
+}
+
+// recordReplaced adds a saved file to the conflicts index
+func (r *Restore) recordReplaced(outcome *Outcome, replaced Replaced) {
+	r.replaced = append(r.replaced, replaced)
+	if err := r.writeConflictIndex(r.replaced); err != nil {
+		outcome.Warnings = append(outcome.Warnings, err.Error())
+	}
+}
+
+// deploy puts the file in place according to the outcome's mode. Symlinks
+// and hard links share a copy in the store, which gets the file's recorded
+// attributes; copies get them themselves.
+func (r *Restore) deploy(outcome *Outcome, file types.DotFile, content []byte) error {
+	if outcome.Source != "" {
+		if err := writeFile(outcome.Source, file, content); err != nil {
+			return fmt.Errorf("error writing %s to the store: %w", file.Path, err)
+		}
+		outcome.Warnings = append(outcome.Warnings, ApplyAttributes(outcome.Source, file)...)
+	}
+
+	switch outcome.Mode {
+	case ModeSymlink:
+		return r.FS.CreateSymlink(outcome.Source, outcome.Target)
+	case ModeHardlink:
+		if err := os.MkdirAll(filepath.Dir(outcome.Target), 0755); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+		if err := os.Link(outcome.Source, outcome.Target); err != nil {
+			return fmt.Errorf("error creating hard link: %w", err)
+		}
+		return nil
+	default:
+		if err := writeFile(outcome.Target, file, content); err != nil {
+			return err
+		}
+		outcome.Warnings = append(outcome.Warnings, ApplyAttributes(outcome.Target, file)...)
+		return nil
+	}
+}
+
+// linked reports whether the target of a symlink or hard link outcome
+// already links to its store copy, whatever the copy's content
+func (r *Restore) linked(outcome *Outcome) bool {
+	switch outcome.Mode {
+	case ModeSymlink:
+		if !r.FS.IsSymlink(outcome.Target) {
+			return false
+		}
+		target, err := os.Readlink(outcome.Target)
+		return err == nil && filepath.Clean(target) == filepath.Clean(outcome.Source)
+	case ModeHardlink:
+		info, err := os.Lstat(outcome.Target)
+		if err != nil {
+			return false
+		}
+		source, err := os.Lstat(outcome.Source)
+		return err == nil && os.SameFile(info, source)
+	}
+	return false
+}
+
+// inPlace reports whether the existing target is what a copy, a rendered
+// file or, when link is set, a preserved symlink would put there. Links
+// into the store are handled by updateStore.
+func (r *Restore) inPlace(outcome *Outcome, content []byte, link bool) bool {
+	if link {
+		target, err := os.Readlink(outcome.Target)
+		return err == nil && filepath.Clean(target) == filepath.Clean(string(content))
+	}
+	switch outcome.Mode {
+	case ModeSymlink, ModeHardlink:
+		return false
+	}
+	existing, err := os.ReadFile(outcome.Target)
+	return err == nil && !r.FS.IsSymlink(outcome.Target) && bytes.Equal(existing, content)
+}
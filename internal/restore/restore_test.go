@@ -0,0 +1,196 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+	"github.com/amroessam/dotback/internal/scan"
+)
+
+// mapSource serves backed up content from a map keyed by portable path
+type mapSource map[string]string
+
+func (s mapSource) ReadFile(file types.DotFile) ([]byte, error) {
+	content, ok := s[file.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", file.Path, types.ErrNotFound)
+	}
+	return []byte(content), nil
+}
+
+// newTestRestore returns a restore of machine "laptop" into a temporary
+// home directory
+func newTestRestore(t *testing.T, source Source) (string, *Restore) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME"} {
+		t.Setenv(name, "")
+	}
+	dirs, err := xdg.Load()
+	if err != nil {
+		t.Fatalf("xdg.Load() error = %v", err)
+	}
+	fs, err := scan.NewFileSystem(scan.Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	return home, &Restore{
+		Source:  source,
+		FS:      fs,
+		Dirs:    dirs,
+		Machine: "laptop",
+		Store:   filepath.Join(home, ".local/share/dotback", StoreDir),
+	}
+}
+
+func TestRestoreRun(t *testing.T) {
+	home, r := newTestRestore(t, mapSource{
+		"~/.bashrc":                      "alias ll='ls -l'\n",
+		"$XDG_CONFIG_HOME/nvim/init.lua": "vim.opt.number = true\n",
+		"~/.zshrc":                       "setopt autocd\n",
+	})
+	if err := os.WriteFile(filepath.Join(home, ".zshrc"), []byte("# mine\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	var progress []string
+	r.OnFile = func(done, total int, file types.DotFile) { progress = append(progress, file.Path) }
+
+	files := []types.DotFile{
+		{Path: "~/.bashrc", Mode: "0600"},
+		{Path: "$XDG_CONFIG_HOME/nvim/init.lua"},
+		{Path: "~/.zshrc"},
+		{Path: "~/.missing"},
+		{Path: "~/.vimrc", IsSymlink: true, LinkTarget: "src/vimrc"},
+	}
+	result := r.Run(files)
+	if len(progress) != len(files) {
+		t.Errorf("OnFile called %d times", len(progress))
+	}
+	if result.Count(StatusRestored) != 3 || result.Count(StatusSkipped) != 1 || result.Count(StatusFailed) != 1 {
+		t.Errorf("Run() = %+v", result.Files)
+	}
+
+	bashrc := filepath.Join(home, ".bashrc")
+	target, err := os.Readlink(bashrc)
+	if err != nil || target != r.StorePath("~/.bashrc") {
+		t.Errorf("~/.bashrc links to %q, %v", target, err)
+	}
+	if content, _ := os.ReadFile(bashrc); string(content) != "alias ll='ls -l'\n" {
+		t.Errorf("~/.bashrc = %q", content)
+	}
+	if info, err := os.Stat(r.StorePath("~/.bashrc")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Stored .bashrc mode = %v, %v", info, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".config/nvim/init.lua")); string(content) != "vim.opt.number = true\n" {
+		t.Errorf("init.lua = %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".zshrc")); string(content) != "# mine\n" {
+		t.Errorf("Existing ~/.zshrc was overwritten: %q", content)
+	}
+	if target, _ := os.Readlink(filepath.Join(home, ".vimrc")); target != "src/vimrc" {
+		t.Errorf("~/.vimrc links to %q, want the recorded relative target", target)
+	}
+
+	// Restoring again finds everything in place
+	result = r.Run(append(files[:2:2], files[4]))
+	for _, outcome := range result.Files {
+		if outcome.Status != StatusSkipped || outcome.Reason != ReasonInPlace {
+			t.Errorf("Second run = %+v", outcome)
+		}
+	}
+}
+
+func TestRestoreModes(t *testing.T) {
+	home, r := newTestRestore(t, mapSource{
+		"~/.bashrc":    "alias ll='ls -l'\n",
+		"~/.gitconfig": "[user]\n\tname = {{ .User }}\n# {{ .Machine }}\n",
+		"~/.zshrc":     "setopt autocd\n",
+		"~/.tmux.conf": "set -g mouse on\n",
+		"~/.logo.png":  "{{ not a template",
+		"~/.bad":       "{{ .Missing }}",
+	})
+	r.Modes = &Modes{def: ModeSymlink, apps: map[string]string{
+		"~/.zshrc":     ModeCopy,
+		"~/.tmux.conf": ModeHardlink,
+		"~/.gitconfig": ModeRender,
+		"~/.logo.png":  ModeRender,
+		"~/.bad":       ModeRender,
+	}}
+	files := []types.DotFile{
+		{Path: "~/.bashrc"},
+		{Path: "~/.gitconfig"},
+		{Path: "~/.zshrc", Mode: "0600"},
+		{Path: "~/.tmux.conf"},
+		{Path: "~/.logo.png", Binary: true},
+		{Path: "~/.bad"},
+	}
+	result := r.Run(files)
+	if result.Count(StatusRestored) != 5 || result.Count(StatusFailed) != 1 {
+		t.Fatalf("Run() = %+v", result.Files)
+	}
+
+	zshrc := filepath.Join(home, ".zshrc")
+	if info, err := os.Lstat(zshrc); err != nil || !info.Mode().IsRegular() || info.Mode().Perm() != 0600 {
+		t.Errorf("Copied .zshrc = %v, %v", info, err)
+	}
+	tmux, _ := os.Stat(filepath.Join(home, ".tmux.conf"))
+	stored, _ := os.Stat(r.StorePath("~/.tmux.conf"))
+	if tmux == nil || stored == nil || !os.SameFile(tmux, stored) {
+		t.Errorf(".tmux.conf is not a hard link to the store")
+	}
+	gitconfig, _ := os.ReadFile(filepath.Join(home, ".gitconfig"))
+	if !strings.Contains(string(gitconfig), "# laptop\n") || strings.Contains(string(gitconfig), "{{") {
+		t.Errorf("Rendered .gitconfig = %q", gitconfig)
+	}
+	if logo, _ := os.ReadFile(filepath.Join(home, ".logo.png")); string(logo) != "{{ not a template" {
+		t.Errorf("Binary file was rendered: %q", logo)
+	}
+	if outcome := result.Files[5]; outcome.Status != StatusFailed || !strings.Contains(outcome.Reason, "Missing") {
+		t.Errorf(".bad = %+v", outcome)
+	}
+
+	// Restoring again finds every file in place
+	r.State = &State{}
+	r.State.Record(result, "dotfiles", "laptop", time.Now())
+	if rendered := r.State.Rendered(); len(rendered) != 2 || !rendered[filepath.Join(home, ".gitconfig")] {
+		t.Errorf("Rendered() = %v", rendered)
+	}
+	result = r.Run(files[:5])
+	for _, outcome := range result.Files {
+		if outcome.Status != StatusSkipped || outcome.Reason != ReasonInPlace {
+			t.Errorf("Second run = %+v", outcome)
+		}
+	}
+
+	// Newer content updates the store copies behind links. A copy edited
+	// through its link is saved first; one that was not is just updated.
+	r.Conflicts = filepath.Join(home, ".local/state/dotback", ConflictsDir, "20240501T120000Z")
+	r.Source = mapSource{"~/.bashrc": "alias ll='ls -la'\n", "~/.tmux.conf": "set -g mouse off\n"}
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte("# edited\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	result = r.Run([]types.DotFile{files[0], files[3]})
+	if result.Count(StatusRestored) != 2 {
+		t.Fatalf("Third run = %+v", result.Files)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".bashrc")); string(content) != "alias ll='ls -la'\n" {
+		t.Errorf("~/.bashrc = %q", content)
+	}
+	if saved, _ := os.ReadFile(result.Files[0].Saved); string(saved) != "# edited\n" {
+		t.Errorf("Saved store copy of .bashrc = %q", saved)
+	}
+	if result.Files[1].Saved != "" {
+		t.Errorf("Unedited .tmux.conf was saved to %s", result.Files[1].Saved)
+	}
+	tmux, _ = os.Stat(filepath.Join(home, ".tmux.conf"))
+	stored, _ = os.Stat(r.StorePath("~/.tmux.conf"))
+	if content, _ := os.ReadFile(filepath.Join(home, ".tmux.conf")); string(content) != "set -g mouse off\n" || !os.SameFile(tmux, stored) {
+		t.Errorf(".tmux.conf = %q, still a hard link = %v", content, os.SameFile(tmux, stored))
+	}
+}
@@ -0,0 +1,109 @@
+package restore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+// Selection narrows a restore to some of a machine's files. Include and
+// Exclude are paths or glob patterns, such as "~/.config/nvim" or
+// "~/.ssh/*", that match a path and everything below it. Apps names
+// applications recorded in the machine's manifest. A file is selected when
+// it matches Include or belongs to one of Apps, or when both are empty,
+// and does not match Exclude.
+type Selection struct {
+	Include []string
+	Exclude []string
+	Apps    []string
+}
+
+// Empty reports whether the selection keeps every file
+func (s Selection) Empty() bool {
+	return len(s.Include) == 0 && len(s.Exclude) == 0 && len(s.Apps) == 0
+}
+
+// Filter returns the selected files. apps are the applications in the
+// machine's manifest, with portable paths; naming one that is not among
+// them is an error.
+func (s Selection) Filter(files []types.DotFile, apps []types.App, dirs xdg.Dirs) ([]types.DotFile, error) {
+	for _, pattern := range append(append([]string{}, s.Include...), s.Exclude...) {
+		if _, err := filepath.Match(dirs.Resolve(pattern), ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	inApps := make(map[string]bool)
+	for _, name := range s.Apps {
+		found := false
+		for _, app := range apps {
+			if strings.EqualFold(app.Name, name) {
+				found = true
+				for _, file := range app.ConfigFiles {
+					inApps[file.Path] = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no application %q in the backup, expected one of %s", name, strings.Join(appNames(apps), ", "))
+		}
+	}
+
+	var selected []types.DotFile
+	for _, file := range files {
+		target := dirs.Resolve(file.Path)
+		keep := len(s.Include) == 0 && len(s.Apps) == 0
+		keep = keep || inApps[file.Path] || matchAny(s.Include, target, dirs)
+		if keep && !matchAny(s.Exclude, target, dirs) {
+			selected = append(selected, file)
+		}
+	}
+	return selected, nil
+}
+
+// appNames returns the sorted names of the applications
+func appNames(apps []types.App) []string {
+	var names []string
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchAny reports whether any of the patterns matches the absolute path
+func matchAny(patterns []string, path string, dirs xdg.Dirs) bool {
+	for _, pattern := range patterns {
+		if xdg.MatchPath(filepath.Clean(dirs.Resolve(pattern)), path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLabels parses label selectors written as "key=value"
+func ParseLabels(selectors []string) (map[string]string, error) {
+	labels := make(map[string]string, len(selectors))
+	for _, selector := range selectors {
+		key, value, ok := strings.Cut(selector, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", selector)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// MatchLabels reports whether a machine has every wanted label
+func MatchLabels(machine types.Machine, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if got, ok := machine.Labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
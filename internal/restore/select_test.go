@@ -0,0 +1,88 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+func TestSelectionFilter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	dirs, err := xdg.Load()
+	if err != nil {
+		t.Fatalf("xdg.Load() error = %v", err)
+	}
+
+	files := []types.DotFile{
+		{Path: "~/.bashrc"},
+		{Path: "~/.tmux.conf"},
+		{Path: "$XDG_CONFIG_HOME/nvim/init.lua"},
+		{Path: "$XDG_CONFIG_HOME/nvim/lua/plugins.lua"},
+		{Path: "~/.ssh/config"},
+	}
+	apps := []types.App{
+		{Name: "nvim", ConfigFiles: []types.DotFile{{Path: "$XDG_CONFIG_HOME/nvim/init.lua"}, {Path: "$XDG_CONFIG_HOME/nvim/lua/plugins.lua"}}},
+		{Name: "tmux", ConfigFiles: []types.DotFile{{Path: "~/.tmux.conf"}}},
+	}
+
+	tests := []struct {
+		name      string
+		selection Selection
+		want      []string
+		wantErr   bool
+	}{
+		{name: "Everything", want: []string{"~/.bashrc", "~/.tmux.conf", "$XDG_CONFIG_HOME/nvim/init.lua", "$XDG_CONFIG_HOME/nvim/lua/plugins.lua", "~/.ssh/config"}},
+		{name: "Apps", selection: Selection{Apps: []string{"NVIM", "tmux"}}, want: []string{"~/.tmux.conf", "$XDG_CONFIG_HOME/nvim/init.lua", "$XDG_CONFIG_HOME/nvim/lua/plugins.lua"}},
+		{name: "Include directory", selection: Selection{Include: []string{"~/.config/nvim"}}, want: []string{"$XDG_CONFIG_HOME/nvim/init.lua", "$XDG_CONFIG_HOME/nvim/lua/plugins.lua"}},
+		{name: "Include directory glob", selection: Selection{Include: []string{"~/.config/n*"}}, want: []string{"$XDG_CONFIG_HOME/nvim/init.lua", "$XDG_CONFIG_HOME/nvim/lua/plugins.lua"}},
+		{name: "Include glob and app", selection: Selection{Include: []string{"~/.ssh/*"}, Apps: []string{"tmux"}}, want: []string{"~/.tmux.conf", "~/.ssh/config"}},
+		{name: "Exclude", selection: Selection{Apps: []string{"nvim"}, Exclude: []string{"$XDG_CONFIG_HOME/nvim/lua"}}, want: []string{"$XDG_CONFIG_HOME/nvim/init.lua"}},
+		{name: "Exclude only", selection: Selection{Exclude: []string{"~/.*rc", "~/.config"}}, want: []string{"~/.tmux.conf", "~/.ssh/config"}},
+		{name: "Unknown app", selection: Selection{Apps: []string{"emacs"}}, wantErr: true},
+		{name: "Invalid pattern", selection: Selection{Include: []string{"~/[.bashrc"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := tt.selection.Filter(files, apps, dirs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, file := range selected {
+				got = append(got, file.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	wanted, err := ParseLabels([]string{"role=server", " os = linux "})
+	if err != nil {
+		t.Fatalf("ParseLabels() error = %v", err)
+	}
+	if !reflect.DeepEqual(wanted, map[string]string{"role": "server", "os": "linux"}) {
+		t.Errorf("ParseLabels() = %v", wanted)
+	}
+	for _, selector := range []string{"role", "=server"} {
+		if _, err := ParseLabels([]string{selector}); err == nil {
+			t.Errorf("ParseLabels(%q) succeeded", selector)
+		}
+	}
+
+	server := types.Machine{Labels: map[string]string{"role": "server", "os": "linux", "site": "fra"}}
+	if !MatchLabels(server, wanted) {
+		t.Error("MatchLabels() = false for a machine with every label")
+	}
+	if MatchLabels(types.Machine{Labels: map[string]string{"role": "desktop", "os": "linux"}}, wanted) || MatchLabels(types.Machine{}, wanted) {
+		t.Error("MatchLabels() = true for a machine without the labels")
+	}
+	if !MatchLabels(types.Machine{}, nil) {
+		t.Error("MatchLabels() = false without wanted labels")
+	}
+}
@@ -0,0 +1,189 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/logger"
+)
+
+const (
+	// StateFileName is the name of the record of restored files inside
+	// dotback's state directory
+	StateFileName = "restored.json"
+
+	// stateVersion is bumped whenever the recorded fields change meaning.
+	// Records written by other versions are discarded.
+	stateVersion = 1
+)
+
+// Deployed records how one file was put in place, so that a later check
+// knows what to expect on disk
+type Deployed struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	Mode   string `json:"mode"`
+	// Source is what a symlink points to or the store file a hard link
+	// shares. Hash is the content written, for every mode but symlinks to
+	// recorded link targets.
+	Source   string    `json:"source,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	Repo     string    `json:"repo"`
+	Machine  string    `json:"machine"`
+	Restored time.Time `json:"restored"`
+}
+
+// State records every file restored on this computer, by target path
+type State struct {
+	Version int        `json:"version"`
+	Files   []Deployed `json:"files"`
+}
+
+// LoadState reads the record at path. A missing, unreadable or outdated
+// record is not an error; it starts out empty.
+func LoadState(path string) *State {
+	state := &State{Version: stateVersion}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Debug("Ignoring restore state: %v", err)
+		}
+		return state
+	}
+	var stored State
+	if err := json.Unmarshal(data, &stored); err != nil {
+		logger.Debug("Ignoring corrupt restore state: %v", err)
+		return state
+	}
+	if stored.Version != stateVersion {
+		logger.Debug("Ignoring restore state version %d", stored.Version)
+		return state
+	}
+	return &stored
+}
+
+// Record adds the files that a restore put in place, or found already in
+// place, replacing what was recorded for the same targets
+func (s *State) Record(result *Result, repo, machine string, at time.Time) {
+	byTarget := make(map[string]Deployed, len(s.Files))
+	for _, file := range s.Files {
+		byTarget[file.Target] = file
+	}
+	for _, outcome := range result.Files {
+		if outcome.Status != StatusRestored && outcome.Reason != ReasonInPlace {
+			continue
+		}
+		byTarget[outcome.Target] = Deployed{
+			Path:     outcome.Path,
+			Target:   outcome.Target,
+			Mode:     outcome.Mode,
+			Source:   outcome.Source,
+			Hash:     outcome.Hash,
+			Repo:     repo,
+			Machine:  machine,
+			Restored: at,
+		}
+	}
+
+	s.Files = s.Files[:0]
+	for _, file := range byTarget {
+		s.Files = append(s.Files, file)
+	}
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].Target < s.Files[j].Target })
+}
+
+// Lookup returns what was recorded for the file restored to target. A nil
+// record has nothing recorded.
+func (s *State) Lookup(target string) (Deployed, bool) {
+	if s == nil {
+		return Deployed{}, false
+	}
+	for _, file := range s.Files {
+		if file.Target == target {
+			return file, true
+		}
+	}
+	return Deployed{}, false
+}
+
+// Rendered returns the targets of the files that were restored by
+// rendering a template. Backing them up would replace the template in the
+// backup with its output.
+func (s *State) Rendered() map[string]bool {
+	rendered := make(map[string]bool)
+	for _, file := range s.Files {
+		if file.Mode == ModeRender {
+			rendered[file.Target] = true
+		}
+	}
+	return rendered
+}
+
+// Save writes the record to path
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding restore state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing restore state: %w", err)
+	}
+	return nil
+}
+
+// Check returns an error describing how the file on disk differs from what
+// was restored, or nil if it is as expected. A symlink must still point to
+// its source and a hard link must still share its store file, while edits
+// through either are expected. Copies and rendered files must still have
+// the content that was written.
+func (d Deployed) Check() error {
+	info, err := os.Lstat(d.Target)
+	if err != nil {
+		return fmt.Errorf("%s is missing", d.Target)
+	}
+
+	switch d.Mode {
+	case ModeSymlink:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is no longer a symlink", d.Target)
+		}
+		if target, err := os.Readlink(d.Target); err != nil || filepath.Clean(target) != filepath.Clean(d.Source) {
+			return fmt.Errorf("%s no longer links to %s", d.Target, d.Source)
+		}
+		return nil
+	case ModeHardlink:
+		source, err := os.Stat(d.Source)
+		if err != nil || !os.SameFile(info, source) {
+			return fmt.Errorf("%s is no longer a hard link to %s", d.Target, d.Source)
+		}
+		return nil
+	default:
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is no longer a regular file", d.Target)
+		}
+	}
+
+	content, err := os.ReadFile(d.Target)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", d.Target, err)
+	}
+	if d.Hash != "" && hashContent(content) != d.Hash {
+		return fmt.Errorf("%s was modified", d.Target)
+	}
+	return nil
+}
+
+// hashContent returns the hex encoded SHA-256 of content
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
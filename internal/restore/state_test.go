@@ -0,0 +1,85 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestStateRecordAndCheck(t *testing.T) {
+	home, r := newTestRestore(t, mapSource{
+		"~/.bashrc":    "alias ll='ls -l'\n",
+		"~/.gitconfig": "[user]\n",
+		"~/.zshrc":     "setopt autocd\n",
+	})
+	r.Modes = &Modes{def: ModeSymlink, apps: map[string]string{"~/.gitconfig": ModeCopy, "~/.zshrc": ModeHardlink}}
+	result := r.Run([]types.DotFile{{Path: "~/.bashrc"}, {Path: "~/.gitconfig"}, {Path: "~/.zshrc"}, {Path: "~/.missing"}})
+
+	path := filepath.Join(home, ".local/state/dotback", StateFileName)
+	state := LoadState(path)
+	state.Record(result, "dotfiles", "laptop", time.Now())
+	if err := state.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	state = LoadState(path)
+	if len(state.Files) != 3 {
+		t.Fatalf("Recorded %+v", state.Files)
+	}
+	if deployed, ok := state.Lookup(filepath.Join(home, ".zshrc")); !ok || deployed.Mode != ModeHardlink {
+		t.Errorf("Lookup(.zshrc) = %+v, %v", deployed, ok)
+	}
+	if _, ok := (*State)(nil).Lookup(filepath.Join(home, ".zshrc")); ok {
+		t.Error("Lookup() on a nil state found a file")
+	}
+	for _, file := range state.Files {
+		if err := file.Check(); err != nil {
+			t.Errorf("Check(%s) error = %v", file.Path, err)
+		}
+	}
+
+	// Editing through a link is expected; replacing the link or editing a
+	// copy is not
+	byPath := map[string]Deployed{}
+	for _, file := range state.Files {
+		byPath[file.Path] = file
+	}
+	if err := os.WriteFile(filepath.Join(home, ".zshrc"), []byte("setopt nomatch\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := byPath["~/.zshrc"].Check(); err != nil {
+		t.Errorf("Check(.zshrc) after an edit error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[core]\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := byPath["~/.gitconfig"].Check(); err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Errorf("Check(.gitconfig) error = %v, want modified", err)
+	}
+	bashrc := filepath.Join(home, ".bashrc")
+	if err := os.Remove(bashrc); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(bashrc, []byte("alias ll='ls -l'\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := byPath["~/.bashrc"].Check(); err == nil || !strings.Contains(err.Error(), "no longer a symlink") {
+		t.Errorf("Check(.bashrc) error = %v, want no longer a symlink", err)
+	}
+
+	// A later restore replaces the entries for the same targets
+	state.Record(&Result{Files: []Outcome{{Path: "~/.bashrc", Target: bashrc, Status: StatusRestored, Mode: ModeCopy}}}, "dotfiles", "desktop", time.Now())
+	if len(state.Files) != 3 || state.Files[0].Machine != "desktop" || state.Files[0].Mode != ModeCopy {
+		t.Errorf("Record() = %+v", state.Files)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if state := LoadState(path); len(state.Files) != 0 {
+		t.Errorf("LoadState() of a corrupt record = %+v", state)
+	}
+}
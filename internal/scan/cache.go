@@ -0,0 +1,190 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/logger"
+)
+
+const (
+	// CacheFileName is the name of the hash cache inside dotback's directory
+	CacheFileName = "hash-cache.json"
+
+	// cacheVersion is bumped whenever the cached fields or the way they are
+	// computed change. Caches written by other versions are discarded.
+	cacheVersion = 1
+
+	// cacheMaxAge drops entries for files that have not been seen for a while
+	cacheMaxAge = 30 * 24 * time.Hour
+
+	// racyWindow is how recently a file may have been modified and still be
+	// cached. A file written within the file system's timestamp granularity
+	// of being hashed could change again without its mtime moving.
+	racyWindow = 2 * time.Second
+)
+
+// cacheEntry is what is remembered about a file's content
+type cacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime int64     `json:"mtime"`
+	Hash    string    `json:"hash"`
+	Binary  bool      `json:"binary,omitempty"`
+	SQLite  bool      `json:"sqlite,omitempty"`
+	Secrets []string  `json:"secrets,omitempty"`
+	Used    time.Time `json:"used"`
+}
+
+type cacheFile struct {
+	Version int                    `json:"version"`
+	Entries map[string]*cacheEntry `json:"entries"`
+}
+
+// Cache remembers file hashes between scans. Entries are keyed by device
+// and inode, where the platform provides them, and are only reused while
+// the file's size and modification time are unchanged.
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	dirty   bool
+	hits    int
+	misses  int
+}
+
+// OpenCache loads the cache stored at path. A missing, unreadable or
+// outdated cache is not an error; it starts out empty.
+func OpenCache(path string) *Cache {
+	c := &Cache{path: path, entries: make(map[string]*cacheEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Debug("Ignoring hash cache: %v", err)
+		}
+		return c
+	}
+	var stored cacheFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		logger.Debug("Ignoring corrupt hash cache: %v", err)
+		return c
+	}
+	if stored.Version != cacheVersion {
+		logger.Debug("Ignoring hash cache version %d", stored.Version)
+		return c
+	}
+	for key, entry := range stored.Entries {
+		if entry != nil {
+			c.entries[key] = entry
+		}
+	}
+	return c
+}
+
+// lookup returns the cached entry for the file if it is still valid
+func (c *Cache) lookup(path string, info os.FileInfo) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[cacheKey(path, info)]
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		c.misses++
+		return cacheEntry{}, false
+	}
+	c.hits++
+	entry.Used = time.Now()
+	c.dirty = true
+	return *entry, true
+}
+
+// store records the entry for the file, unless the file was modified too
+// recently for its mtime to be trusted
+func (c *Cache) store(path string, info os.FileInfo, entry cacheEntry) {
+	if c == nil || time.Since(info.ModTime()) < racyWindow {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.Size = info.Size()
+	entry.ModTime = info.ModTime().UnixNano()
+	entry.Used = time.Now()
+	c.entries[cacheKey(path, info)] = &entry
+	c.dirty = true
+}
+
+// Stats returns how many lookups were answered from the cache and how many
+// files had to be hashed
+func (c *Cache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Save writes the cache back to disk if it changed, dropping entries that
+// have not been used recently
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	for key, entry := range c.entries {
+		if time.Since(entry.Used) > cacheMaxAge {
+			delete(c.entries, key)
+		}
+	}
+
+	data, err := json.Marshal(cacheFile{Version: cacheVersion, Entries: c.entries})
+	if err != nil {
+		return fmt.Errorf("error encoding hash cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial cache
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), CacheFileName+".*")
+	if err != nil {
+		return fmt.Errorf("error writing hash cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing hash cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing hash cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("error writing hash cache: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// ClearCache deletes the cache stored at path
+func ClearCache(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing hash cache: %w", err)
+	}
+	return nil
+}
+
+// cacheKey identifies a file by device and inode, falling back to its path
+// on platforms without inodes
+func cacheKey(path string, info os.FileInfo) string {
+	if dev, ino, ok := fileID(info); ok {
+		return fmt.Sprintf("%d:%d", dev, ino)
+	}
+	return path
+}
@@ -0,0 +1,115 @@
+package scan
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// AppSpec describes where a known application keeps its configuration
+type AppSpec struct {
+	Name string
+	// Paths are config files or directories, either under the home
+	// directory ("~/.vimrc") or under an XDG base directory
+	// ("$XDG_CONFIG_HOME/nvim")
+	Paths []string
+	// VersionCommand prints the installed version, e.g. {"git", "--version"}
+	VersionCommand []string
+}
+
+// Catalog is the built-in list of applications recognized by the scanner
+var Catalog = []AppSpec{
+	{Name: "git", Paths: []string{"~/.gitconfig", "~/.gitignore_global", "~/.gitmessage", "$XDG_CONFIG_HOME/git"}, VersionCommand: []string{"git", "--version"}},
+	{Name: "bash", Paths: []string{"~/.bashrc", "~/.bash_profile", "~/.bash_login", "~/.bash_logout", "~/.bash_aliases", "~/.profile", "~/.inputrc"}, VersionCommand: []string{"bash", "--version"}},
+	{Name: "zsh", Paths: []string{"~/.zshrc", "~/.zshenv", "~/.zprofile", "~/.zlogin", "~/.zlogout", "~/.p10k.zsh"}, VersionCommand: []string{"zsh", "--version"}},
+	{Name: "fish", Paths: []string{"$XDG_CONFIG_HOME/fish"}, VersionCommand: []string{"fish", "--version"}},
+	{Name: "vim", Paths: []string{"~/.vimrc", "~/.gvimrc", "~/.vim"}, VersionCommand: []string{"vim", "--version"}},
+	{Name: "nvim", Paths: []string{"$XDG_CONFIG_HOME/nvim"}, VersionCommand: []string{"nvim", "--version"}},
+	{Name: "tmux", Paths: []string{"~/.tmux.conf", "$XDG_CONFIG_HOME/tmux"}, VersionCommand: []string{"tmux", "-V"}},
+	{Name: "vscode", Paths: []string{
+		"$XDG_CONFIG_HOME/Code/User/settings.json",
+		"$XDG_CONFIG_HOME/Code/User/keybindings.json",
+		"$XDG_CONFIG_HOME/Code/User/snippets",
+		"~/Library/Application Support/Code/User/settings.json",
+		"~/Library/Application Support/Code/User/keybindings.json",
+		"~/Library/Application Support/Code/User/snippets",
+	}, VersionCommand: []string{"code", "--version"}},
+	{Name: "alacritty", Paths: []string{"~/.alacritty.yml", "~/.alacritty.toml", "$XDG_CONFIG_HOME/alacritty"}, VersionCommand: []string{"alacritty", "--version"}},
+	{Name: "kitty", Paths: []string{"$XDG_CONFIG_HOME/kitty"}, VersionCommand: []string{"kitty", "--version"}},
+	{Name: "wezterm", Paths: []string{"~/.wezterm.lua", "$XDG_CONFIG_HOME/wezterm"}, VersionCommand: []string{"wezterm", "--version"}},
+	{Name: "starship", Paths: []string{"$XDG_CONFIG_HOME/starship.toml"}, VersionCommand: []string{"starship", "--version"}},
+	{Name: "ssh", Paths: []string{"~/.ssh/config"}, VersionCommand: []string{"ssh", "-V"}},
+	{Name: "gnupg", Paths: []string{"~/.gnupg/gpg.conf", "~/.gnupg/gpg-agent.conf", "~/.gnupg/dirmngr.conf"}, VersionCommand: []string{"gpg", "--version"}},
+	{Name: "htop", Paths: []string{"$XDG_CONFIG_HOME/htop", "~/.htoprc"}, VersionCommand: []string{"htop", "--version"}},
+	{Name: "npm", Paths: []string{"~/.npmrc"}, VersionCommand: []string{"npm", "--version"}},
+	{Name: "curl", Paths: []string{"~/.curlrc"}, VersionCommand: []string{"curl", "--version"}},
+}
+
+// versionTimeout bounds how long a version command may run
+const versionTimeout = 3 * time.Second
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+[0-9A-Za-z.+-]*`)
+
+// lookupApp returns the catalog entry with the given name
+func lookupApp(name string) (AppSpec, bool) {
+	for _, spec := range Catalog {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return AppSpec{}, false
+}
+
+// runCommand runs an external command and returns its combined output.
+// Some tools, such as ssh, print their version on stderr.
+func runCommand(name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// parseVersion extracts the first version-looking token from command output
+func parseVersion(output []byte) string {
+	return string(versionPattern.Find(output))
+}
+
+// GroupFiles assigns each file to the first app whose config files contain
+// it. Apps are trimmed to the given files and dropped when none remain;
+// files that belong to no app are returned separately.
+func GroupFiles(files []types.DotFile, apps []types.App) ([]types.App, []types.DotFile) {
+	wanted := make(map[string]bool, len(files))
+	for _, file := range files {
+		wanted[file.Path] = true
+	}
+
+	claimed := make(map[string]bool)
+	var grouped []types.App
+	for _, app := range apps {
+		var configFiles []types.DotFile
+		for _, file := range app.ConfigFiles {
+			if wanted[file.Path] && !claimed[file.Path] {
+				claimed[file.Path] = true
+				configFiles = append(configFiles, file)
+			}
+		}
+		if len(configFiles) == 0 {
+			continue
+		}
+		app.ConfigFiles = configFiles
+		grouped = append(grouped, app)
+	}
+
+	var other []types.DotFile
+	for _, file := range files {
+		if !claimed[file.Path] {
+			other = append(other, file)
+		}
+	}
+	return grouped, other
+}
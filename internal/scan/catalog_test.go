@@ -0,0 +1,142 @@
+package scan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{output: "git version 2.43.0\n", want: "2.43.0"},
+		{output: "tmux 3.3a\n", want: "3.3a"},
+		{output: "NVIM v0.9.5\nBuild type: Release\n", want: "0.9.5"},
+		{output: "OpenSSH_9.6p1, LibreSSL 3.3.6\n", want: "9.6p1"},
+		{output: "no version here", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			if got := parseVersion([]byte(tt.output)); got != tt.want {
+				t.Errorf("parseVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAppConfigs(t *testing.T) {
+	home := setupHome(t)
+
+	fs, err := NewFileSystem(Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	fs.runCommand = func(name string, args ...string) ([]byte, error) {
+		if name == "git" {
+			return []byte("git version 2.43.0\n"), nil
+		}
+		return nil, fmt.Errorf("%s not installed", name)
+	}
+
+	apps, err := fs.FindAppConfigs(nil)
+	if err != nil {
+		t.Fatalf("FindAppConfigs() error = %v", err)
+	}
+
+	byName := make(map[string]types.App)
+	for _, app := range apps {
+		byName[app.Name] = app
+	}
+
+	git, ok := byName["git"]
+	if !ok {
+		t.Fatalf("Expected git in %v", apps)
+	}
+	if git.Version != "2.43.0" {
+		t.Errorf("git.Version = %q, want 2.43.0", git.Version)
+	}
+	if len(git.ConfigFiles) != 1 || git.ConfigFiles[0].Path != filepath.Join(home, ".config/git/config") {
+		t.Errorf("git.ConfigFiles = %v", git.ConfigFiles)
+	}
+
+	nvim, ok := byName["nvim"]
+	if !ok {
+		t.Fatalf("Expected nvim in %v", apps)
+	}
+	if nvim.Version != "" {
+		t.Errorf("nvim.Version = %q, want empty when not installed", nvim.Version)
+	}
+
+	if _, ok := byName["tmux"]; ok {
+		t.Error("tmux has no config files and should not be reported")
+	}
+
+	// Apps outside the catalog use the conventional locations
+	apps, err = fs.FindAppConfigs([]string{"local"})
+	if err != nil {
+		t.Fatalf("FindAppConfigs() error = %v", err)
+	}
+	if len(apps) != 1 || apps[0].Name != "local" || len(apps[0].ConfigFiles) != 1 {
+		t.Errorf("FindAppConfigs([local]) = %v", apps)
+	}
+}
+
+func TestFindAppConfigsQuiet(t *testing.T) {
+	home := setupHome(t)
+	if err := os.WriteFile(filepath.Join(home, ".config/nvim/big.lua"), []byte(strings.Repeat("x", 2048)), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Skips under app directories are reported by the scan alone
+	var skipped []Skip
+	var progress int
+	fs, err := NewFileSystem(Options{
+		MaxFileSize: 1024,
+		OnSkip:      func(skip Skip) { skipped = append(skipped, skip) },
+		OnProgress:  func(Progress) { progress++ },
+	})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	if _, err := fs.FindDotFiles(nil); err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	scanSkipped, scanProgress := len(skipped), progress
+	if _, err := fs.FindAppConfigs(nil); err != nil {
+		t.Fatalf("FindAppConfigs() error = %v", err)
+	}
+	if len(skipped) != scanSkipped || progress != scanProgress {
+		t.Errorf("FindAppConfigs() reported %d more skips and %d more progress updates", len(skipped)-scanSkipped, progress-scanProgress)
+	}
+	if scanSkipped == 0 {
+		t.Error("Expected the scan to skip big.lua")
+	}
+}
+
+func TestGroupFiles(t *testing.T) {
+	files := []types.DotFile{{Path: "/h/.bashrc"}, {Path: "/h/.gitconfig"}, {Path: "/h/.profile"}, {Path: "/h/.unknown"}}
+	apps := []types.App{
+		{Name: "bash", ConfigFiles: []types.DotFile{{Path: "/h/.bashrc"}, {Path: "/h/.profile"}}},
+		{Name: "sh", ConfigFiles: []types.DotFile{{Path: "/h/.profile"}}},
+		{Name: "git", ConfigFiles: []types.DotFile{{Path: "/h/.gitconfig"}}},
+		{Name: "tmux", ConfigFiles: []types.DotFile{{Path: "/other/.tmux.conf"}}},
+	}
+
+	grouped, other := GroupFiles(files, apps)
+	if len(grouped) != 2 || grouped[0].Name != "bash" || grouped[1].Name != "git" {
+		t.Fatalf("GroupFiles() grouped = %v", grouped)
+	}
+	if len(grouped[0].ConfigFiles) != 2 {
+		t.Errorf("bash files = %v", grouped[0].ConfigFiles)
+	}
+	if len(other) != 1 || other[0].Path != "/h/.unknown" {
+		t.Errorf("GroupFiles() other = %v", other)
+	}
+}
@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"sort"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// ChangeKind classifies how a path differs between two scans
+type ChangeKind string
+
+const (
+	ChangeAdded       ChangeKind = "added"
+	ChangeModified    ChangeKind = "modified"
+	ChangeDeleted     ChangeKind = "deleted"
+	ChangeTypeChanged ChangeKind = "type-changed"
+	ChangeUnchanged   ChangeKind = "unchanged"
+)
+
+// ChangeKinds lists every kind in the order they are reported
+var ChangeKinds = []ChangeKind{ChangeAdded, ChangeModified, ChangeDeleted, ChangeTypeChanged, ChangeUnchanged}
+
+// Change describes one path. Old is nil for added files and New is nil for
+// deleted files.
+type Change struct {
+	Path string         `json:"path"`
+	Kind ChangeKind     `json:"kind"`
+	Old  *types.DotFile `json:"old,omitempty"`
+	New  *types.DotFile `json:"new,omitempty"`
+}
+
+// DiffTotals counts changes by kind
+type DiffTotals struct {
+	Added       int `json:"added"`
+	Modified    int `json:"modified"`
+	Deleted     int `json:"deleted"`
+	TypeChanged int `json:"type_changed"`
+	Unchanged   int `json:"unchanged"`
+}
+
+// Diff compares a previous list of files with the current one and returns a
+// change for every path in either list, sorted by path. A file that turned
+// into a symlink, or the other way round, is type-changed; otherwise a
+// different hash or permission bits mean it was modified. Permission bits
+// are only compared when both sides recorded them.
+func Diff(previous, current []types.DotFile) []Change {
+	before := make(map[string]types.DotFile, len(previous))
+	for _, file := range previous {
+		before[file.Path] = file
+	}
+
+	var changes []Change
+	for i := range current {
+		file := &current[i]
+		old, ok := before[file.Path]
+		if !ok {
+			changes = append(changes, Change{Path: file.Path, Kind: ChangeAdded, New: file})
+			continue
+		}
+		delete(before, file.Path)
+
+		kind := ChangeUnchanged
+		switch {
+		case old.IsSymlink != file.IsSymlink:
+			kind = ChangeTypeChanged
+		case old.Hash != file.Hash:
+			kind = ChangeModified
+		case old.Mode != "" && file.Mode != "" && old.Mode != file.Mode:
+			kind = ChangeModified
+		}
+		changes = append(changes, Change{Path: file.Path, Kind: kind, Old: &old, New: file})
+	}
+	for _, old := range before {
+		old := old
+		changes = append(changes, Change{Path: old.Path, Kind: ChangeDeleted, Old: &old})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// CountChanges totals the changes by kind
+func CountChanges(changes []Change) DiffTotals {
+	var totals DiffTotals
+	for _, change := range changes {
+		switch change.Kind {
+		case ChangeAdded:
+			totals.Added++
+		case ChangeModified:
+			totals.Modified++
+		case ChangeDeleted:
+			totals.Deleted++
+		case ChangeTypeChanged:
+			totals.TypeChanged++
+		case ChangeUnchanged:
+			totals.Unchanged++
+		}
+	}
+	return totals
+}
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+func TestDiff(t *testing.T) {
+	previous := []types.DotFile{
+		{Path: "/home/user/.bashrc", Hash: "a"},
+		{Path: "/home/user/.vimrc", Hash: "b"},
+		{Path: "/home/user/.profile", Hash: "c"},
+		{Path: "/home/user/.zshrc", Hash: "d"},
+	}
+	current := []types.DotFile{
+		{Path: "/home/user/.zshrc", Hash: "d"},
+		{Path: "/home/user/.bashrc", Hash: "a2"},
+		{Path: "/home/user/.profile", Hash: "x", IsSymlink: true},
+		{Path: "/home/user/.gitconfig", Hash: "e"},
+	}
+
+	changes := Diff(previous, current)
+
+	want := []struct {
+		path string
+		kind ChangeKind
+	}{
+		{path: "/home/user/.bashrc", kind: ChangeModified},
+		{path: "/home/user/.gitconfig", kind: ChangeAdded},
+		{path: "/home/user/.profile", kind: ChangeTypeChanged},
+		{path: "/home/user/.vimrc", kind: ChangeDeleted},
+		{path: "/home/user/.zshrc", kind: ChangeUnchanged},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Diff() returned %d changes, want %d: %v", len(changes), len(want), changes)
+	}
+	for i, w := range want {
+		if changes[i].Path != w.path || changes[i].Kind != w.kind {
+			t.Errorf("changes[%d] = %s %s, want %s %s", i, changes[i].Path, changes[i].Kind, w.path, w.kind)
+		}
+	}
+
+	if changes[1].Old != nil || changes[1].New == nil {
+		t.Errorf("Added change should only have New: %+v", changes[1])
+	}
+	if changes[3].Old == nil || changes[3].New != nil {
+		t.Errorf("Deleted change should only have Old: %+v", changes[3])
+	}
+
+	totals := CountChanges(changes)
+	if totals != (DiffTotals{Added: 1, Modified: 1, Deleted: 1, TypeChanged: 1, Unchanged: 1}) {
+		t.Errorf("CountChanges() = %+v", totals)
+	}
+}
+
+func TestDiffNoPrevious(t *testing.T) {
+	changes := Diff(nil, []types.DotFile{{Path: "/a"}, {Path: "/b"}})
+	if totals := CountChanges(changes); totals.Added != 2 || len(changes) != 2 {
+		t.Errorf("Diff(nil) = %v", changes)
+	}
+}
+
+func TestDiffMode(t *testing.T) {
+	previous := []types.DotFile{
+		{Path: "/home/user/bin/deploy", Hash: "a", Mode: "0644"},
+		{Path: "/home/user/.bashrc", Hash: "b"},
+	}
+	current := []types.DotFile{
+		{Path: "/home/user/bin/deploy", Hash: "a", Mode: "0755"},
+		{Path: "/home/user/.bashrc", Hash: "b", Mode: "0644"},
+	}
+
+	changes := Diff(previous, current)
+	if changes[0].Kind != ChangeUnchanged {
+		t.Errorf("A mode recorded on one side only should not count: %+v", changes[0])
+	}
+	if changes[1].Kind != ChangeModified {
+		t.Errorf("A changed mode should count as modified: %+v", changes[1])
+	}
+}
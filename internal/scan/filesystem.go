@@ -0,0 +1,445 @@
+package scan
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/amroessam/dotback/internal/common/ignore"
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/output"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+// Options configures a FileSystem
+type Options struct {
+	// Roots are searched when FindDotFiles is called without paths.
+	// Defaults to the user's home directory, plus $XDG_CONFIG_HOME when it
+	// is not a dot directory inside the home directory.
+	Roots []string
+	// Ignore excludes matching files and directories from the scan
+	Ignore *ignore.Matcher
+	// MaxFileSize skips larger files. Zero uses DefaultMaxFileSize and a
+	// negative value disables the limit.
+	MaxFileSize int64
+	// MaxSQLiteSize skips larger SQLite databases, with the same defaults
+	// as MaxFileSize
+	MaxSQLiteSize int64
+	// SkipBinary skips files with binary content
+	SkipBinary bool
+	// OnSkip is called for every entry left out of the scan
+	OnSkip func(skip Skip)
+	// Context cancels the scan. Defaults to context.Background().
+	Context context.Context
+	// Workers is the number of files inspected in parallel. Defaults to
+	// the number of CPUs.
+	Workers int
+	// OnProgress is called as directories are entered and files are hashed
+	OnProgress func(progress Progress)
+	// Cache reuses the hashes of files that have not changed since they
+	// were last scanned. Optional.
+	Cache *Cache
+	// RecordOwner records the owner and group of every file
+	RecordOwner bool
+	// Symlinks selects what is done with symbolic links, per path
+	Symlinks types.SymlinkConfig
+	// ManagedDirs are directories managed by dotback, such as the store of
+	// restored files. Links into them are followed whatever their policy,
+	// so a restored file is scanned as its content under its own path.
+	ManagedDirs []string
+}
+
+// FileSystem implements types.FileSystem on top of the local operating system
+type FileSystem struct {
+	home          string
+	dirs          xdg.Dirs
+	roots         []string
+	ignore        *ignore.Matcher
+	secretPaths   []*ignore.Matcher
+	maxFileSize   int64
+	maxSQLiteSize int64
+	skipBinary    bool
+	onSkip        func(skip Skip)
+	onProgress    func(progress Progress)
+	owners        *owners
+	symlinks      *symlinkPolicies
+	managed       []string
+	workers       int
+	ctx           context.Context
+	cache         *Cache
+	runCommand    func(name string, args ...string) ([]byte, error)
+}
+
+// NewFileSystem creates a new OS-backed file system
+func NewFileSystem(opts Options) (*FileSystem, error) {
+	dirs, err := xdg.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileSystem{
+		home:          dirs.Home,
+		dirs:          dirs,
+		ignore:        opts.Ignore,
+		secretPaths:   newSecretPathMatchers(dirs),
+		maxFileSize:   opts.MaxFileSize,
+		maxSQLiteSize: opts.MaxSQLiteSize,
+		skipBinary:    opts.SkipBinary,
+		onSkip:        opts.OnSkip,
+		onProgress:    opts.OnProgress,
+		workers:       opts.Workers,
+		ctx:           opts.Context,
+		cache:         opts.Cache,
+		managed:       resolveDirs(opts.ManagedDirs),
+		runCommand:    runCommand,
+	}
+	if f.symlinks, err = newSymlinkPolicies(opts.Symlinks, dirs); err != nil {
+		return nil, err
+	}
+	if opts.RecordOwner {
+		f.owners = newOwners()
+	}
+	if f.ctx == nil {
+		f.ctx = context.Background()
+	}
+	if f.workers <= 0 {
+		f.workers = runtime.NumCPU()
+	}
+	if f.maxFileSize == 0 {
+		f.maxFileSize = DefaultMaxFileSize
+	}
+	if f.maxSQLiteSize == 0 {
+		f.maxSQLiteSize = DefaultMaxSQLiteSize
+	}
+	roots := opts.Roots
+	if len(roots) == 0 {
+		roots = defaultRoots(dirs)
+	}
+	for _, root := range roots {
+		f.roots = append(f.roots, f.GetAbsolutePath(root))
+	}
+	return f, nil
+}
+
+// defaultRoots returns the home directory, plus $XDG_CONFIG_HOME when the
+// home directory walk would not reach it
+func defaultRoots(dirs xdg.Dirs) []string {
+	roots := []string{dirs.Home}
+	rel, err := filepath.Rel(dirs.Home, dirs.ConfigHome)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || !strings.HasPrefix(rel, ".") {
+		roots = append(roots, dirs.ConfigHome)
+	}
+	return roots
+}
+
+// ReadFile reads the named file
+func (f *FileSystem) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(f.GetAbsolutePath(path))
+}
+
+// WriteFile writes content to the named file, creating parent directories as needed
+func (f *FileSystem) WriteFile(path string, content []byte) error {
+	path = f.GetAbsolutePath(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
+
+// CreateSymlink creates target as a symbolic link pointing to source
+func (f *FileSystem) CreateSymlink(source, target string) error {
+	source = f.GetAbsolutePath(source)
+	target = f.GetAbsolutePath(target)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	if err := os.Symlink(source, target); err != nil {
+		return fmt.Errorf("error creating symlink: %w", err)
+	}
+	return nil
+}
+
+// Readlink returns the target of a symbolic link
+func (f *FileSystem) Readlink(path string) (string, error) {
+	return os.Readlink(f.GetAbsolutePath(path))
+}
+
+// Exists reports whether the path exists, without following symlinks
+func (f *FileSystem) Exists(path string) bool {
+	_, err := os.Lstat(f.GetAbsolutePath(path))
+	return err == nil
+}
+
+// IsSymlink reports whether the path is a symbolic link
+func (f *FileSystem) IsSymlink(path string) bool {
+	info, err := os.Lstat(f.GetAbsolutePath(path))
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// GetAbsolutePath expands a leading ~ or XDG base directory variable, such
+// as $XDG_CONFIG_HOME, and returns the cleaned absolute path
+func (f *FileSystem) GetAbsolutePath(path string) string {
+	path = f.dirs.Resolve(path)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+// WalkFunc is called for every dotfile found during a walk
+type WalkFunc func(file types.DotFile) error
+
+// Walk calls fn for every dotfile under the given paths, or the configured
+// roots when none are given, as soon as the file has been hashed. Directly
+// inside the home directory only entries starting with a dot are
+// considered; any other directory is searched in full.
+//
+// Files are inspected by a pool of workers, so fn may be called in any
+// order, but never concurrently. The walk stops early when the context is
+// cancelled or fn returns an error.
+func (f *FileSystem) Walk(paths []string, fn WalkFunc) error {
+	return newWalker(f, fn).run(f.walkRoots(paths))
+}
+
+// walkRoots returns the absolute paths to walk for the given paths
+func (f *FileSystem) walkRoots(paths []string) []string {
+	if len(paths) == 0 {
+		return f.roots
+	}
+	roots := make([]string, 0, len(paths))
+	for _, path := range paths {
+		roots = append(roots, f.GetAbsolutePath(path))
+	}
+	return roots
+}
+
+// FindDotFiles walks the given paths, or the configured roots when none are
+// given, and returns every dotfile found sorted by path
+func (f *FileSystem) FindDotFiles(paths []string) ([]types.DotFile, error) {
+	return f.findDotFiles(paths, false)
+}
+
+// findDotFiles is FindDotFiles. A quiet walk does not call the OnSkip and
+// OnProgress hooks, for walks over files the caller has already scanned.
+func (f *FileSystem) findDotFiles(paths []string, quiet bool) ([]types.DotFile, error) {
+	var files []types.DotFile
+	w := newWalker(f, func(file types.DotFile) error {
+		files = append(files, file)
+		return nil
+	})
+	w.quiet = quiet
+	if err := w.run(f.walkRoots(paths)); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// FindAppConfigs returns the applications that have at least one config
+// file on disk. When apps is empty the whole Catalog is checked. Names that
+// are not in the catalog fall back to the conventional ~/.<name>,
+// ~/.<name>rc and $XDG_CONFIG_HOME/<name> locations.
+func (f *FileSystem) FindAppConfigs(apps []string) ([]types.App, error) {
+	var specs []AppSpec
+	if len(apps) == 0 {
+		specs = Catalog
+	}
+	for _, name := range apps {
+		spec, ok := lookupApp(name)
+		if !ok {
+			spec = AppSpec{Name: name, Paths: []string{"~/." + name, "~/." + name + "rc", xdg.ConfigHomeVar + "/" + name}}
+		}
+		specs = append(specs, spec)
+	}
+
+	var result []types.App
+	for _, spec := range specs {
+		var candidates []string
+		for _, path := range spec.Paths {
+			if full := f.GetAbsolutePath(path); f.Exists(full) {
+				candidates = append(candidates, full)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		// The app's directories are usually part of the main scan too, so
+		// their skips and progress are not reported a second time
+		files, err := f.findDotFiles(candidates, true)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, types.App{
+			Name:        spec.Name,
+			Version:     f.appVersion(spec),
+			ConfigFiles: files,
+		})
+	}
+	return result, nil
+}
+
+// appVersion runs the app's version command, returning "" when the app is
+// not installed or its output has no recognizable version
+func (f *FileSystem) appVersion(spec AppSpec) string {
+	if len(spec.VersionCommand) == 0 {
+		return ""
+	}
+	output, err := f.runCommand(spec.VersionCommand[0], spec.VersionCommand[1:]...)
+	if err != nil {
+		logger.Debug("Could not get %s version: %v", spec.Name, err)
+		return ""
+	}
+	return parseVersion(output)
+}
+
+// inspect builds the DotFile for an lstat result. A non-empty reason means
+// the entry was filtered out. Preserved symlinks are hashed by their target
+// so that retargeting a link counts as a change; followed ones, and links
+// into directories dotback manages, are inspected as the file they point
+// to.
+func (f *FileSystem) inspect(path string, info os.FileInfo) (types.DotFile, string, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return types.DotFile{}, "", fmt.Errorf("error reading link %s: %w", path, err)
+		}
+		policy := f.symlinks.policy(path)
+		if policy == SymlinkSkip {
+			return types.DotFile{}, "symlink (policy skip)", nil
+		}
+		if f.managedDir(path, target) != "" {
+			policy = SymlinkFollow
+		}
+
+		resolved, err := os.Stat(path)
+		switch {
+		case isLinkLoop(err):
+			return types.DotFile{}, "symlink loop", nil
+		case policy == SymlinkPreserve:
+			return f.inspectLink(path, target, info), "", nil
+		case errors.Is(err, fs.ErrNotExist):
+			return types.DotFile{}, fmt.Sprintf("dangling symlink to %s", target), nil
+		case err != nil:
+			return types.DotFile{}, "", fmt.Errorf("error following link %s: %w", path, err)
+		case resolved.IsDir():
+			// The walker follows links to directories itself
+			return types.DotFile{}, "symlink to a directory", nil
+		}
+		info = resolved
+	}
+
+	file := types.DotFile{
+		Path:         path,
+		LastModified: info.ModTime(),
+		Size:         info.Size(),
+	}
+	if f.owners != nil {
+		file.Owner = f.owners.name(info)
+	}
+
+	if reason := specialFileReason(info.Mode()); reason != "" {
+		return types.DotFile{}, reason, nil
+	}
+	if f.maxFileSize >= 0 && info.Size() > f.maxFileSize {
+		return types.DotFile{}, fmt.Sprintf("larger than %s (%s)", output.FormatBytes(f.maxFileSize), output.FormatBytes(info.Size())), nil
+	}
+
+	entry, ok := f.cache.lookup(path, info)
+	if !ok {
+		var reason string
+		var err error
+		if entry, reason, err = f.hashFile(path, info); err != nil || reason != "" {
+			return types.DotFile{}, reason, err
+		}
+		f.cache.store(path, info, entry)
+	}
+
+	// Filters are applied to cached entries too, since the limits may have
+	// changed since the file was hashed
+	if reason := f.sqliteReason(info, entry.SQLite); reason != "" {
+		return types.DotFile{}, reason, nil
+	}
+	if entry.Binary && f.skipBinary {
+		return types.DotFile{}, "binary file", nil
+	}
+
+	file.Hash = entry.Hash
+	file.Mode = formatMode(info.Mode())
+	file.Binary = entry.Binary
+	file.Secrets = f.detectSecrets(path, entry.Secrets)
+	return file, "", nil
+}
+
+// inspectLink builds the DotFile of a preserved symlink. Dangling links
+// are preserved too, since what they point to may exist on the machine the
+// backup is restored to.
+func (f *FileSystem) inspectLink(path, target string, info os.FileInfo) types.DotFile {
+	sum := sha256.Sum256([]byte(target))
+	file := types.DotFile{
+		Path:         path,
+		LastModified: info.ModTime(),
+		Hash:         hex.EncodeToString(sum[:]),
+		IsSymlink:    true,
+		LinkTarget:   target,
+		Size:         int64(len(target)),
+		Secrets:      f.detectSecrets(path, nil),
+	}
+	if f.owners != nil {
+		file.Owner = f.owners.name(info)
+	}
+	return file
+}
+
+// hashFile reads a regular file and records what the cache needs to know
+// about its content. Files that are filtered out are not hashed in full.
+func (f *FileSystem) hashFile(path string, info os.FileInfo) (cacheEntry, string, error) {
+	handle, err := os.Open(path)
+	if err != nil {
+		return cacheEntry{}, "", err
+	}
+	defer handle.Close()
+
+	// The head is used for content sniffing and secret detection, then
+	// hashed together with the rest of the file
+	head := make([]byte, min(info.Size(), maxSecretScanBytes))
+	n, err := io.ReadFull(handle, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return cacheEntry{}, "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	head = head[:n]
+
+	entry := cacheEntry{SQLite: isSQLite(head), Binary: isBinary(head)}
+	if reason := f.sqliteReason(info, entry.SQLite); reason != "" {
+		return cacheEntry{}, reason, nil
+	}
+	if entry.Binary && f.skipBinary {
+		return cacheEntry{}, "binary file", nil
+	}
+
+	h := sha256.New()
+	h.Write(head)
+	if _, err := io.Copy(h, handle); err != nil {
+		return cacheEntry{}, "", fmt.Errorf("error hashing %s: %w", path, err)
+	}
+	entry.Hash = hex.EncodeToString(h.Sum(nil))
+	if !entry.Binary {
+		entry.Secrets = detectSecretContent(head)
+	}
+	return entry, "", nil
+}
@@ -0,0 +1,257 @@
+package scan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/ignore"
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// setupHome creates a fake home directory with a few dotfiles and points
+// HOME at it for the duration of the test
+func setupHome(t *testing.T) string {
+	home, err := os.MkdirTemp("", "dotback-home-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	t.Setenv("HOME", home)
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME"} {
+		t.Setenv(name, "")
+	}
+
+	files := map[string]string{
+		".bashrc":                 "export PATH=$PATH:~/bin\n",
+		".config/nvim/init.lua":   "vim.opt.number = true\n",
+		".config/git/config":      "[user]\n\tname = test\n",
+		"Documents/notes.txt":     "not a dotfile\n",
+		"Documents/.hidden":       "not at the top level\n",
+		".local/share/app/a.conf": "a = 1\n",
+	}
+	for path, content := range files {
+		full := filepath.Join(home, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(home, ".bashrc"), filepath.Join(home, ".profile")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	return home
+}
+
+func TestFileSystemImplementsInterface(t *testing.T) {
+	var _ types.FileSystem = &FileSystem{}
+}
+
+func TestFindDotFiles(t *testing.T) {
+	home := setupHome(t)
+
+	fs, err := NewFileSystem(Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	files, err := fs.FindDotFiles(nil)
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+
+	want := []string{
+		filepath.Join(home, ".bashrc"),
+		filepath.Join(home, ".config/git/config"),
+		filepath.Join(home, ".config/nvim/init.lua"),
+		filepath.Join(home, ".local/share/app/a.conf"),
+		filepath.Join(home, ".profile"),
+	}
+	if len(files) != len(want) {
+		t.Fatalf("FindDotFiles() returned %d files, want %d: %v", len(files), len(want), files)
+	}
+	for i, file := range files {
+		if file.Path != want[i] {
+			t.Errorf("files[%d].Path = %v, want %v", i, file.Path, want[i])
+		}
+		if file.Hash == "" {
+			t.Errorf("files[%d].Hash is empty", i)
+		}
+		if file.LastModified.IsZero() {
+			t.Errorf("files[%d].LastModified is zero", i)
+		}
+	}
+
+	bashrc := files[0]
+	sum := sha256.Sum256([]byte("export PATH=$PATH:~/bin\n"))
+	if bashrc.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Hash = %v, want SHA-256 of contents", bashrc.Hash)
+	}
+	if bashrc.IsSymlink {
+		t.Error(".bashrc should not be a symlink")
+	}
+	if !files[4].IsSymlink {
+		t.Error(".profile should be a symlink")
+	}
+}
+
+func TestFindDotFilesExplicitPaths(t *testing.T) {
+	home := setupHome(t)
+
+	fs, err := NewFileSystem(Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	files, err := fs.FindDotFiles([]string{"~/.config", "~/.bashrc", "~/.config/nvim"})
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("FindDotFiles() returned %d files, want 3: %v", len(files), files)
+	}
+	if files[0].Path != filepath.Join(home, ".bashrc") {
+		t.Errorf("files[0].Path = %v", files[0].Path)
+	}
+
+	if _, err := fs.FindDotFiles([]string{"~/missing"}); err == nil {
+		t.Error("Expected error for missing path")
+	}
+}
+
+func TestFileOperations(t *testing.T) {
+	home := setupHome(t)
+
+	fs, err := NewFileSystem(Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	if got := fs.GetAbsolutePath("~/.bashrc"); got != filepath.Join(home, ".bashrc") {
+		t.Errorf("GetAbsolutePath() = %v", got)
+	}
+
+	if err := fs.WriteFile("~/.config/new/file", []byte("content")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	content, err := fs.ReadFile("~/.config/new/file")
+	if err != nil || string(content) != "content" {
+		t.Errorf("ReadFile() = %q, %v", content, err)
+	}
+
+	if err := fs.CreateSymlink("~/.config/new/file", "~/.newlink"); err != nil {
+		t.Fatalf("CreateSymlink() error = %v", err)
+	}
+	if !fs.Exists("~/.newlink") || !fs.IsSymlink("~/.newlink") {
+		t.Error("Expected ~/.newlink to be a symlink")
+	}
+	if fs.IsSymlink("~/.bashrc") {
+		t.Error("Expected ~/.bashrc not to be a symlink")
+	}
+	if fs.Exists("~/.missing") {
+		t.Error("Expected ~/.missing not to exist")
+	}
+}
+
+func TestFindDotFilesIgnore(t *testing.T) {
+	home := setupHome(t)
+
+	matcher := ignore.NewMatcher(home)
+	matcher.AddPatterns("test", []string{".local/", "*.lua", "!init.lua", ".config/git/"})
+
+	fs, err := NewFileSystem(Options{Ignore: matcher})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	files, err := fs.FindDotFiles(nil)
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	var got []string
+	for _, file := range files {
+		got = append(got, file.Path)
+	}
+	want := []string{
+		filepath.Join(home, ".bashrc"),
+		filepath.Join(home, ".config/nvim/init.lua"),
+		filepath.Join(home, ".profile"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("FindDotFiles() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("files[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Explicit paths inside an excluded directory are skipped too
+	files, err = fs.FindDotFiles([]string{"~/.local/share/app/a.conf"})
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("FindDotFiles() = %v, want no files", files)
+	}
+}
+
+func TestFindDotFilesXDG(t *testing.T) {
+	home := setupHome(t)
+	configHome := filepath.Join(home, "xdg-config")
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+
+	for path, content := range map[string]string{
+		"nvim/init.lua": "vim.opt.number = false\n",
+		"gh/hosts.yml":  "github.com:\n  user: test\n",
+	} {
+		full := filepath.Join(configHome, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	fs, err := NewFileSystem(Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	fs.runCommand = func(name string, args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not installed", name)
+	}
+
+	// $XDG_CONFIG_HOME is not a dot directory, so it is scanned as its own root
+	files, err := fs.FindDotFiles(nil)
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	secrets := make(map[string][]string)
+	for _, file := range files {
+		secrets[file.Path] = file.Secrets
+	}
+	if _, ok := secrets[filepath.Join(configHome, "nvim/init.lua")]; !ok {
+		t.Errorf("Expected nvim/init.lua under $XDG_CONFIG_HOME, got %v", files)
+	}
+	if got := secrets[filepath.Join(configHome, "gh/hosts.yml")]; len(got) != 1 || got[0] != "credential-file" {
+		t.Errorf("gh/hosts.yml Secrets = %v", got)
+	}
+
+	apps, err := fs.FindAppConfigs([]string{"nvim"})
+	if err != nil {
+		t.Fatalf("FindAppConfigs() error = %v", err)
+	}
+	if len(apps) != 1 || len(apps[0].ConfigFiles) != 1 || apps[0].ConfigFiles[0].Path != filepath.Join(configHome, "nvim/init.lua") {
+		t.Errorf("FindAppConfigs() = %v", apps)
+	}
+
+	if got := fs.GetAbsolutePath("$XDG_CONFIG_HOME/nvim"); got != filepath.Join(configHome, "nvim") {
+		t.Errorf("GetAbsolutePath() = %q", got)
+	}
+}
@@ -0,0 +1,76 @@
+package scan
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/amroessam/dotback/internal/common/output"
+)
+
+const (
+	// DefaultMaxFileSize is the largest file included in a scan by default
+	DefaultMaxFileSize = 10 << 20
+	// DefaultMaxSQLiteSize is the largest SQLite database included by default
+	DefaultMaxSQLiteSize = 5 << 20
+
+	// binarySniffBytes is how much of a file is checked for NUL bytes, the
+	// same heuristic git uses to tell binary from text
+	binarySniffBytes = 8000
+)
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Skip records an entry that was left out of a scan and why
+type Skip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// specialFileReason returns why a file of the given mode cannot be backed
+// up, or "" for regular files, directories and symlinks
+func specialFileReason(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "device file"
+	case mode&os.ModeIrregular != 0:
+		return "irregular file"
+	}
+	return ""
+}
+
+// isBinary reports whether the start of a file contains a NUL byte
+func isBinary(head []byte) bool {
+	if len(head) > binarySniffBytes {
+		head = head[:binarySniffBytes]
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// isSQLite reports whether the start of a file is a SQLite database header
+func isSQLite(head []byte) bool {
+	return bytes.HasPrefix(head, sqliteHeader)
+}
+
+// sqliteReason returns why a SQLite database should be skipped, or "" if
+// the file is not a database or is small enough to back up. Sparse
+// databases are skipped because copying them expands the holes and the
+// live file is usually still being written by its application.
+func (f *FileSystem) sqliteReason(info os.FileInfo, sqlite bool) string {
+	if !sqlite {
+		return ""
+	}
+	if f.maxSQLiteSize >= 0 && info.Size() > f.maxSQLiteSize {
+		return fmt.Sprintf("SQLite database larger than %s (%s)", output.FormatBytes(f.maxSQLiteSize), output.FormatBytes(info.Size()))
+	}
+	if allocated, ok := allocatedSize(info); ok && allocated < info.Size()/2 {
+		return fmt.Sprintf("sparse SQLite database (%s allocated of %s)", output.FormatBytes(allocated), output.FormatBytes(info.Size()))
+	}
+	return ""
+}
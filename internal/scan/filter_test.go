@@ -0,0 +1,150 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpecialFileReason(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string
+	}{
+		{mode: 0644, want: ""},
+		{mode: os.ModeDir | 0755, want: ""},
+		{mode: os.ModeSymlink | 0777, want: ""},
+		{mode: os.ModeSocket | 0755, want: "socket"},
+		{mode: os.ModeNamedPipe | 0644, want: "named pipe"},
+		{mode: os.ModeDevice | 0660, want: "device file"},
+		{mode: os.ModeDevice | os.ModeCharDevice | 0660, want: "character device"},
+		{mode: os.ModeIrregular, want: "irregular file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			if got := specialFileReason(tt.mode); got != tt.want {
+				t.Errorf("specialFileReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	if isBinary([]byte("plain text\n")) {
+		t.Error("Text reported as binary")
+	}
+	if !isBinary([]byte("ELF\x00\x01")) {
+		t.Error("NUL byte not reported as binary")
+	}
+	late := append([]byte(strings.Repeat("a", binarySniffBytes)), 0)
+	if isBinary(late) {
+		t.Error("NUL byte after the sniff window reported as binary")
+	}
+}
+
+func TestFindDotFilesFilters(t *testing.T) {
+	home := setupHome(t)
+
+	write := func(path string, content []byte) {
+		full := filepath.Join(home, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	write(".big/history", make([]byte, 2048))
+	write(".fonts/font.ttf", []byte("\x00\x01\x00\x00font"))
+	write(".app/small.db", append([]byte("SQLite format 3\x00"), make([]byte, 100)...))
+	write(".app/large.db", append([]byte("SQLite format 3\x00"), make([]byte, 600)...))
+
+	var skipped []Skip
+	fs, err := NewFileSystem(Options{
+		MaxFileSize:   1024,
+		MaxSQLiteSize: 512,
+		OnSkip:        func(skip Skip) { skipped = append(skipped, skip) },
+	})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	files, err := fs.FindDotFiles([]string{"~/.big", "~/.fonts", "~/.app"})
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, file := range files {
+		rel, _ := filepath.Rel(home, file.Path)
+		found[rel] = file.Binary
+	}
+	if binary, ok := found[".fonts/font.ttf"]; !ok || !binary {
+		t.Errorf("Expected font.ttf to be found and marked binary, got %v", found)
+	}
+	if _, ok := found[".app/small.db"]; !ok {
+		t.Errorf("Expected small.db to be found, got %v", found)
+	}
+
+	reasons := make(map[string]string)
+	for _, skip := range skipped {
+		rel, _ := filepath.Rel(home, skip.Path)
+		reasons[rel] = skip.Reason
+	}
+	if !strings.HasPrefix(reasons[".big/history"], "larger than 1.0 KiB") {
+		t.Errorf("history reason = %q", reasons[".big/history"])
+	}
+	if !strings.HasPrefix(reasons[".app/large.db"], "SQLite database larger than") {
+		t.Errorf("large.db reason = %q", reasons[".app/large.db"])
+	}
+
+	// Binary files can be excluded entirely
+	fs, err = NewFileSystem(Options{SkipBinary: true, OnSkip: func(skip Skip) { skipped = append(skipped, skip) }})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	skipped = nil
+	files, err = fs.FindDotFiles([]string{"~/.fonts"})
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	if len(files) != 0 || len(skipped) != 1 || skipped[0].Reason != "binary file" {
+		t.Errorf("FindDotFiles() = %v, skipped %v", files, skipped)
+	}
+}
+
+func TestFindDotFilesSparseSQLite(t *testing.T) {
+	home := setupHome(t)
+
+	path := filepath.Join(home, ".app", "sparse.db")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("SQLite format 3\x00"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Truncate(path, 1<<20); err != nil {
+		t.Fatalf("Failed to extend file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if allocated, ok := allocatedSize(info); !ok || allocated >= info.Size()/2 {
+		t.Skip("File system does not create sparse files")
+	}
+
+	var skipped []Skip
+	fs, err := NewFileSystem(Options{OnSkip: func(skip Skip) { skipped = append(skipped, skip) }})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	if _, err := fs.FindDotFiles([]string{"~/.app"}); err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0].Reason, "sparse SQLite database") {
+		t.Errorf("skipped = %v, want sparse SQLite database", skipped)
+	}
+}
@@ -0,0 +1,55 @@
+package scan
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// owners resolves user and group IDs to names. Lookups are cached since
+// nearly every file in a home directory has the same owner.
+type owners struct {
+	mu     sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func newOwners() *owners {
+	return &owners{users: make(map[uint32]string), groups: make(map[uint32]string)}
+}
+
+// name returns "user:group" for the file, or "" when ownership is not
+// available on this platform. IDs without a name are kept as numbers.
+func (o *owners) name(info os.FileInfo) string {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return ""
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	userName, ok := o.users[uid]
+	if !ok {
+		userName = strconv.FormatUint(uint64(uid), 10)
+		if u, err := user.LookupId(userName); err == nil {
+			userName = u.Username
+		}
+		o.users[uid] = userName
+	}
+	groupName, ok := o.groups[gid]
+	if !ok {
+		groupName = strconv.FormatUint(uint64(gid), 10)
+		if g, err := user.LookupGroupId(groupName); err == nil {
+			groupName = g.Name
+		}
+		o.groups[gid] = groupName
+	}
+	return fmt.Sprintf("%s:%s", userName, groupName)
+}
+
+// formatMode returns the permission bits of a file in octal
+func formatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", uint32(mode.Perm()))
+}
@@ -0,0 +1,71 @@
+package scan
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFindDotFilesMode(t *testing.T) {
+	home := setupHome(t)
+	if err := os.Chmod(filepath.Join(home, ".bashrc"), 0600); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+
+	fs, err := NewFileSystem(Options{})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	files, err := fs.FindDotFiles([]string{"~/.bashrc"})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("FindDotFiles() = %v, %v", files, err)
+	}
+	if files[0].Mode != "0600" {
+		t.Errorf("Mode = %q, want 0600", files[0].Mode)
+	}
+	if files[0].Owner != "" {
+		t.Errorf("Owner = %q without RecordOwner", files[0].Owner)
+	}
+}
+
+func TestFindDotFilesOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file ownership is not available on Windows")
+	}
+	setupHome(t)
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Could not look up the current user: %v", err)
+	}
+
+	fs, err := NewFileSystem(Options{RecordOwner: true})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	files, err := fs.FindDotFiles([]string{"~/.bashrc"})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("FindDotFiles() = %v, %v", files, err)
+	}
+	if !strings.HasPrefix(files[0].Owner, current.Username+":") {
+		t.Errorf("Owner = %q, want %s:<group>", files[0].Owner, current.Username)
+	}
+}
+
+func TestFormatMode(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string
+	}{
+		{mode: 0644, want: "0644"},
+		{mode: 0755 | os.ModeDir, want: "0755"},
+		{mode: 0600 | os.ModeSetuid, want: "0600"},
+	}
+	for _, tt := range tests {
+		if got := formatMode(tt.mode); got != tt.want {
+			t.Errorf("formatMode(%v) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
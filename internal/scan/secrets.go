@@ -0,0 +1,166 @@
+package scan
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/amroessam/dotback/internal/common/ignore"
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+// maxSecretScanBytes bounds how much of each file is searched for secrets
+const maxSecretScanBytes = 1 << 20
+
+// minSecretEntropy is the Shannon entropy, in bits per character, above
+// which an assigned credential value is considered a real secret
+const minSecretEntropy = 4.0
+
+// secretPattern flags file content matching a known credential format
+type secretPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+var secretPatterns = []secretPattern{
+	{name: "private-key", re: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{name: "aws-access-key", re: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA)[0-9A-Z]{16}\b`)},
+	{name: "aws-secret-key", re: regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{name: "gcp-service-account", re: regexp.MustCompile(`"type"\s*:\s*"service_account"`)},
+	{name: "gcp-api-key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{name: "github-token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{name: "slack-token", re: regexp.MustCompile(`\bxox[abprs]-[0-9A-Za-z-]{10,}`)},
+}
+
+// credentialAssignment finds values assigned to credential-like keys. Only
+// values with high entropy are reported, to skip placeholders and paths.
+var credentialAssignment = regexp.MustCompile(`(?i)(?:api[_-]?key|secret|token|passw(?:or)?d|auth)[A-Za-z_-]*["']?\s*[:=]\s*["']?([A-Za-z0-9+/_\-.=]{20,})`)
+
+// secretPaths are files that hold credentials whatever their content. The
+// patterns use gitignore syntax below the home directory ("~/") or an XDG
+// base directory.
+var secretPaths = []struct {
+	name     string
+	patterns []string
+}{
+	{name: "credential-file", patterns: []string{
+		"~/.aws/credentials",
+		"~/.netrc",
+		"~/_netrc",
+		"~/.git-credentials",
+		"~/.pgpass",
+		"~/.docker/config.json",
+		"~/.kube/config",
+		"~/.vault-token",
+		"~/.password-store/",
+		"$XDG_CONFIG_HOME/gh/hosts.yml",
+		"$XDG_CONFIG_HOME/gcloud/credentials.db",
+		"$XDG_CONFIG_HOME/gcloud/application_default_credentials.json",
+		"$XDG_CONFIG_HOME/gcloud/legacy_credentials/",
+	}},
+	{name: "ssh-private-key", patterns: []string{"~/.ssh/id_*", "!~/.ssh/id_*.pub"}},
+	{name: "gpg-private-key", patterns: []string{"~/.gnupg/private-keys-v1.d/", "~/.gnupg/secring.gpg"}},
+}
+
+// newSecretPathMatchers builds one matcher per base directory used by
+// secretPaths. Rule sources are the secret names.
+func newSecretPathMatchers(dirs xdg.Dirs) []*ignore.Matcher {
+	var matchers []*ignore.Matcher
+	byBase := make(map[string]*ignore.Matcher)
+	for _, rule := range secretPaths {
+		for _, pattern := range rule.patterns {
+			negate := strings.HasPrefix(pattern, "!")
+			base, rel, _ := strings.Cut(strings.TrimPrefix(pattern, "!"), "/")
+			m, ok := byBase[base]
+			if !ok {
+				m = ignore.NewMatcher(dirs.Resolve(base))
+				byBase[base] = m
+				matchers = append(matchers, m)
+			}
+			if negate {
+				rel = "!" + rel
+			}
+			m.AddPatterns(rule.name, []string{rel})
+		}
+	}
+	return matchers
+}
+
+// detectSecrets returns the names of the secret rules triggered by the path,
+// combined with those already found in the file's content
+func (f *FileSystem) detectSecrets(path string, contentSecrets []string) []string {
+	found := make(map[string]bool)
+	for _, m := range f.secretPaths {
+		if result := m.Explain(path, false); result.Ignored {
+			found[result.Rule.Source] = true
+		}
+	}
+	for _, name := range contentSecrets {
+		found[name] = true
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectSecretContent returns the names of the content rules that match
+func detectSecretContent(content []byte) []string {
+	// Binary files are not searched; their matches are mostly noise
+	if bytes.IndexByte(content, 0) >= 0 {
+		return nil
+	}
+
+	var names []string
+	for _, pattern := range secretPatterns {
+		if pattern.re.Match(content) {
+			names = append(names, pattern.name)
+		}
+	}
+	for _, match := range credentialAssignment.FindAllSubmatch(content, -1) {
+		if entropy(match[1]) >= minSecretEntropy {
+			names = append(names, "high-entropy-string")
+			break
+		}
+	}
+	return names
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s []byte) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	counts := make(map[byte]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	var h float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// SplitSecrets separates files flagged with secrets from the rest. Flagged
+// files whose path matches the allow list are treated as safe.
+func SplitSecrets(files []types.DotFile, allow *ignore.Matcher) (safe, blocked []types.DotFile) {
+	for _, file := range files {
+		if len(file.Secrets) > 0 && (allow == nil || !allow.Ignored(file.Path, false)) {
+			blocked = append(blocked, file)
+			continue
+		}
+		safe = append(safe, file)
+	}
+	return safe, blocked
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package scan
+
+import "os"
+
+// allocatedSize is not available on this platform
+func allocatedSize(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+// fileID is not available on this platform
+func fileID(info os.FileInfo) (uint64, uint64, bool) {
+	return 0, 0, false
+}
+
+// fileOwner is not available on this platform
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// isLinkLoop is not available on this platform
+func isLinkLoop(err error) bool {
+	return false
+}
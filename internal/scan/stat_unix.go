@@ -0,0 +1,41 @@
+//go:build unix
+
+package scan
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// allocatedSize returns the number of bytes allocated on disk for the file
+func allocatedSize(info os.FileInfo) (int64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
+
+// fileID returns the device and inode numbers of the file
+func fileID(info os.FileInfo) (uint64, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(st.Dev), uint64(st.Ino), true
+}
+
+// fileOwner returns the user and group IDs of the file
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+// isLinkLoop reports whether err comes from following a symlink loop
+func isLinkLoop(err error) bool {
+	return errors.Is(err, syscall.ELOOP)
+}
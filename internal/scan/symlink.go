@@ -0,0 +1,124 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+const (
+	// SymlinkPreserve records a link and its target. It is the default.
+	SymlinkPreserve = "preserve"
+	// SymlinkFollow backs up what a link points to as if it were the link
+	SymlinkFollow = "follow"
+	// SymlinkSkip leaves links out of the scan
+	SymlinkSkip = "skip"
+)
+
+// symlinkRule applies a policy to a path and everything below it, or to
+// the paths matching a glob pattern
+type symlinkRule struct {
+	pattern string
+	policy  string
+}
+
+// symlinkPolicies decides what happens to each symlink found in a scan
+type symlinkPolicies struct {
+	def   string
+	rules []symlinkRule
+}
+
+// CheckSymlinkPolicy returns an error unless policy is empty or a known
+// symlink policy
+func CheckSymlinkPolicy(policy string) error {
+	switch policy {
+	case "", SymlinkPreserve, SymlinkFollow, SymlinkSkip:
+		return nil
+	}
+	return fmt.Errorf("invalid symlink policy %q, expected %q, %q or %q", policy, SymlinkPreserve, SymlinkFollow, SymlinkSkip)
+}
+
+// newSymlinkPolicies resolves the paths of a symlink config. The most
+// specific path wins when several match.
+func newSymlinkPolicies(cfg types.SymlinkConfig, dirs xdg.Dirs) (*symlinkPolicies, error) {
+	if err := CheckSymlinkPolicy(cfg.Default); err != nil {
+		return nil, err
+	}
+	p := &symlinkPolicies{def: cfg.Default}
+	if p.def == "" {
+		p.def = SymlinkPreserve
+	}
+	for pattern, policy := range cfg.Paths {
+		if err := CheckSymlinkPolicy(policy); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		if policy == "" {
+			policy = p.def
+		}
+		p.rules = append(p.rules, symlinkRule{pattern: filepath.Clean(dirs.Resolve(pattern)), policy: policy})
+	}
+	sort.Slice(p.rules, func(i, j int) bool { return len(p.rules[i].pattern) > len(p.rules[j].pattern) })
+	return p, nil
+}
+
+// policy returns the policy for the symlink at an absolute path
+func (p *symlinkPolicies) policy(path string) string {
+	if p == nil {
+		return SymlinkPreserve
+	}
+	for _, rule := range p.rules {
+		if xdg.MatchPath(rule.pattern, path) {
+			return rule.policy
+		}
+	}
+	return p.def
+}
+
+// managedDir returns the directory managed by dotback that the symlink at
+// path points into, or "" if it points elsewhere. Restored files are links
+// into dotback's store, and backing them up as links would store links to
+// themselves.
+func (f *FileSystem) managedDir(path, target string) string {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	candidates := []string{filepath.Clean(target)}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		candidates = append(candidates, resolved)
+	}
+	for _, dir := range f.managed {
+		for _, candidate := range candidates {
+			if inside(dir, candidate) {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+// inside reports whether path is dir or inside it
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveDirs returns the directories with symlinks in them resolved, so
+// that they can be compared with the targets of links
+func resolveDirs(dirs []string) []string {
+	var resolved []string
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		resolved = append(resolved, abs)
+		if real, err := filepath.EvalSymlinks(abs); err == nil && real != abs {
+			resolved = append(resolved, real)
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,157 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+	"github.com/amroessam/dotback/internal/common/xdg"
+)
+
+func TestSymlinkPolicies(t *testing.T) {
+	home := setupHome(t)
+	dirs, err := xdg.Load()
+	if err != nil {
+		t.Fatalf("xdg.Load() error = %v", err)
+	}
+
+	policies, err := newSymlinkPolicies(types.SymlinkConfig{
+		Default: SymlinkFollow,
+		Paths: map[string]string{
+			"~/.config":           SymlinkSkip,
+			"~/.config/nvim":      SymlinkPreserve,
+			"~/bin/*":             SymlinkSkip,
+			"~/.local/s*":         SymlinkSkip,
+			"$XDG_CONFIG_HOME/gh": SymlinkFollow,
+		},
+	}, dirs)
+	if err != nil {
+		t.Fatalf("newSymlinkPolicies() error = %v", err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: ".bashrc", want: SymlinkFollow},
+		{path: ".config/git/config", want: SymlinkSkip},
+		{path: ".config/nvim/lua/init.lua", want: SymlinkPreserve},
+		{path: ".config/gh/hosts.yml", want: SymlinkFollow},
+		{path: "bin/tool", want: SymlinkSkip},
+		{path: ".local/share/app/data", want: SymlinkSkip},
+		{path: ".local/bin/tool", want: SymlinkFollow},
+		{path: ".configure", want: SymlinkFollow},
+	}
+	for _, tt := range tests {
+		if got := policies.policy(filepath.Join(home, tt.path)); got != tt.want {
+			t.Errorf("policy(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	var none *symlinkPolicies
+	if got := none.policy(filepath.Join(home, ".bashrc")); got != SymlinkPreserve {
+		t.Errorf("Default policy = %q, want %q", got, SymlinkPreserve)
+	}
+	if _, err := newSymlinkPolicies(types.SymlinkConfig{Default: "copy"}, dirs); err == nil {
+		t.Error("newSymlinkPolicies() accepted an unknown default")
+	}
+	if _, err := newSymlinkPolicies(types.SymlinkConfig{Paths: map[string]string{"~/.vimrc": "copy"}}, dirs); err == nil {
+		t.Error("newSymlinkPolicies() accepted an unknown policy")
+	}
+}
+
+func TestFindDotFilesSymlinks(t *testing.T) {
+	home := setupHome(t)
+	store := filepath.Join(home, ".local/share/dotback/store")
+	for path, content := range map[string]string{
+		"src/vimrc":                              "set number\n",
+		"src/tmux/tmux.conf":                     "set -g mouse on\n",
+		".local/share/dotback/store/home/.zshrc": "setopt autocd\n",
+	} {
+		full := filepath.Join(home, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	for link, target := range map[string]string{
+		".vimrc":        filepath.Join(home, "src/vimrc"),
+		".dangling":     "nowhere",
+		".loop":         ".loop2",
+		".loop2":        ".loop",
+		".zshrc":        filepath.Join(store, "home/.zshrc"),
+		".config/tmux":  filepath.Join(home, "src/tmux"),
+		".config/self":  "..",
+		".config/skipd": filepath.Join(home, "src/vimrc"),
+	} {
+		if err := os.Symlink(target, filepath.Join(home, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	scanWith := func(cfg types.SymlinkConfig) (map[string]types.DotFile, map[string]string) {
+		skipped := map[string]string{}
+		fs, err := NewFileSystem(Options{
+			Symlinks:    cfg,
+			ManagedDirs: []string{filepath.Join(home, ".local/share/dotback")},
+			OnSkip:      func(skip Skip) { skipped[strings.TrimPrefix(skip.Path, home+"/")] = skip.Reason },
+		})
+		if err != nil {
+			t.Fatalf("NewFileSystem() error = %v", err)
+		}
+		files, err := fs.FindDotFiles(nil)
+		if err != nil {
+			t.Fatalf("FindDotFiles() error = %v", err)
+		}
+		found := map[string]types.DotFile{}
+		for _, file := range files {
+			found[strings.TrimPrefix(file.Path, home+"/")] = file
+		}
+		return found, skipped
+	}
+
+	// Preserve records links, including dangling ones
+	found, skipped := scanWith(types.SymlinkConfig{Paths: map[string]string{"~/.config/skipd": SymlinkSkip}})
+	if vimrc := found[".vimrc"]; !vimrc.IsSymlink || vimrc.LinkTarget != filepath.Join(home, "src/vimrc") {
+		t.Errorf(".vimrc = %+v", vimrc)
+	}
+	if dangling := found[".dangling"]; !dangling.IsSymlink || dangling.LinkTarget != "nowhere" {
+		t.Errorf(".dangling = %+v", dangling)
+	}
+	if tmux := found[".config/tmux"]; !tmux.IsSymlink {
+		t.Errorf(".config/tmux = %+v", tmux)
+	}
+	// A link into the store is a restored file, scanned as its content
+	if zshrc := found[".zshrc"]; zshrc.IsSymlink || zshrc.Size != int64(len("setopt autocd\n")) || zshrc.Hash == "" {
+		t.Errorf(".zshrc = %+v", zshrc)
+	}
+	for path, reason := range map[string]string{
+		".loop":         "symlink loop",
+		".config/skipd": "policy skip",
+	} {
+		if !strings.Contains(skipped[path], reason) {
+			t.Errorf("%s skipped as %q, want %q", path, skipped[path], reason)
+		}
+	}
+
+	// Follow backs up the content, and walks linked directories
+	found, skipped = scanWith(types.SymlinkConfig{Default: SymlinkFollow})
+	if vimrc := found[".vimrc"]; vimrc.IsSymlink || vimrc.Size != int64(len("set number\n")) || vimrc.Mode == "" {
+		t.Errorf(".vimrc = %+v", vimrc)
+	}
+	if _, ok := found[".config/tmux/tmux.conf"]; !ok {
+		t.Errorf("Linked directory was not walked: %v", found)
+	}
+	for path, reason := range map[string]string{
+		".dangling":    "dangling symlink to nowhere",
+		".loop":        "symlink loop",
+		".config/self": "symlink loop",
+	} {
+		if !strings.Contains(skipped[path], reason) {
+			t.Errorf("%s skipped as %q, want %q", path, skipped[path], reason)
+		}
+	}
+}
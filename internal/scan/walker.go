@@ -0,0 +1,311 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/amroessam/dotback/internal/common/logger"
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// Progress reports how far a scan has got
+type Progress struct {
+	FilesSeen   int64  `json:"files_seen"`
+	BytesHashed int64  `json:"bytes_hashed"`
+	CurrentDir  string `json:"current_dir"`
+}
+
+// job is a file waiting to be inspected. Either info is set, or entry is
+// set and the worker stats it.
+type job struct {
+	path  string
+	entry fs.DirEntry
+	info  os.FileInfo
+}
+
+// walker traverses directories on one goroutine and hands files to a
+// bounded pool of workers that stat, hash and check them
+type walker struct {
+	fs     *FileSystem
+	fn     WalkFunc
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan job
+	seen   map[string]bool
+	// following holds the targets of the directory links being walked
+	following map[string]bool
+	// quiet leaves out the OnSkip and OnProgress hooks
+	quiet bool
+
+	// mu serializes callbacks and guards the fields below
+	mu       sync.Mutex
+	err      error
+	progress Progress
+}
+
+func newWalker(f *FileSystem, fn WalkFunc) *walker {
+	ctx, cancel := context.WithCancel(f.ctx)
+	return &walker{
+		fs:     f,
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(chan job, f.workers*4),
+		seen:   make(map[string]bool),
+
+		following: make(map[string]bool),
+	}
+}
+
+// run walks every root and waits for the workers to finish
+func (w *walker) run(roots []string) error {
+	defer w.cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.fs.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range w.jobs {
+				w.inspect(j)
+			}
+		}()
+	}
+
+	var err error
+	for _, root := range roots {
+		logger.Debug("Scanning %s", root)
+		if err = w.walkRoot(root); err != nil {
+			break
+		}
+	}
+	close(w.jobs)
+	wg.Wait()
+
+	// An error from the callback is what stopped the walk, so it wins
+	if w.err != nil {
+		return w.err
+	}
+	if err == nil {
+		err = w.fs.ctx.Err()
+	}
+	return err
+}
+
+func (w *walker) walkRoot(root string) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", root, err)
+	}
+	if w.fs.ignore != nil {
+		if result := w.fs.ignore.Explain(root, info.IsDir()); result.Ignored {
+			w.skip(root, "ignored by "+result.Rule.String())
+			return nil
+		}
+	}
+	if info.Mode()&os.ModeSymlink != 0 && w.followsDir(root) {
+		return w.followDir(root)
+	}
+	if !info.IsDir() {
+		if !w.seen[root] {
+			w.seen[root] = true
+			return w.submit(job{path: root, info: info})
+		}
+		return nil
+	}
+	return w.walkTree(root, root)
+}
+
+// walkTree walks dir and reports every entry as if dir were at shown. The
+// two differ when dir is the target of a followed directory link.
+func (w *walker) walkTree(dir, shown string) error {
+	isHome := shown == w.fs.home
+	err := filepath.WalkDir(dir, func(real string, d fs.DirEntry, err error) error {
+		if ctxErr := w.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		path := shown
+		if real != dir {
+			rel, relErr := filepath.Rel(dir, real)
+			if relErr != nil {
+				return relErr
+			}
+			path = filepath.Join(shown, rel)
+		}
+		if err != nil {
+			// Unreadable entries are skipped rather than aborting the scan
+			w.skip(path, fmt.Sprintf("unreadable: %v", err))
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == shown {
+			w.enterDir(path)
+			return nil
+		}
+		if isHome && filepath.Dir(path) == shown && !strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if w.fs.ignore != nil {
+			if result := w.fs.ignore.Match(path, d.IsDir()); result.Ignored {
+				w.skip(path, "ignored by "+result.Rule.String())
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+		if d.IsDir() {
+			w.enterDir(path)
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 && w.followsDir(path) {
+			return w.followDir(path)
+		}
+		if w.seen[path] {
+			return nil
+		}
+		w.seen[path] = true
+		return w.submit(job{path: path, entry: d})
+	})
+	if err != nil {
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+		return fmt.Errorf("error scanning %s: %w", shown, err)
+	}
+	return nil
+}
+
+// followsDir reports whether path is a link to a directory that should be
+// walked as if it were the directory: one whose policy is follow, or one
+// into a directory dotback manages that is not skipped
+func (w *walker) followsDir(path string) bool {
+	switch w.fs.symlinks.policy(path) {
+	case SymlinkFollow:
+	case SymlinkSkip:
+		return false
+	default:
+		target, err := os.Readlink(path)
+		if err != nil || w.fs.managedDir(path, target) == "" {
+			return false
+		}
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// followDir walks the directory a link points to, reporting its entries
+// under the link. Links back into a directory being walked are loops.
+func (w *walker) followDir(link string) error {
+	resolved, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		w.skip(link, fmt.Sprintf("unreadable: %v", err))
+		return nil
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(link))
+	if err != nil {
+		parent = filepath.Dir(link)
+	}
+	if w.following[resolved] || inside(resolved, parent) {
+		w.skip(link, "symlink loop")
+		return nil
+	}
+
+	w.following[resolved] = true
+	defer delete(w.following, resolved)
+	return w.walkTree(resolved, link)
+}
+
+// submit queues a job, giving up if the walk is cancelled
+func (w *walker) submit(j job) error {
+	select {
+	case w.jobs <- j:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// inspect runs on a worker goroutine
+func (w *walker) inspect(j job) {
+	if w.ctx.Err() != nil {
+		return
+	}
+
+	info := j.info
+	if info == nil {
+		var err error
+		if info, err = j.entry.Info(); err != nil {
+			w.skip(j.path, fmt.Sprintf("unreadable: %v", err))
+			return
+		}
+	}
+
+	file, reason, err := w.fs.inspect(j.path, info)
+	switch {
+	case err != nil:
+		w.skip(j.path, fmt.Sprintf("unreadable: %v", err))
+	case reason != "":
+		w.skip(j.path, reason)
+	default:
+		hashed := int64(0)
+		if !file.IsSymlink {
+			hashed = info.Size()
+		}
+		w.emit(file, hashed)
+	}
+}
+
+func (w *walker) emit(file types.DotFile, hashed int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return
+	}
+	if err := w.fn(file); err != nil {
+		w.err = err
+		w.cancel()
+		return
+	}
+	w.progress.FilesSeen++
+	w.progress.BytesHashed += hashed
+	w.report()
+}
+
+func (w *walker) skip(path, reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	logger.Debug("Skipping %s: %s", path, reason)
+	if w.fs.onSkip != nil && !w.quiet {
+		w.fs.onSkip(Skip{Path: path, Reason: reason})
+	}
+	w.progress.FilesSeen++
+	w.report()
+}
+
+func (w *walker) enterDir(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.progress.CurrentDir = dir
+	w.report()
+}
+
+// report must be called with mu held
+func (w *walker) report() {
+	if w.fs.onProgress != nil && !w.quiet {
+		w.fs.onProgress(w.progress)
+	}
+}
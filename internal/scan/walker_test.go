@@ -0,0 +1,130 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amroessam/dotback/internal/common/types"
+)
+
+// addFiles creates count small files under dir inside the home directory
+func addFiles(t *testing.T, home, dir string, count int) {
+	full := filepath.Join(home, dir)
+	if err := os.MkdirAll(full, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		if err := os.WriteFile(filepath.Join(full, fmt.Sprintf("file%03d", i)), []byte(fmt.Sprintf("content %d\n", i)), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func TestWalkConcurrent(t *testing.T) {
+	home := setupHome(t)
+	addFiles(t, home, ".many", 200)
+
+	sequential, err := NewFileSystem(Options{Workers: 1})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	want, err := sequential.FindDotFiles(nil)
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+
+	var events []Progress
+	parallel, err := NewFileSystem(Options{
+		Workers:    8,
+		OnProgress: func(progress Progress) { events = append(events, progress) },
+	})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+	got, err := parallel.FindDotFiles(nil)
+	if err != nil {
+		t.Fatalf("FindDotFiles() error = %v", err)
+	}
+
+	if len(got) != len(want) || len(got) != 205 {
+		t.Fatalf("FindDotFiles() returned %d files, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || got[i].Hash != want[i].Hash {
+			t.Errorf("files[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if len(events) == 0 {
+		t.Fatal("Expected progress events")
+	}
+	last := events[len(events)-1]
+	if last.FilesSeen != 205 {
+		t.Errorf("FilesSeen = %d, want 205", last.FilesSeen)
+	}
+	if last.BytesHashed == 0 {
+		t.Error("BytesHashed = 0")
+	}
+	sawDir := false
+	for _, event := range events {
+		if event.CurrentDir == filepath.Join(home, ".many") {
+			sawDir = true
+		}
+	}
+	if !sawDir {
+		t.Error("Expected a progress event for the .many directory")
+	}
+}
+
+func TestWalkCancelled(t *testing.T) {
+	home := setupHome(t)
+	addFiles(t, home, ".many", 50)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fs, err := NewFileSystem(Options{Context: ctx, Workers: 2})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	count := 0
+	err = fs.Walk(nil, func(file types.DotFile) error {
+		count++
+		if count == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Walk() error = %v, want context.Canceled", err)
+	}
+	if count >= 55 {
+		t.Errorf("Walk() visited %d files after cancellation", count)
+	}
+}
+
+func TestWalkCallbackError(t *testing.T) {
+	home := setupHome(t)
+	addFiles(t, home, ".many", 50)
+
+	fs, err := NewFileSystem(Options{Workers: 4})
+	if err != nil {
+		t.Fatalf("NewFileSystem() error = %v", err)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = fs.Walk(nil, func(file types.DotFile) error {
+		count++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Walk() error = %v, want %v", err, stop)
+	}
+	if count != 1 {
+		t.Errorf("Callback called %d times after returning an error", count)
+	}
+}